@@ -0,0 +1,195 @@
+// Package accesslog writes a record of each connection on close, in
+// JSON lines or a custom template, to a file rotated by size and time.
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/tun2socks/v2/log"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/statistic"
+)
+
+// FormatJSON is the format of JSON lines, which is the default.
+const FormatJSON = "json"
+
+// queueSize is the number of entries waiting to be written, beyond which
+// they are dropped.
+const queueSize = 1024
+
+// Entry is the record of a connection in the access log.
+type Entry struct {
+	Time  time.Time `json:"time"`
+	Start time.Time `json:"start"`
+	// Duration is in milliseconds.
+	Duration    int64  `json:"duration"`
+	ID          string `json:"id"`
+	Network     string `json:"network"`
+	Device      string `json:"device,omitempty"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Dialer      string `json:"dialer,omitempty"`
+	Proxy       string `json:"proxy,omitempty"`
+	Upload      int64  `json:"upload"`
+	Download    int64  `json:"download"`
+	// Outcome is the reason why the connection is closed, e.g.
+	// eof, timeout, killed or dial error.
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// NewEntry returns the Entry of the closed connection c.
+func NewEntry(c statistic.ClosedConnection) *Entry {
+	e := &Entry{
+		Time:     c.End,
+		Start:    c.Start,
+		Duration: c.Duration,
+		ID:       c.ID,
+		Upload:   c.Upload,
+		Download: c.Download,
+		Outcome:  c.Reason,
+		Error:    c.Error,
+	}
+	if md := c.Metadata; md != nil {
+		e.Network = md.Network.String()
+		e.Device = md.Device
+		e.Source = md.SourceAddress()
+		e.Destination = md.DestinationAddress()
+		e.Proxy = md.Proxy
+		if md.MidIP.IsValid() {
+			e.Dialer = netip.AddrPortFrom(md.MidIP, md.MidPort).String()
+		}
+	}
+	return e
+}
+
+// Config is the configuration of a Logger.
+type Config struct {
+	// Path is the file the access log is written to.
+	Path string
+
+	// Format is either FormatJSON or a text/template of Entry,
+	// e.g. "{{.Time}} {{.Source}} -> {{.Destination}} {{.Outcome}}".
+	// Lines are terminated with newline.
+	Format string
+
+	// MaxSize is the size of the file in bytes, over which it's
+	// rotated. It's not rotated by size if zero.
+	MaxSize int64
+
+	// RotateInterval is how often the file is rotated. It's not
+	// rotated by time if zero.
+	RotateInterval time.Duration
+
+	// MaxBackups and MaxAge are the number and age of rotated files
+	// to retain, each of which is unlimited if zero.
+	MaxBackups int
+	MaxAge     time.Duration
+}
+
+// Logger writes an Entry of each connection closed to a file, in the
+// background, so that closing connections never waits for the file.
+type Logger struct {
+	tmpl *template.Template
+	w    *rotateWriter
+	buf  bytes.Buffer
+
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan statistic.ClosedConnection
+	done    chan struct{}
+	dropped *atomic.Uint64
+}
+
+// New returns a Logger of cfg, which opens the file.
+func New(cfg Config) (*Logger, error) {
+	l := &Logger{
+		queue:   make(chan statistic.ClosedConnection, queueSize),
+		done:    make(chan struct{}),
+		dropped: atomic.NewUint64(0),
+	}
+	if cfg.Format != "" && cfg.Format != FormatJSON {
+		tmpl, err := template.New("accesslog").Parse(cfg.Format)
+		if err != nil {
+			return nil, err
+		}
+		l.tmpl = tmpl
+	}
+
+	w, err := newRotateWriter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	l.w = w
+
+	go l.run(cfg.Path)
+	return l, nil
+}
+
+// Log queues the Entry of the closed connection c to be written, which
+// is dropped if the queue is full. It has the same signature as the
+// closed handler of statistic.Manager.
+func (l *Logger) Log(c statistic.ClosedConnection) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		return
+	}
+	select {
+	case l.queue <- c:
+	default:
+		l.dropped.Inc()
+	}
+}
+
+func (l *Logger) run(path string) {
+	defer close(l.done)
+	for c := range l.queue {
+		if err := l.write(c); err != nil {
+			log.Warnf("[ACCESS] write %s: %v", path, err)
+		}
+		if n := l.dropped.Swap(0); n > 0 {
+			log.Warnf("[ACCESS] %d entries dropped: queue is full", n)
+		}
+	}
+}
+
+func (l *Logger) write(c statistic.ClosedConnection) error {
+	l.buf.Reset()
+	e := NewEntry(c)
+	if l.tmpl != nil {
+		if err := l.tmpl.Execute(&l.buf, e); err != nil {
+			return err
+		}
+		if !strings.HasSuffix(l.buf.String(), "\n") {
+			l.buf.WriteByte('\n')
+		}
+	} else if err := json.NewEncoder(&l.buf).Encode(e); err != nil {
+		return err
+	}
+	_, err := l.w.Write(l.buf.Bytes())
+	return err
+}
+
+// Close writes the entries queued and closes the file.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.queue)
+	l.mu.Unlock()
+
+	<-l.done
+	return l.w.Close()
+}
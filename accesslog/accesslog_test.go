@@ -0,0 +1,135 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/statistic"
+)
+
+func closedConnection() statistic.ClosedConnection {
+	end := time.Now()
+	return statistic.ClosedConnection{
+		ID: "id",
+		Metadata: &M.Metadata{
+			Network: M.TCP,
+			SrcIP:   netip.MustParseAddr("10.0.0.2"),
+			SrcPort: 50000,
+			DstIP:   netip.MustParseAddr("203.0.113.1"),
+			DstPort: 443,
+			Proxy:   "socks5://127.0.0.1:1080",
+		},
+		Start:    end.Add(-time.Second),
+		End:      end,
+		Duration: 1000,
+		Upload:   100,
+		Download: 200,
+		Reason:   statistic.ReasonEOF,
+	}
+}
+
+func TestJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := New(Config{Path: path})
+	require.NoError(t, err)
+	l.Log(closedConnection())
+	require.NoError(t, l.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var e Entry
+	require.NoError(t, json.Unmarshal(data, &e))
+	assert.Equal(t, "10.0.0.2:50000", e.Source)
+	assert.Equal(t, "203.0.113.1:443", e.Destination)
+	assert.Equal(t, "socks5://127.0.0.1:1080", e.Proxy)
+	assert.Equal(t, int64(100), e.Upload)
+	assert.Equal(t, statistic.ReasonEOF, e.Outcome)
+}
+
+func TestTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := New(Config{Path: path, Format: "{{.Source}} -> {{.Destination}} {{.Outcome}}"})
+	require.NoError(t, err)
+	l.Log(closedConnection())
+	require.NoError(t, l.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2:50000 -> 203.0.113.1:443 eof\n", string(data))
+
+	_, err = New(Config{Path: path, Format: "{{.Source"})
+	assert.Error(t, err)
+}
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	l, err := New(Config{Path: path, MaxSize: 1, MaxBackups: 2})
+	require.NoError(t, err)
+	for range 5 {
+		l.Log(closedConnection())
+	}
+	require.NoError(t, l.Close())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var backups int
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "access-") {
+			backups++
+		}
+	}
+	// Each entry is written to a file of its own, the oldest of
+	// which are removed.
+	assert.Equal(t, 2, backups)
+	assert.Len(t, entries, 3)
+}
+
+func TestRotateFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	w, err := newRotateWriter(Config{Path: path, MaxSize: 1})
+	require.NoError(t, err)
+	defer w.Close()
+
+	_, err = w.Write([]byte("a\n"))
+	require.NoError(t, err)
+
+	// The file fails to be renamed, and is written to a new one.
+	require.NoError(t, os.Remove(path))
+	_, err = w.Write([]byte("b\n"))
+	assert.Error(t, err)
+
+	// It's still written to, and rotated.
+	_, err = w.Write([]byte("c\n"))
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "c\n", string(data))
+}
+
+func TestClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := New(Config{Path: path})
+	require.NoError(t, err)
+
+	// The entries queued are written on close, and dropped after.
+	for range 10 {
+		l.Log(closedConnection())
+	}
+	require.NoError(t, l.Close())
+	l.Log(closedConnection())
+	require.NoError(t, l.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 10, strings.Count(string(data), "\n"))
+}
@@ -0,0 +1,171 @@
+package accesslog
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp of rotated files, which sorts in
+// time order.
+const backupTimeFormat = "20060102T150405.000000"
+
+// rotateWriter is a file writer, which renames the file with the time
+// of rotation, e.g. access-20060102T150405.000000.log, and starts a new
+// one when it's over the size or the interval.
+type rotateWriter struct {
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+	maxAge     time.Duration
+
+	mu     sync.Mutex
+	closed bool
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func newRotateWriter(cfg Config) (*rotateWriter, error) {
+	w := &rotateWriter{
+		path:       cfg.Path,
+		maxSize:    cfg.MaxSize,
+		interval:   cfg.RotateInterval,
+		maxBackups: cfg.MaxBackups,
+		maxAge:     cfg.MaxAge,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotateWriter) open() error {
+	if dir := filepath.Dir(w.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size, w.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	// The file may have failed to be reopened on rotation.
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	var rotateErr error
+	if w.size > 0 && (w.maxSize > 0 && w.size+int64(len(p)) > w.maxSize ||
+		w.interval > 0 && time.Since(w.opened) >= w.interval) {
+		if rotateErr = w.rotate(); w.file == nil {
+			return 0, rotateErr
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// rotate renames the file and opens a new one. The file is reopened even
+// if it fails to be renamed, so that it's still written to and rotated
+// again later.
+func (w *rotateWriter) rotate() error {
+	closeErr := w.file.Close()
+	w.file = nil
+
+	ext := filepath.Ext(w.path)
+	backup := strings.TrimSuffix(w.path, ext) + "-" + time.Now().Format(backupTimeFormat) + ext
+	renameErr := os.Rename(w.path, backup)
+	if err := w.open(); err != nil {
+		return err
+	}
+	if err := errors.Join(closeErr, renameErr); err != nil {
+		return err
+	}
+	w.removeBackups()
+	return nil
+}
+
+// removeBackups removes the rotated files beyond the retention.
+func (w *rotateWriter) removeBackups() {
+	if w.maxBackups <= 0 && w.maxAge <= 0 {
+		return
+	}
+
+	dir, base := filepath.Split(w.path)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return
+	}
+	type backup struct {
+		name string
+		time time.Time
+	}
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		ts := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		t, err := time.ParseInLocation(backupTimeFormat, ts, time.Local)
+		if err != nil {
+			continue /* not a backup */
+		}
+		backups = append(backups, backup{name, t})
+	}
+	// The newest come first.
+	slices.SortFunc(backups, func(a, b backup) int {
+		return b.time.Compare(a.time)
+	})
+
+	for i, b := range backups {
+		if w.maxBackups > 0 && i >= w.maxBackups || w.maxAge > 0 && time.Since(b.time) > w.maxAge {
+			_ = os.Remove(filepath.Join(dir, b.name))
+		}
+	}
+}
+
+func (w *rotateWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -2,7 +2,9 @@ package adapter
 
 import (
 	"net"
+	"net/netip"
 
+	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
@@ -13,6 +15,14 @@ type TCPConn interface {
 
 	// ID returns the transport endpoint ID.
 	ID() stack.TransportEndpointID
+
+	// Device returns the name of the device
+	// the connection came in on.
+	Device() string
+
+	// NICID returns the ID of the NIC
+	// the connection came in on.
+	NICID() tcpip.NICID
 }
 
 // UDPConn represents a UDP connection that implements both net.Conn
@@ -23,8 +33,38 @@ type UDPConn interface {
 
 	// ID returns the transport endpoint ID.
 	ID() stack.TransportEndpointID
+
+	// Device returns the name of the device
+	// the connection came in on.
+	Device() string
+
+	// NICID returns the ID of the NIC
+	// the connection came in on.
+	NICID() tcpip.NICID
+
+	// WriteFrom writes a datagram back to the remote end of the
+	// connection, using from as its source address instead of
+	// the local address of the connection.
+	WriteFrom(b []byte, from netip.AddrPort) (int, error)
+
+	// WriteUnreachable sends an ICMP destination unreachable error
+	// for the connection back to its source, with the given code.
+	WriteUnreachable(code UnreachableCode) error
 }
 
+// UnreachableCode is the code of ICMP destination unreachable errors.
+type UnreachableCode uint8
+
+const (
+	// PortUnreachable indicates that there is no service on the
+	// destination port, e.g. dialing the destination failed.
+	PortUnreachable UnreachableCode = iota
+
+	// AdminProhibited indicates that communication with the
+	// destination is administratively prohibited by policy.
+	AdminProhibited
+)
+
 // Packet represents a generic network packet delivered to a network
 // handler. It provides access to the underlying packet buffer, the
 // owning network stack, and the associated stack.TransportEndpointID.
@@ -37,4 +77,8 @@ type Packet interface {
 
 	// ID returns the transport endpoint ID.
 	ID() stack.TransportEndpointID
+
+	// WriteBack writes an ICMP message back to the source of this packet,
+	// from the destination of it. The checksum is filled in by the stack.
+	WriteBack(icmp []byte) error
 }
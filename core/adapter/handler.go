@@ -1,5 +1,10 @@
 package adapter
 
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
 // TransportHandler is a TCP/UDP connection handler that implements
 // HandleTCP and HandleUDP methods.
 type TransportHandler interface {
@@ -7,6 +12,25 @@ type TransportHandler interface {
 	HandleUDP(UDPConn)
 }
 
+// Drainer is implemented by the TransportHandler which may stop accepting
+// new connections, e.g. when it's draining on shutdown. The connections
+// refused are reset for TCP, and replied with ICMP port unreachable for UDP.
+type Drainer interface {
+	Draining() bool
+}
+
+// Limiter is implemented by the TransportHandler which limits the
+// connections, e.g. of each source address. A connection admitted is
+// counted until it's handled, and must be released if it fails to be
+// set up before being handed over. The connections are told apart by
+// the NIC they come in on as well, as those of different devices may
+// share the same transport endpoint ID. The connections refused are
+// reset for TCP, and dropped for UDP.
+type Limiter interface {
+	Admit(tcpip.NICID, tcpip.TransportProtocolNumber, stack.TransportEndpointID) bool
+	Release(tcpip.NICID, tcpip.TransportProtocolNumber, stack.TransportEndpointID)
+}
+
 // NetworkHandler is a L3/network packet handler that implements
 // HandlePacket method.
 type NetworkHandler interface {
@@ -0,0 +1,102 @@
+// Package capture provides packet capture of devices in pcap and pcapng
+// formats, in the way of the sniffer of gVisor.
+package capture
+
+import (
+	"sync"
+
+	"go.uber.org/atomic"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/link/nested"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+
+	"github.com/xjasonlyu/tun2socks/v2/core/device"
+)
+
+// Direction is the direction of captured packets.
+type Direction uint8
+
+const (
+	// Inbound packets are read from the device.
+	Inbound Direction = iota + 1
+	// Outbound packets are written to the device.
+	Outbound
+)
+
+var _ device.Device = (*Endpoint)(nil)
+
+// Endpoint wraps a device, and captures packets passing through it
+// to the sessions started. It costs no more than an atomic load per
+// packet if there are no sessions.
+type Endpoint struct {
+	nested.Endpoint
+
+	device device.Device
+
+	mu       sync.Mutex
+	sessions atomic.Pointer[[]*Session]
+}
+
+// Wrap wraps d with an Endpoint.
+func Wrap(d device.Device) *Endpoint {
+	e := &Endpoint{device: d}
+	e.sessions.Store(&[]*Session{})
+	e.Endpoint.Init(d, e)
+	return e
+}
+
+// Name returns the name of the wrapped device.
+func (e *Endpoint) Name() string {
+	return e.device.Name()
+}
+
+// Type returns the type of the wrapped device.
+func (e *Endpoint) Type() string {
+	return e.device.Type()
+}
+
+// Device returns the wrapped device.
+func (e *Endpoint) Device() device.Device {
+	return e.device
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher.
+func (e *Endpoint) DeliverNetworkPacket(protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	e.capture(Inbound, pkt)
+	e.Endpoint.DeliverNetworkPacket(protocol, pkt)
+}
+
+// WritePackets implements stack.LinkEndpoint.
+func (e *Endpoint) WritePackets(pkts stack.PacketBufferList) (int, tcpip.Error) {
+	if len(*e.sessions.Load()) > 0 {
+		for _, pkt := range pkts.AsSlice() {
+			e.capture(Outbound, pkt)
+		}
+	}
+	return e.Endpoint.WritePackets(pkts)
+}
+
+func (e *Endpoint) capture(dir Direction, pkt *stack.PacketBuffer) {
+	for _, s := range *e.sessions.Load() {
+		s.capture(e, dir, pkt)
+	}
+}
+
+func (e *Endpoint) addSession(s *Session) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	sessions := append(append([]*Session(nil), *e.sessions.Load()...), s)
+	e.sessions.Store(&sessions)
+}
+
+func (e *Endpoint) removeSession(s *Session) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var sessions []*Session
+	for _, ss := range *e.sessions.Load() {
+		if ss != s {
+			sessions = append(sessions, ss)
+		}
+	}
+	e.sessions.Store(&sessions)
+}
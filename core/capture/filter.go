@@ -0,0 +1,250 @@
+package capture
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// Filter selects packets to capture. It is a subset of the BPF filter
+// syntax of tcpdump, with primitives joined by "and":
+//
+//	ip | ip6                       network protocol
+//	tcp | udp | icmp               transport protocol
+//	[src|dst] host ADDR            source and/or destination address
+//	[src|dst] net CIDR             source and/or destination network
+//	[src|dst] port PORT            source and/or destination port
+//
+// Each primitive can be negated by "not". An empty filter selects
+// all packets.
+type Filter struct {
+	expr  string
+	terms []term
+}
+
+type termKind uint8
+
+const (
+	termIPVersion termKind = iota
+	termProtocol
+	termNet
+	termPort
+)
+
+// qualifier is the direction qualifier of host, net and port.
+type qualifier uint8
+
+const (
+	qualAny qualifier = iota
+	qualSrc
+	qualDst
+)
+
+type term struct {
+	not   bool
+	kind  termKind
+	qual  qualifier
+	value int
+	net   netip.Prefix
+}
+
+// ParseFilter parses the filter expression s.
+func ParseFilter(s string) (*Filter, error) {
+	f := &Filter{expr: s}
+	tokens := strings.Fields(strings.ToLower(s))
+
+	next := func() (string, bool) {
+		if len(tokens) == 0 {
+			return "", false
+		}
+		tok := tokens[0]
+		tokens = tokens[1:]
+		return tok, true
+	}
+
+	for len(tokens) > 0 {
+		var t term
+		tok, _ := next()
+		if tok == "not" || tok == "!" {
+			t.not = true
+			if tok, _ = next(); tok == "" {
+				return nil, fmt.Errorf("missing primitive after not")
+			}
+		}
+		switch tok {
+		case "src":
+			t.qual = qualSrc
+			tok, _ = next()
+		case "dst":
+			t.qual = qualDst
+			tok, _ = next()
+		}
+
+		switch tok {
+		case "ip":
+			t.kind, t.value = termIPVersion, header.IPv4Version
+		case "ip6":
+			t.kind, t.value = termIPVersion, header.IPv6Version
+		case "tcp":
+			t.kind, t.value = termProtocol, int(header.TCPProtocolNumber)
+		case "udp":
+			t.kind, t.value = termProtocol, int(header.UDPProtocolNumber)
+		case "icmp":
+			// Both ICMPv4 and ICMPv6 are matched by icmp.
+			t.kind, t.value = termProtocol, int(header.ICMPv4ProtocolNumber)
+		case "host", "net":
+			arg, ok := next()
+			if !ok {
+				return nil, fmt.Errorf("missing address after %s", tok)
+			}
+			p, err := parseNet(arg)
+			if err != nil {
+				return nil, err
+			}
+			t.kind, t.net = termNet, p
+		case "port":
+			arg, ok := next()
+			if !ok {
+				return nil, fmt.Errorf("missing port after %s", tok)
+			}
+			port, err := strconv.ParseUint(arg, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port: %s", arg)
+			}
+			t.kind, t.value = termPort, int(port)
+		default:
+			return nil, fmt.Errorf("invalid primitive: %q", tok)
+		}
+
+		if t.qual != qualAny && t.kind != termNet && t.kind != termPort {
+			return nil, fmt.Errorf("src/dst does not apply to %s", tok)
+		}
+		f.terms = append(f.terms, t)
+
+		if tok, ok := next(); ok && tok != "and" && tok != "&&" {
+			return nil, fmt.Errorf("expected and, got %q", tok)
+		} else if ok && len(tokens) == 0 {
+			return nil, fmt.Errorf("missing primitive after %s", tok)
+		}
+	}
+	return f, nil
+}
+
+func parseNet(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// String returns the filter expression.
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// packetInfo is the information of a packet matched by filters.
+type packetInfo struct {
+	version          int
+	protocol         int
+	src, dst         netip.Addr
+	srcPort, dstPort int
+	hasPorts         bool
+}
+
+func parsePacket(b []byte) (info packetInfo, ok bool) {
+	var transport []byte
+	switch header.IPVersion(b) {
+	case header.IPv4Version:
+		if len(b) < header.IPv4MinimumSize {
+			return info, false
+		}
+		ip := header.IPv4(b)
+		info.version = header.IPv4Version
+		info.protocol = int(ip.Protocol())
+		info.src = netip.AddrFrom4(ip.SourceAddress().As4())
+		info.dst = netip.AddrFrom4(ip.DestinationAddress().As4())
+		if hlen := int(ip.HeaderLength()); ip.FragmentOffset() == 0 && hlen <= len(b) {
+			transport = b[hlen:]
+		}
+	case header.IPv6Version:
+		if len(b) < header.IPv6MinimumSize {
+			return info, false
+		}
+		ip := header.IPv6(b)
+		info.version = header.IPv6Version
+		info.protocol = int(ip.NextHeader())
+		if info.protocol == int(header.ICMPv6ProtocolNumber) {
+			info.protocol = int(header.ICMPv4ProtocolNumber)
+		}
+		info.src = netip.AddrFrom16(ip.SourceAddress().As16())
+		info.dst = netip.AddrFrom16(ip.DestinationAddress().As16())
+		transport = b[header.IPv6MinimumSize:]
+	default:
+		return info, false
+	}
+
+	switch info.protocol {
+	case int(header.TCPProtocolNumber), int(header.UDPProtocolNumber):
+		// Source and destination ports are at the same
+		// offsets of both TCP and UDP headers.
+		if len(transport) >= 4 {
+			info.srcPort = int(header.UDP(transport).SourcePort())
+			info.dstPort = int(header.UDP(transport).DestinationPort())
+			info.hasPorts = true
+		}
+	}
+	return info, true
+}
+
+// match reports whether the IP packet b is selected by f.
+func (f *Filter) match(b []byte) bool {
+	if f == nil || len(f.terms) == 0 {
+		return true
+	}
+	info, ok := parsePacket(b)
+	if !ok {
+		return false
+	}
+	for _, t := range f.terms {
+		if t.match(&info) == t.not {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *term) match(info *packetInfo) bool {
+	switch t.kind {
+	case termIPVersion:
+		return info.version == t.value
+	case termProtocol:
+		return info.protocol == t.value
+	case termNet:
+		return t.qualify(t.net.Contains(info.src), t.net.Contains(info.dst))
+	case termPort:
+		return info.hasPorts && t.qualify(info.srcPort == t.value, info.dstPort == t.value)
+	}
+	return false
+}
+
+func (t *term) qualify(src, dst bool) bool {
+	switch t.qual {
+	case qualSrc:
+		return src
+	case qualDst:
+		return dst
+	default:
+		return src || dst
+	}
+}
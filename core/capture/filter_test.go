@@ -0,0 +1,72 @@
+package capture
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func newUDPPacket(src, dst [4]byte, srcPort, dstPort uint16) []byte {
+	b := make([]byte, header.IPv4MinimumSize+header.UDPMinimumSize)
+	header.IPv4(b).Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(b)),
+		TTL:         64,
+		Protocol:    uint8(header.UDPProtocolNumber),
+		SrcAddr:     tcpip.AddrFrom4(src),
+		DstAddr:     tcpip.AddrFrom4(dst),
+	})
+	header.UDP(b[header.IPv4MinimumSize:]).Encode(&header.UDPFields{
+		SrcPort: srcPort,
+		DstPort: dstPort,
+		Length:  header.UDPMinimumSize,
+	})
+	return b
+}
+
+func TestFilter(t *testing.T) {
+	pkt := newUDPPacket([4]byte{10, 0, 0, 1}, [4]byte{1, 1, 1, 1}, 40000, 53)
+
+	for _, tt := range []struct {
+		expr  string
+		match bool
+	}{
+		{"", true},
+		{"ip", true},
+		{"ip6", false},
+		{"udp", true},
+		{"tcp", false},
+		{"not tcp", true},
+		{"port 53", true},
+		{"src port 53", false},
+		{"dst port 53", true},
+		{"host 1.1.1.1", true},
+		{"src host 1.1.1.1", false},
+		{"net 10.0.0.0/8", true},
+		{"dst net 10.0.0.0/8", false},
+		{"udp and port 53 and net 10.0.0.0/8", true},
+		{"udp && not port 53", false},
+	} {
+		f, err := ParseFilter(tt.expr)
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.match, f.match(pkt), tt.expr)
+	}
+}
+
+func TestParseFilterError(t *testing.T) {
+	for _, expr := range []string{
+		"foo",
+		"port",
+		"port http",
+		"host 1.1.1",
+		"src tcp",
+		"tcp or udp",
+		"tcp and",
+		"not",
+	} {
+		_, err := ParseFilter(expr)
+		assert.Error(t, err, expr)
+	}
+}
@@ -0,0 +1,206 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Format is the file format of captures.
+type Format uint8
+
+const (
+	// FormatPcapNG is the pcapng format, which records the interface
+	// and direction of packets.
+	FormatPcapNG Format = iota
+	// FormatPcap is the classic libpcap format.
+	FormatPcap
+)
+
+// ParseFormat parses the format name, which is either pcapng or pcap.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "", "pcapng":
+		return FormatPcapNG, nil
+	case "pcap":
+		return FormatPcap, nil
+	default:
+		return 0, fmt.Errorf("invalid capture format: %s", s)
+	}
+}
+
+func (f Format) String() string {
+	if f == FormatPcap {
+		return "pcap"
+	}
+	return "pcapng"
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	if f == FormatPcap {
+		return "application/vnd.tcpdump.pcap"
+	}
+	return "application/x-pcapng"
+}
+
+// _linkTypeRaw is LINKTYPE_RAW, packets beginning with an IPv4 or IPv6
+// header. Link headers of devices, e.g. TAP, are stripped on capture.
+const _linkTypeRaw = 101
+
+// interfaceInfo describes a captured interface.
+type interfaceInfo struct {
+	name    string
+	snapLen uint32
+}
+
+// record is a captured packet.
+type record struct {
+	ts      time.Time
+	ifIndex uint32
+	dir     Direction
+	data    []byte
+	origLen int
+}
+
+// formatWriter writes captures in a file format.
+type formatWriter interface {
+	// writeHeader writes the file header describing ifaces.
+	writeHeader(w io.Writer, ifaces []interfaceInfo) (int, error)
+	// writeRecord writes the captured packet r.
+	writeRecord(w io.Writer, r *record) (int, error)
+	// recordSize returns the size of records of n bytes captured.
+	recordSize(n int) int
+}
+
+func newFormatWriter(f Format) formatWriter {
+	if f == FormatPcap {
+		return pcapWriter{}
+	}
+	return pcapngWriter{}
+}
+
+// pcapWriter writes the libpcap format with microsecond timestamps.
+type pcapWriter struct{}
+
+func (pcapWriter) writeHeader(w io.Writer, ifaces []interfaceInfo) (int, error) {
+	var snapLen uint32
+	for _, iface := range ifaces {
+		snapLen = max(snapLen, iface.snapLen)
+	}
+
+	b := make([]byte, 24)
+	binary.LittleEndian.PutUint32(b[0:], 0xa1b2c3d4) // magic
+	binary.LittleEndian.PutUint16(b[4:], 2)          // major version
+	binary.LittleEndian.PutUint16(b[6:], 4)          // minor version
+	binary.LittleEndian.PutUint32(b[16:], snapLen)
+	binary.LittleEndian.PutUint32(b[20:], _linkTypeRaw)
+	return w.Write(b)
+}
+
+func (pcapWriter) writeRecord(w io.Writer, r *record) (int, error) {
+	b := make([]byte, 16, 16+len(r.data))
+	binary.LittleEndian.PutUint32(b[0:], uint32(r.ts.Unix()))
+	binary.LittleEndian.PutUint32(b[4:], uint32(r.ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(b[8:], uint32(len(r.data)))
+	binary.LittleEndian.PutUint32(b[12:], uint32(r.origLen))
+	return w.Write(append(b, r.data...))
+}
+
+func (pcapWriter) recordSize(n int) int {
+	return 16 + n
+}
+
+// pcapngWriter writes the pcapng format with one interface
+// description block per device.
+type pcapngWriter struct{}
+
+const (
+	_pcapngSectionHeader        = 0x0a0d0d0a
+	_pcapngInterfaceDescription = 0x00000001
+	_pcapngEnhancedPacket       = 0x00000006
+
+	_pcapngOptEnd       = 0
+	_pcapngOptIfName    = 2
+	_pcapngOptIfTSResol = 9
+	_pcapngOptEPBFlags  = 2
+)
+
+// pcapngBlock builds a pcapng block.
+type pcapngBlock []byte
+
+// newPcapngBlock returns a block of typ, followed by the
+// placeholder of length, which is filled by finish.
+func newPcapngBlock(typ uint32) pcapngBlock {
+	b := binary.LittleEndian.AppendUint32(make(pcapngBlock, 0, 64), typ)
+	return binary.LittleEndian.AppendUint32(b, 0)
+}
+
+func (b pcapngBlock) appendPadded(data []byte) pcapngBlock {
+	b = append(b, data...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func (b pcapngBlock) appendOption(code uint16, value []byte) pcapngBlock {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	return b.appendPadded(value)
+}
+
+// finish appends the trailing length, and fills the leading one.
+func (b pcapngBlock) finish() []byte {
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(len(b)))
+	return b
+}
+
+func (pcapngWriter) writeHeader(w io.Writer, ifaces []interfaceInfo) (int, error) {
+	shb := newPcapngBlock(_pcapngSectionHeader)
+	shb = binary.LittleEndian.AppendUint32(shb, 0x1a2b3c4d) // byte-order magic
+	shb = binary.LittleEndian.AppendUint16(shb, 1)          // major version
+	shb = binary.LittleEndian.AppendUint16(shb, 0)          // minor version
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0)) // section length, unspecified
+	buf := shb.finish()
+
+	for _, iface := range ifaces {
+		idb := newPcapngBlock(_pcapngInterfaceDescription)
+		idb = binary.LittleEndian.AppendUint16(idb, _linkTypeRaw)
+		idb = binary.LittleEndian.AppendUint16(idb, 0) // reserved
+		idb = binary.LittleEndian.AppendUint32(idb, iface.snapLen)
+		if iface.name != "" {
+			idb = idb.appendOption(_pcapngOptIfName, []byte(iface.name))
+		}
+		idb = idb.appendOption(_pcapngOptIfTSResol, []byte{9}) // nanoseconds
+		idb = idb.appendOption(_pcapngOptEnd, nil)
+		buf = append(buf, idb.finish()...)
+	}
+	return w.Write(buf)
+}
+
+func (pcapngWriter) writeRecord(w io.Writer, r *record) (int, error) {
+	ts := uint64(r.ts.UnixNano())
+	epb := newPcapngBlock(_pcapngEnhancedPacket)
+	epb = binary.LittleEndian.AppendUint32(epb, r.ifIndex)
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(r.data)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(r.origLen))
+	epb = epb.appendPadded(r.data)
+	// The lowest 2 bits of flags are the direction, 1 for
+	// inbound and 2 for outbound, the same as Direction.
+	epb = epb.appendOption(_pcapngOptEPBFlags, binary.LittleEndian.AppendUint32(nil, uint32(r.dir)))
+	epb = epb.appendOption(_pcapngOptEnd, nil)
+	return w.Write(epb.finish())
+}
+
+func (pcapngWriter) recordSize(n int) int {
+	// Block header of 28 bytes, padded data, flags option of
+	// 8 bytes, end of options and trailing length of 4 bytes.
+	return 28 + (n+3)&^3 + 8 + 4 + 4
+}
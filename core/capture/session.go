@@ -0,0 +1,238 @@
+package capture
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	// _defaultSnapLen is the default maximum bytes captured per packet,
+	// which is large enough for GSO packets.
+	_defaultSnapLen = 1 << 16
+
+	// _queueSize is the number of packets queued for the writer.
+	// Packets are dropped if the writer falls behind, rather than
+	// blocking the devices.
+	_queueSize = 1024
+)
+
+// ErrLimitReached is the reason of sessions stopped by the size
+// or time limit.
+var ErrLimitReached = errors.New("capture limit reached")
+
+// Options are the options of a capture session.
+type Options struct {
+	// Format is the file format of the capture.
+	Format Format
+
+	// Filter selects the packets to capture, all if nil.
+	Filter *Filter
+
+	// SnapLen is the maximum bytes captured per packet.
+	SnapLen uint32
+
+	// MaxSize is the maximum bytes written, unlimited if zero.
+	MaxSize int64
+
+	// Duration is the maximum duration of the capture, unlimited if zero.
+	Duration time.Duration
+}
+
+// Session is a capture session writing the packets of endpoints to w.
+type Session struct {
+	endpoints []*Endpoint
+	ifIndex   map[*Endpoint]uint32
+
+	w       io.Writer
+	format  formatWriter
+	filter  *Filter
+	snapLen uint32
+	maxSize int64
+
+	queue chan *record
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+	timer *time.Timer
+
+	// reserved is the bytes written and queued, which is
+	// checked against maxSize on capture.
+	reserved atomic.Int64
+	written  atomic.Int64
+	dropped  atomic.Uint64
+	err      error
+}
+
+// Start starts a capture session of endpoints, writing to w until
+// the session is stopped, the limits are reached, or w fails.
+func Start(w io.Writer, endpoints []*Endpoint, opts *Options) (*Session, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("no endpoint to capture")
+	}
+
+	s := &Session{
+		endpoints: endpoints,
+		ifIndex:   make(map[*Endpoint]uint32, len(endpoints)),
+		w:         w,
+		format:    newFormatWriter(opts.Format),
+		filter:    opts.Filter,
+		snapLen:   opts.SnapLen,
+		maxSize:   opts.MaxSize,
+		queue:     make(chan *record, _queueSize),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if s.snapLen == 0 {
+		s.snapLen = _defaultSnapLen
+	}
+
+	ifaces := make([]interfaceInfo, 0, len(endpoints))
+	for i, e := range endpoints {
+		s.ifIndex[e] = uint32(i)
+		ifaces = append(ifaces, interfaceInfo{name: e.Name(), snapLen: s.snapLen})
+	}
+	n, err := s.format.writeHeader(w, ifaces)
+	if err != nil {
+		return nil, err
+	}
+	s.written.Store(int64(n))
+	s.reserved.Store(int64(n))
+
+	go s.loop()
+	for _, e := range endpoints {
+		e.addSession(s)
+	}
+	if opts.Duration > 0 {
+		s.timer = time.AfterFunc(opts.Duration, func() {
+			s.close(ErrLimitReached)
+		})
+	}
+	return s, nil
+}
+
+// Done returns a channel closed when the session ends.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason why the session ended, nil if it is stopped
+// by Stop. It must be called after Done is closed.
+func (s *Session) Err() error {
+	return s.err
+}
+
+// Written returns the bytes written by the session.
+func (s *Session) Written() int64 {
+	return s.written.Load()
+}
+
+// Dropped returns the number of packets dropped since the writer
+// was too slow.
+func (s *Session) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Stop stops the session, and waits for queued packets to be written.
+func (s *Session) Stop() {
+	s.close(nil)
+	<-s.done
+}
+
+func (s *Session) close(err error) {
+	s.once.Do(func() {
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		for _, e := range s.endpoints {
+			e.removeSession(s)
+		}
+		s.err = err
+		close(s.stop)
+	})
+}
+
+func (s *Session) loop() {
+	defer close(s.done)
+
+	write := func(r *record) bool {
+		n, err := s.format.writeRecord(s.w, r)
+		s.written.Add(int64(n))
+		if err != nil {
+			s.close(err)
+			return false
+		}
+		return true
+	}
+
+	for {
+		select {
+		case r := <-s.queue:
+			if !write(r) {
+				return
+			}
+		case <-s.stop:
+			// Flush packets queued before the stop.
+			for {
+				select {
+				case r := <-s.queue:
+					if !write(r) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Session) capture(e *Endpoint, dir Direction, pkt *stack.PacketBuffer) {
+	// Link headers, e.g. ethernet of TAP, are stripped, since
+	// captures are of raw IP packets.
+	skip := len(pkt.LinkHeader().Slice())
+	origLen := pkt.Size() - skip
+	if origLen <= 0 {
+		return
+	}
+
+	data := make([]byte, 0, min(uint32(origLen), s.snapLen))
+	for _, v := range pkt.AsSlices() {
+		if skip >= len(v) {
+			skip -= len(v)
+			continue
+		}
+		v, skip = v[skip:], 0
+		data = append(data, v[:min(len(v), cap(data)-len(data))]...)
+		if len(data) == cap(data) {
+			break
+		}
+	}
+	if !s.filter.match(data) {
+		return
+	}
+
+	size := int64(s.format.recordSize(len(data)))
+	if s.maxSize > 0 && s.reserved.Add(size) > s.maxSize {
+		s.close(ErrLimitReached)
+		return
+	}
+
+	r := &record{
+		ts:      time.Now(),
+		ifIndex: s.ifIndex[e],
+		dir:     dir,
+		data:    data,
+		origLen: origLen,
+	}
+	select {
+	case s.queue <- r:
+	default:
+		s.reserved.Sub(size)
+		s.dropped.Inc()
+	}
+}
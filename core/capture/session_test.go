@@ -0,0 +1,94 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+type testDevice struct {
+	*channel.Endpoint
+}
+
+func (testDevice) Name() string { return "test0" }
+
+func (testDevice) Type() string { return "test" }
+
+func deliver(e *Endpoint, b []byte) {
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(b),
+	})
+	defer pkt.DecRef()
+	e.DeliverNetworkPacket(header.IPv4ProtocolNumber, pkt)
+}
+
+func TestSession(t *testing.T) {
+	e := Wrap(testDevice{channel.New(16, 1500, "")})
+	tcp := newUDPPacket([4]byte{10, 0, 0, 1}, [4]byte{1, 1, 1, 1}, 40000, 53)
+	tcp[9] = uint8(header.TCPProtocolNumber)
+	udp := newUDPPacket([4]byte{10, 0, 0, 1}, [4]byte{1, 1, 1, 1}, 40000, 53)
+
+	filter, err := ParseFilter("udp")
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	s, err := Start(buf, []*Endpoint{e}, &Options{
+		Format: FormatPcap,
+		Filter: filter,
+		// Header of 24 bytes and two records.
+		MaxSize: 24 + 2*(16+int64(len(udp))),
+	})
+	require.NoError(t, err)
+
+	deliver(e, udp)
+	deliver(e, tcp)
+	deliver(e, udp)
+	deliver(e, udp)
+
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("session is not stopped by size limit")
+	}
+	assert.ErrorIs(t, s.Err(), ErrLimitReached)
+
+	b := buf.Bytes()
+	require.Len(t, b, 24+2*(16+len(udp)))
+	assert.Equal(t, uint32(0xa1b2c3d4), binary.LittleEndian.Uint32(b))
+	assert.Equal(t, uint32(_linkTypeRaw), binary.LittleEndian.Uint32(b[20:]))
+	assert.Equal(t, udp, b[24+16:24+16+len(udp)])
+
+	// Packets are not captured after the session ends.
+	assert.Empty(t, *e.sessions.Load())
+}
+
+func TestPcapngBlocks(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := pcapngWriter{}
+	_, err := w.writeHeader(buf, []interfaceInfo{{name: "tun0", snapLen: 1500}})
+	require.NoError(t, err)
+	n, err := w.writeRecord(buf, &record{ts: time.Now(), dir: Outbound, data: []byte{0x45, 1, 2}, origLen: 3})
+	require.NoError(t, err)
+	assert.Equal(t, w.recordSize(3), n)
+
+	// Walk the blocks by the leading and trailing lengths.
+	var types []uint32
+	for b := buf.Bytes(); len(b) > 0; {
+		require.GreaterOrEqual(t, len(b), 12)
+		l := int(binary.LittleEndian.Uint32(b[4:]))
+		require.Zero(t, l%4)
+		require.LessOrEqual(t, l, len(b))
+		assert.Equal(t, uint32(l), binary.LittleEndian.Uint32(b[l-4:]))
+		types = append(types, binary.LittleEndian.Uint32(b))
+		b = b[l:]
+	}
+	assert.Equal(t, []uint32{_pcapngSectionHeader, _pcapngInterfaceDescription, _pcapngEnhancedPacket}, types)
+}
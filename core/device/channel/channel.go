@@ -0,0 +1,97 @@
+// Package channel provides Endpoint which implemented device.Device
+// interface in memory, exchanging raw IP packets with its user instead
+// of the kernel. It's mostly used to test the stack without root.
+package channel
+
+import (
+	"context"
+	"errors"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+
+	"github.com/xjasonlyu/tun2socks/v2/core/device"
+)
+
+const Driver = "channel"
+
+const (
+	defaultMTU = 1500
+
+	// Queue length for outbound packets, read by ReadPacket.
+	// Overflow causes packet drops.
+	defaultQueueLen = 1 << 10
+)
+
+// ErrClosed is returned by ReadPacket if the Endpoint is closed.
+var ErrClosed = errors.New("endpoint closed")
+
+var _ device.Device = (*Endpoint)(nil)
+
+type Endpoint struct {
+	*channel.Endpoint
+
+	name string
+}
+
+// New returns an Endpoint named name, with the default MTU if mtu is zero.
+func New(name string, mtu uint32) *Endpoint {
+	if mtu == 0 {
+		mtu = defaultMTU
+	}
+	return &Endpoint{
+		Endpoint: channel.New(defaultQueueLen, mtu, ""),
+		name:     name,
+	}
+}
+
+func (e *Endpoint) Name() string {
+	return e.name
+}
+
+func (e *Endpoint) Type() string {
+	return Driver
+}
+
+// Inject injects the raw IP packet b into the stack, as if it was read
+// from a device. The packet is dropped if the Endpoint is not attached.
+func (e *Endpoint) Inject(b []byte) error {
+	if len(b) == 0 {
+		return errors.New("empty packet")
+	}
+
+	var protocol = header.IPv4ProtocolNumber
+	switch header.IPVersion(b) {
+	case header.IPv4Version:
+	case header.IPv6Version:
+		protocol = header.IPv6ProtocolNumber
+	default:
+		return errors.New("invalid IP version")
+	}
+
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(append([]byte(nil), b...)),
+	})
+	defer pkt.DecRef()
+	e.InjectInbound(protocol, pkt)
+	return nil
+}
+
+// ReadPacket returns the next raw IP packet written by the stack,
+// blocking until there is one, ctx is done or the Endpoint is closed.
+func (e *Endpoint) ReadPacket(ctx context.Context) ([]byte, error) {
+	pkt := e.ReadContext(ctx)
+	if pkt == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrClosed
+	}
+	defer pkt.DecRef()
+
+	buf := pkt.ToBuffer()
+	defer buf.Release()
+	return buf.Flatten(), nil
+}
@@ -0,0 +1,361 @@
+package tap
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/netip"
+	"sync"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+
+	// dhcpLeaseTime is the lease time of addresses handed out.
+	dhcpLeaseTime = 12 * time.Hour
+
+	// Offsets of the fixed fields of a DHCP message (RFC 2131 section 2).
+	dhcpOpOffset     = 0
+	dhcpHTypeOffset  = 1
+	dhcpHLenOffset   = 2
+	dhcpXidOffset    = 4
+	dhcpCiaddrOffset = 12
+	dhcpYiaddrOffset = 16
+	dhcpSiaddrOffset = 20
+	dhcpGiaddrOffset = 24
+	dhcpChaddrOffset = 28
+	dhcpMagicOffset  = 236
+	dhcpMinimumSize  = 240
+
+	// dhcpReplySize is the minimum size of replies, as BOOTP requires.
+	dhcpReplySize = 300
+
+	dhcpMagic = 0x63825363
+
+	dhcpBootRequest = 1
+	dhcpBootReply   = 2
+)
+
+// DHCP message types (RFC 2132 section 9.6).
+const (
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpDecline  = 4
+	dhcpAck      = 5
+	dhcpNak      = 6
+	dhcpRelease  = 7
+	dhcpInform   = 8
+)
+
+// DHCP options (RFC 2132).
+const (
+	dhcpOptPad         = 0
+	dhcpOptSubnetMask  = 1
+	dhcpOptRouter      = 3
+	dhcpOptDNS         = 6
+	dhcpOptRequestedIP = 50
+	dhcpOptLeaseTime   = 51
+	dhcpOptMessageType = 53
+	dhcpOptServerID    = 54
+	dhcpOptEnd         = 255
+)
+
+// dhcpServer is a minimal DHCP server, which leases addresses of a
+// subnet to clients by their hardware address.
+type dhcpServer struct {
+	prefix netip.Prefix
+	router tcpip.Address
+	mask   tcpip.Address
+	dns    []tcpip.Address
+
+	mu     sync.Mutex
+	leases map[tcpip.LinkAddress]*dhcpLease
+	owners map[netip.Addr]tcpip.LinkAddress
+}
+
+type dhcpLease struct {
+	addr   netip.Addr
+	expiry time.Time
+}
+
+func newDHCPServer(cfg *DHCPConfig) (*dhcpServer, error) {
+	if !cfg.Prefix.Addr().Is4() {
+		return nil, errors.New("prefix must be IPv4")
+	}
+	if cfg.Prefix.Bits() > 30 {
+		return nil, errors.New("prefix too small")
+	}
+
+	prefix := cfg.Prefix.Masked()
+	router := cfg.Prefix.Addr()
+	if router == prefix.Addr() {
+		router = router.Next()
+	}
+
+	s := &dhcpServer{
+		prefix: prefix,
+		router: tcpip.AddrFrom4(router.As4()),
+		mask:   tcpip.AddrFromSlice(binary.BigEndian.AppendUint32(nil, ^uint32(0)<<(32-prefix.Bits()))),
+		leases: make(map[tcpip.LinkAddress]*dhcpLease),
+		owners: make(map[netip.Addr]tcpip.LinkAddress),
+	}
+
+	for _, addr := range cfg.DNS {
+		if !addr.Is4() {
+			return nil, errors.New("dns server must be IPv4")
+		}
+		s.dns = append(s.dns, tcpip.AddrFrom4(addr.As4()))
+	}
+	return s, nil
+}
+
+// handle handles the DHCP request message b, and returns the reply to it
+// with the IP address to send it to, or nil if it needs no reply.
+func (s *dhcpServer) handle(b []byte) (reply []byte, dst tcpip.Address) {
+	if len(b) < dhcpMinimumSize ||
+		b[dhcpOpOffset] != dhcpBootRequest ||
+		b[dhcpHTypeOffset] != 1 /* ethernet */ ||
+		b[dhcpHLenOffset] != header.EthernetAddressSize ||
+		binary.BigEndian.Uint32(b[dhcpMagicOffset:]) != dhcpMagic {
+		return nil, tcpip.Address{}
+	}
+	opts := parseDHCPOptions(b[dhcpMinimumSize:])
+	if len(opts[dhcpOptMessageType]) != 1 {
+		return nil, tcpip.Address{}
+	}
+
+	// Ignore requests to other servers.
+	if id, ok := opts[dhcpOptServerID]; ok && tcpip.AddrFromSlice(id) != s.router {
+		return nil, tcpip.Address{}
+	}
+
+	chaddr := tcpip.LinkAddress(b[dhcpChaddrOffset : dhcpChaddrOffset+header.EthernetAddressSize])
+	ciaddr, _ := netip.AddrFromSlice(b[dhcpCiaddrOffset : dhcpCiaddrOffset+4])
+
+	var (
+		msgType byte
+		yiaddr  netip.Addr
+	)
+	switch opts[dhcpOptMessageType][0] {
+	case dhcpDiscover:
+		requested, _ := netip.AddrFromSlice(opts[dhcpOptRequestedIP])
+		if yiaddr = s.lease(chaddr, requested); !yiaddr.IsValid() {
+			return nil, tcpip.Address{} /* pool exhausted */
+		}
+		msgType = dhcpOffer
+	case dhcpRequest:
+		requested, _ := netip.AddrFromSlice(opts[dhcpOptRequestedIP])
+		if !requested.IsValid() {
+			requested = ciaddr /* renewing or rebinding */
+		}
+		if yiaddr = s.lease(chaddr, requested); yiaddr.IsValid() && yiaddr == requested {
+			msgType = dhcpAck
+		} else {
+			msgType, yiaddr = dhcpNak, netip.Addr{}
+		}
+	case dhcpInform:
+		msgType = dhcpAck
+	case dhcpDecline, dhcpRelease:
+		s.release(chaddr)
+		return nil, tcpip.Address{}
+	default:
+		return nil, tcpip.Address{}
+	}
+
+	reply = make([]byte, dhcpMinimumSize, dhcpReplySize)
+	reply[dhcpOpOffset] = dhcpBootReply
+	copy(reply[dhcpHTypeOffset:dhcpXidOffset], b[dhcpHTypeOffset:dhcpXidOffset])
+	copy(reply[dhcpXidOffset:dhcpCiaddrOffset+4], b[dhcpXidOffset:dhcpCiaddrOffset+4])
+	if yiaddr.IsValid() {
+		copy(reply[dhcpYiaddrOffset:], yiaddr.AsSlice())
+	}
+	copy(reply[dhcpSiaddrOffset:], s.router.AsSlice())
+	copy(reply[dhcpGiaddrOffset:dhcpMagicOffset], b[dhcpGiaddrOffset:dhcpMagicOffset])
+	binary.BigEndian.PutUint32(reply[dhcpMagicOffset:], dhcpMagic)
+
+	reply = append(reply, dhcpOptMessageType, 1, msgType)
+	reply = append(reply, dhcpOptServerID, 4)
+	reply = append(reply, s.router.AsSlice()...)
+	if msgType != dhcpNak {
+		if msgType != dhcpAck || yiaddr.IsValid() {
+			reply = append(reply, dhcpOptLeaseTime, 4)
+			reply = binary.BigEndian.AppendUint32(reply, uint32(dhcpLeaseTime/time.Second))
+		}
+		reply = append(reply, dhcpOptSubnetMask, 4)
+		reply = append(reply, s.mask.AsSlice()...)
+		reply = append(reply, dhcpOptRouter, 4)
+		reply = append(reply, s.router.AsSlice()...)
+		if len(s.dns) > 0 {
+			reply = append(reply, dhcpOptDNS, byte(4*len(s.dns)))
+			for _, addr := range s.dns {
+				reply = append(reply, addr.AsSlice()...)
+			}
+		}
+	}
+	reply = append(reply, dhcpOptEnd)
+	for len(reply) < dhcpReplySize {
+		reply = append(reply, dhcpOptPad)
+	}
+
+	// As per RFC 2131 section 4.1, reply to the client address if it has
+	// one, and broadcast otherwise, which every client is able to receive.
+	dst = header.IPv4Broadcast
+	if ciaddr.IsValid() && !ciaddr.IsUnspecified() && msgType != dhcpNak {
+		dst = tcpip.AddrFrom4(ciaddr.As4())
+	}
+	return reply, dst
+}
+
+// lease returns the address leased to chaddr, trying the requested
+// address for a new lease. It returns an invalid address if there is
+// no address left in the subnet.
+func (s *dhcpServer) lease(chaddr tcpip.LinkAddress, requested netip.Addr) netip.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if l, ok := s.leases[chaddr]; ok {
+		l.expiry = now.Add(dhcpLeaseTime)
+		return l.addr
+	}
+
+	available := func(addr netip.Addr) bool {
+		if !s.prefix.Contains(addr) || addr == s.prefix.Addr() ||
+			addr == netip.AddrFrom4(s.router.As4()) || addr == s.broadcast() {
+			return false
+		}
+		owner, ok := s.owners[addr]
+		if !ok {
+			return true
+		}
+		if l := s.leases[owner]; l.expiry.Before(now) {
+			delete(s.leases, owner)
+			delete(s.owners, addr)
+			return true
+		}
+		return false
+	}
+
+	addr := requested
+	if !available(addr) {
+		for addr = s.prefix.Addr().Next(); s.prefix.Contains(addr); addr = addr.Next() {
+			if available(addr) {
+				break
+			}
+		}
+		if !s.prefix.Contains(addr) {
+			return netip.Addr{}
+		}
+	}
+	s.leases[chaddr] = &dhcpLease{addr: addr, expiry: now.Add(dhcpLeaseTime)}
+	s.owners[addr] = chaddr
+	return addr
+}
+
+func (s *dhcpServer) release(chaddr tcpip.LinkAddress) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if l, ok := s.leases[chaddr]; ok {
+		delete(s.owners, l.addr)
+		delete(s.leases, chaddr)
+	}
+}
+
+func (s *dhcpServer) broadcast() netip.Addr {
+	b := s.prefix.Addr().As4()
+	m := s.mask.As4()
+	for i := range b {
+		b[i] |= ^m[i]
+	}
+	return netip.AddrFrom4(b)
+}
+
+// parseDHCPOptions parses the DHCP options in b, skipping malformed ones.
+func parseDHCPOptions(b []byte) map[byte][]byte {
+	opts := make(map[byte][]byte)
+	for len(b) > 0 {
+		code := b[0]
+		if code == dhcpOptEnd {
+			break
+		}
+		if code == dhcpOptPad {
+			b = b[1:]
+			continue
+		}
+		if len(b) < 2 || len(b) < 2+int(b[1]) {
+			break
+		}
+		opts[code], b = b[2:2+int(b[1])], b[2+int(b[1]):]
+	}
+	return opts
+}
+
+// isDHCPRequest reports whether the IPv4 packet pkt is a UDP datagram
+// sent to the DHCP server port.
+func isDHCPRequest(pkt *stack.PacketBuffer) bool {
+	hdr, ok := pkt.Data().PullUp(header.IPv4MinimumSize)
+	if !ok {
+		return false
+	}
+	ip := header.IPv4(hdr)
+	hlen := int(ip.HeaderLength())
+	if ip.TransportProtocol() != header.UDPProtocolNumber || ip.More() || ip.FragmentOffset() != 0 ||
+		hlen < header.IPv4MinimumSize || int(ip.TotalLength()) < hlen+header.UDPMinimumSize ||
+		int(ip.TotalLength()) > pkt.Data().Size() {
+		return false
+	}
+	hdr, ok = pkt.Data().PullUp(hlen + header.UDPMinimumSize)
+	if !ok {
+		return false
+	}
+	return header.UDP(hdr[hlen:]).DestinationPort() == dhcpServerPort
+}
+
+// buildDHCPFrame builds the ethernet frame carrying the DHCP reply b.
+func buildDHCPFrame(srcLinkAddr, dstLinkAddr tcpip.LinkAddress, src, dst tcpip.Address, b []byte) []byte {
+	const hdrSize = header.EthernetMinimumSize + header.IPv4MinimumSize + header.UDPMinimumSize
+	frame := make([]byte, hdrSize+len(b))
+	copy(frame[hdrSize:], b)
+
+	header.Ethernet(frame).Encode(&header.EthernetFields{
+		SrcAddr: srcLinkAddr,
+		DstAddr: dstLinkAddr,
+		Type:    header.IPv4ProtocolNumber,
+	})
+
+	ip := header.IPv4(frame[header.EthernetMinimumSize:])
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(ip)),
+		TTL:         64,
+		Protocol:    uint8(header.UDPProtocolNumber),
+		SrcAddr:     src,
+		DstAddr:     dst,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+
+	length := uint16(header.UDPMinimumSize + len(b))
+	udp := header.UDP(ip[header.IPv4MinimumSize:])
+	udp.Encode(&header.UDPFields{
+		SrcPort: dhcpServerPort,
+		DstPort: dhcpClientPort,
+		Length:  length,
+	})
+	xsum := udp.CalculateChecksum(checksum.Checksum(b,
+		header.PseudoHeaderChecksum(header.UDPProtocolNumber, src, dst, length)))
+	// As per RFC 768, a computed checksum of zero is transmitted
+	// as all ones.
+	if xsum != math.MaxUint16 {
+		xsum = ^xsum
+	}
+	udp.SetChecksum(xsum)
+	return frame
+}
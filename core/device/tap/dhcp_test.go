@@ -0,0 +1,104 @@
+package tap
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func newDHCPMessage(msgType byte, chaddr tcpip.LinkAddress, opts ...byte) []byte {
+	b := make([]byte, dhcpMinimumSize)
+	b[dhcpOpOffset] = dhcpBootRequest
+	b[dhcpHTypeOffset] = 1
+	b[dhcpHLenOffset] = header.EthernetAddressSize
+	binary.BigEndian.PutUint32(b[dhcpXidOffset:], 0xdeadbeef)
+	copy(b[dhcpChaddrOffset:], chaddr)
+	binary.BigEndian.PutUint32(b[dhcpMagicOffset:], dhcpMagic)
+	b = append(b, dhcpOptMessageType, 1, msgType)
+	b = append(b, opts...)
+	return append(b, dhcpOptEnd)
+}
+
+func TestDHCPServer(t *testing.T) {
+	s, err := newDHCPServer(&DHCPConfig{
+		Prefix: netip.MustParsePrefix("192.168.100.0/24"),
+		DNS:    []netip.Addr{netip.MustParseAddr("1.1.1.1")},
+	})
+	require.NoError(t, err)
+
+	chaddr := tcpip.LinkAddress("\x02\x00\x00\x00\x00\x01")
+
+	offer, dst := s.handle(newDHCPMessage(dhcpDiscover, chaddr))
+	require.NotNil(t, offer)
+	assert.Equal(t, header.IPv4Broadcast, dst)
+	assert.Len(t, offer, dhcpReplySize)
+	assert.EqualValues(t, dhcpBootReply, offer[dhcpOpOffset])
+	assert.Equal(t, uint32(0xdeadbeef), binary.BigEndian.Uint32(offer[dhcpXidOffset:]))
+
+	yiaddr := offer[dhcpYiaddrOffset : dhcpYiaddrOffset+4]
+	assert.Equal(t, []byte{192, 168, 100, 2}, yiaddr)
+
+	opts := parseDHCPOptions(offer[dhcpMinimumSize:])
+	assert.Equal(t, []byte{dhcpOffer}, opts[dhcpOptMessageType])
+	assert.Equal(t, []byte{192, 168, 100, 1}, opts[dhcpOptServerID])
+	assert.Equal(t, []byte{192, 168, 100, 1}, opts[dhcpOptRouter])
+	assert.Equal(t, []byte{255, 255, 255, 0}, opts[dhcpOptSubnetMask])
+	assert.Equal(t, []byte{1, 1, 1, 1}, opts[dhcpOptDNS])
+
+	ack, _ := s.handle(newDHCPMessage(dhcpRequest, chaddr,
+		dhcpOptRequestedIP, 4, 192, 168, 100, 2,
+		dhcpOptServerID, 4, 192, 168, 100, 1))
+	require.NotNil(t, ack)
+	assert.Equal(t, []byte{dhcpAck}, parseDHCPOptions(ack[dhcpMinimumSize:])[dhcpOptMessageType])
+	assert.Equal(t, yiaddr, ack[dhcpYiaddrOffset:dhcpYiaddrOffset+4])
+
+	// Another client asking for the same address is refused.
+	nak, _ := s.handle(newDHCPMessage(dhcpRequest, tcpip.LinkAddress("\x02\x00\x00\x00\x00\x02"),
+		dhcpOptRequestedIP, 4, 192, 168, 100, 2))
+	require.NotNil(t, nak)
+	assert.Equal(t, []byte{dhcpNak}, parseDHCPOptions(nak[dhcpMinimumSize:])[dhcpOptMessageType])
+
+	// Requests to other servers are ignored.
+	reply, _ := s.handle(newDHCPMessage(dhcpRequest, chaddr,
+		dhcpOptRequestedIP, 4, 192, 168, 100, 2,
+		dhcpOptServerID, 4, 192, 168, 100, 254))
+	assert.Nil(t, reply)
+
+	// Released addresses are leased again.
+	s.handle(newDHCPMessage(dhcpRelease, chaddr))
+	offer, _ = s.handle(newDHCPMessage(dhcpDiscover, tcpip.LinkAddress("\x02\x00\x00\x00\x00\x03")))
+	require.NotNil(t, offer)
+	assert.Equal(t, []byte{192, 168, 100, 2}, offer[dhcpYiaddrOffset:dhcpYiaddrOffset+4])
+}
+
+func TestDHCPServerExhausted(t *testing.T) {
+	s, err := newDHCPServer(&DHCPConfig{Prefix: netip.MustParsePrefix("10.0.0.1/30")})
+	require.NoError(t, err)
+
+	offer, _ := s.handle(newDHCPMessage(dhcpDiscover, tcpip.LinkAddress("\x02\x00\x00\x00\x00\x01")))
+	require.NotNil(t, offer)
+	assert.Equal(t, []byte{10, 0, 0, 2}, offer[dhcpYiaddrOffset:dhcpYiaddrOffset+4])
+
+	offer, _ = s.handle(newDHCPMessage(dhcpDiscover, tcpip.LinkAddress("\x02\x00\x00\x00\x00\x02")))
+	assert.Nil(t, offer)
+}
+
+func TestBuildDHCPFrame(t *testing.T) {
+	src := tcpip.AddrFrom4([4]byte{192, 168, 100, 1})
+	frame := buildDHCPFrame("\x02\x00\x00\x00\x00\x01", header.EthernetBroadcastAddress,
+		src, header.IPv4Broadcast, make([]byte, dhcpReplySize))
+
+	ip := header.IPv4(frame[header.EthernetMinimumSize:])
+	require.True(t, ip.IsValid(len(ip)))
+	assert.True(t, ip.IsChecksumValid())
+
+	udp := header.UDP(ip.Payload())
+	assert.EqualValues(t, dhcpClientPort, udp.DestinationPort())
+	assert.True(t, udp.IsChecksumValid(src, header.IPv4Broadcast, checksum.Checksum(udp.Payload(), 0)))
+}
@@ -0,0 +1,50 @@
+package tap
+
+import (
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// unicastNeighborSolicit returns a copy of the IPv6 packet pkt sent to its
+// target address, if pkt is a neighbor solicitation sent to the solicited-node
+// multicast address of the target. Otherwise, it returns nil.
+//
+// The stack only accepts packets sent to multicast groups it has joined, which
+// are not known in advance for solicited-node addresses, since every address
+// is owned in spoofing mode. Unicast solicitations are valid as per RFC 4861
+// section 7.2.2, and are answered for any target address.
+func unicastNeighborSolicit(pkt *stack.PacketBuffer) *stack.PacketBuffer {
+	const size = header.IPv6MinimumSize + header.ICMPv6NeighborSolicitMinimumSize
+	hdr, ok := pkt.Data().PullUp(size)
+	if !ok {
+		return nil
+	}
+	ip := header.IPv6(hdr)
+	if ip.TransportProtocol() != header.ICMPv6ProtocolNumber ||
+		!header.IsSolicitedNodeAddr(ip.DestinationAddress()) ||
+		int(ip.PayloadLength()) < header.ICMPv6NeighborSolicitMinimumSize ||
+		header.IPv6MinimumSize+int(ip.PayloadLength()) > pkt.Data().Size() {
+		return nil
+	}
+	icmp := header.ICMPv6(ip.Payload())
+	if icmp.Type() != header.ICMPv6NeighborSolicit {
+		return nil
+	}
+	target := header.NDPNeighborSolicit(icmp.MessageBody()).TargetAddress()
+	if header.SolicitedNodeAddr(target) != ip.DestinationAddress() {
+		return nil
+	}
+
+	b := pkt.Data().AsRange().ToSlice()[:header.IPv6MinimumSize+int(ip.PayloadLength())]
+	ip = header.IPv6(b)
+	header.ICMPv6(ip.Payload()).UpdateChecksumPseudoHeaderAddress(ip.DestinationAddress(), target)
+	ip.SetDestinationAddress(target)
+
+	newPkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(b),
+	})
+	newPkt.PktType = tcpip.PacketHost
+	return newPkt
+}
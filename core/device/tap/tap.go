@@ -0,0 +1,27 @@
+// Package tap provides TAP which implemented device.Device interface.
+package tap
+
+import (
+	"net/netip"
+
+	"github.com/xjasonlyu/tun2socks/v2/core/device"
+)
+
+const Driver = "tap"
+
+// DHCPConfig is the configuration of the DHCP server of TAP.
+type DHCPConfig struct {
+	// Prefix is the subnet to lease addresses from. Its address is
+	// used as the router, or the first address of the subnet if it
+	// is the network address.
+	Prefix netip.Prefix
+
+	// DNS is the list of DNS servers to advertise.
+	DNS []netip.Addr
+}
+
+func (t *TAP) Type() string {
+	return Driver
+}
+
+var _ device.Device = (*TAP)(nil)
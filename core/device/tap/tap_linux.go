@@ -0,0 +1,171 @@
+package tap
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+	glog "gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/rawfile"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/fdbased"
+	"gvisor.dev/gvisor/pkg/tcpip/link/nested"
+	"gvisor.dev/gvisor/pkg/tcpip/link/tun"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+
+	"github.com/xjasonlyu/tun2socks/v2/core/device"
+)
+
+type TAP struct {
+	nested.Endpoint
+
+	fd   int
+	mtu  uint32
+	name string
+	addr tcpip.LinkAddress
+
+	dhcp *dhcpServer
+}
+
+func Open(name string, mtu uint32, dhcp *DHCPConfig) (_ device.Device, err error) {
+	t := &TAP{name: name, mtu: mtu}
+
+	if len(t.name) >= unix.IFNAMSIZ {
+		return nil, fmt.Errorf("interface name too long: %s", t.name)
+	}
+
+	if dhcp != nil {
+		s, err := newDHCPServer(dhcp)
+		if err != nil {
+			return nil, fmt.Errorf("dhcp: %w", err)
+		}
+		t.dhcp = s
+	}
+
+	fd, err := tun.OpenTAP(t.name)
+	if err != nil {
+		return nil, fmt.Errorf("create tap: %w", err)
+	}
+	t.fd = fd
+
+	defer func() {
+		if err != nil {
+			_ = unix.Close(fd)
+		}
+	}()
+
+	if t.mtu > 0 {
+		if err := setMTU(t.name, t.mtu); err != nil {
+			return nil, fmt.Errorf("set mtu: %w", err)
+		}
+	}
+
+	_mtu, err := rawfile.GetMTU(t.name)
+	if err != nil {
+		return nil, fmt.Errorf("get mtu: %w", err)
+	}
+	t.mtu = _mtu
+
+	// The stack sits behind the TAP interface as a host of its own,
+	// so it needs a link address other than that of the interface.
+	if t.addr, err = randomLinkAddress(); err != nil {
+		return nil, fmt.Errorf("generate link address: %w", err)
+	}
+
+	ep, err := fdbased.New(&fdbased.Options{
+		FDs: []int{fd},
+		MTU: t.mtu,
+		// TAP carries ethernet frames, which are resolved by ARP
+		// and NDP. Both answer for all addresses in spoofing mode.
+		EthernetHeader:     true,
+		Address:            t.addr,
+		PacketDispatchMode: fdbased.Readv,
+		// TAP/TUN fd's are not sockets, see tun.Open for details.
+		MaxSyscallHeaderBytes: 0x00,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create endpoint: %w", err)
+	}
+	t.Endpoint.Init(ep, t)
+
+	return t, nil
+}
+
+func (t *TAP) Name() string {
+	return t.name
+}
+
+func (t *TAP) Close() {
+	defer t.Endpoint.Close()
+	_ = unix.Close(t.fd)
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher. DHCP
+// requests are served by the TAP itself if DHCP is enabled, while
+// others are delivered to the stack.
+func (t *TAP) DeliverNetworkPacket(protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	switch protocol {
+	case header.IPv4ProtocolNumber:
+		if t.dhcp != nil && isDHCPRequest(pkt) {
+			t.serveDHCP(pkt)
+			return
+		}
+	case header.IPv6ProtocolNumber:
+		if ns := unicastNeighborSolicit(pkt); ns != nil {
+			defer ns.DecRef()
+			t.Endpoint.DeliverNetworkPacket(protocol, ns)
+			return
+		}
+	}
+	t.Endpoint.DeliverNetworkPacket(protocol, pkt)
+}
+
+func (t *TAP) serveDHCP(pkt *stack.PacketBuffer) {
+	ip := header.IPv4(pkt.Data().AsRange().ToSlice())
+	reply, dst := t.dhcp.handle(ip.Payload()[header.UDPMinimumSize:])
+	if reply == nil {
+		return
+	}
+
+	dstLinkAddr := header.EthernetBroadcastAddress
+	if dst != header.IPv4Broadcast {
+		dstLinkAddr = tcpip.LinkAddress(reply[dhcpChaddrOffset : dhcpChaddrOffset+header.EthernetAddressSize])
+	}
+	frame := buildDHCPFrame(t.addr, dstLinkAddr, t.dhcp.router, dst, reply)
+	if _, err := unix.Write(t.fd, frame); err != nil {
+		glog.Debugf("write dhcp reply: %s", err)
+	}
+}
+
+// randomLinkAddress returns a random locally administered
+// unicast link address.
+func randomLinkAddress() (tcpip.LinkAddress, error) {
+	b := make([]byte, header.EthernetAddressSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[0] = b[0]&^0x01 | 0x02
+	return tcpip.LinkAddress(b), nil
+}
+
+func setMTU(name string, n uint32) error {
+	// open datagram socket
+	fd, err := unix.Socket(
+		unix.AF_INET,
+		unix.SOCK_DGRAM,
+		0,
+	)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(fd)
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return err
+	}
+	ifr.SetUint32(n)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFMTU, ifr)
+}
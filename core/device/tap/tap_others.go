@@ -0,0 +1,23 @@
+//go:build !linux
+
+package tap
+
+import (
+	"errors"
+
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+
+	"github.com/xjasonlyu/tun2socks/v2/core/device"
+)
+
+type TAP struct {
+	stack.LinkEndpoint
+}
+
+func Open(name string, mtu uint32, dhcp *DHCPConfig) (device.Device, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (t *TAP) Name() string {
+	return ""
+}
@@ -17,23 +17,59 @@ import (
 type TUN struct {
 	stack.LinkEndpoint
 
-	fd   int
-	mtu  uint32
-	name string
+	fds     []int
+	mtu     uint32
+	name    string
+	offload bool
+}
+
+// Options are the options to open the TUN with.
+type Options struct {
+	// Queues is the number of queues of the TUN, each of which is read
+	// by a dispatcher of its own. The kernel spreads flows over the
+	// queues, so that packets are processed on multiple cores in parallel.
+	Queues int
+
+	// Offload enables TSO/USO offloads on the TUN with virtio-net headers,
+	// so that large segments are exchanged with the kernel instead of
+	// packets of the MTU. The TUN falls back to plain packets if offloads
+	// are not supported by the kernel, see TUN.Offload.
+	Offload bool
 }
 
 func Open(name string, mtu uint32) (device.Device, error) {
+	return OpenWithOptions(name, mtu, Options{Queues: 1})
+}
+
+// OpenWithOptions opens the TUN with the given options.
+func OpenWithOptions(name string, mtu uint32, opts Options) (_ device.Device, err error) {
 	t := &TUN{name: name, mtu: mtu}
 
 	if len(t.name) >= unix.IFNAMSIZ {
 		return nil, fmt.Errorf("interface name too long: %s", t.name)
 	}
+	if opts.Queues < 1 {
+		return nil, fmt.Errorf("invalid number of queues: %d", opts.Queues)
+	}
 
-	fd, err := tun.Open(t.name)
-	if err != nil {
-		return nil, fmt.Errorf("create tun: %w", err)
+	defer func() {
+		if err != nil {
+			t.closeFDs()
+		}
+	}()
+
+	if opts.Offload {
+		// Offloads are set on each queue, and all queues must be opened
+		// with the same flags, so fall back to plain queues on any error.
+		if t.offload = t.openQueues(opts.Queues, unix.IFF_VNET_HDR) == nil; !t.offload {
+			t.closeFDs()
+		}
+	}
+	if !t.offload {
+		if err := t.openQueues(opts.Queues, 0); err != nil {
+			return nil, err
+		}
 	}
-	t.fd = fd
 
 	if t.mtu > 0 {
 		if err := setMTU(t.name, t.mtu); err != nil {
@@ -47,12 +83,24 @@ func Open(name string, mtu uint32) (device.Device, error) {
 	}
 	t.mtu = _mtu
 
+	if t.offload {
+		ep, err := newOffloadEndpoint(t.fds, t.mtu)
+		if err != nil {
+			return nil, fmt.Errorf("create endpoint: %w", err)
+		}
+		t.LinkEndpoint = ep
+		return t, nil
+	}
+
 	ep, err := fdbased.New(&fdbased.Options{
-		FDs: []int{fd},
+		// One inbound dispatcher is created for each fd, and
+		// outbound packets are spread over them by flow hash.
+		FDs: t.fds,
 		MTU: t.mtu,
 		// TUN only, ignore ethernet header.
 		EthernetHeader: false,
-		// SYS_READV support only for TUN fd.
+		// SYS_READV support only for TUN fd, since the batched
+		// RecvMMsg and PacketMMap modes require socket fds.
 		PacketDispatchMode: fdbased.Readv,
 		// TAP/TUN fd's are not sockets and using the WritePackets calls results
 		// in errors as it always defaults to using SendMMsg which is not supported
@@ -72,13 +120,98 @@ func Open(name string, mtu uint32) (device.Device, error) {
 	return t, nil
 }
 
+// openQueues opens n queues of the TUN with the extra flags. Offloads
+// are enabled on the queues if they are opened with IFF_VNET_HDR.
+func (t *TUN) openQueues(n int, flags uint16) error {
+	if n == 1 && flags == 0 {
+		fd, err := tun.Open(t.name)
+		if err != nil {
+			return fmt.Errorf("create tun: %w", err)
+		}
+		t.fds = append(t.fds, fd)
+		return nil
+	}
+
+	if n > 1 {
+		flags |= unix.IFF_MULTI_QUEUE
+	}
+	for range n {
+		fd, err := openQueue(t.name, flags)
+		if err != nil {
+			return fmt.Errorf("create tun queue: %w", err)
+		}
+		t.fds = append(t.fds, fd)
+
+		if flags&unix.IFF_VNET_HDR != 0 {
+			if err := setOffload(fd); err != nil {
+				return fmt.Errorf("set offload: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Offload reports whether offloads are enabled on the TUN.
+func (t *TUN) Offload() bool {
+	return t.offload
+}
+
+// GSOMaxSize implements stack.GSOEndpoint.
+func (t *TUN) GSOMaxSize() uint32 {
+	if ep, ok := t.LinkEndpoint.(stack.GSOEndpoint); ok {
+		return ep.GSOMaxSize()
+	}
+	return 0
+}
+
+// SupportedGSO implements stack.GSOEndpoint.
+func (t *TUN) SupportedGSO() stack.SupportedGSO {
+	if ep, ok := t.LinkEndpoint.(stack.GSOEndpoint); ok {
+		return ep.SupportedGSO()
+	}
+	return stack.GSONotSupported
+}
+
 func (t *TUN) Name() string {
 	return t.name
 }
 
 func (t *TUN) Close() {
 	defer t.LinkEndpoint.Close()
-	_ = unix.Close(t.fd)
+	t.closeFDs()
+}
+
+func (t *TUN) closeFDs() {
+	for _, fd := range t.fds {
+		_ = unix.Close(fd)
+	}
+	t.fds = nil
+}
+
+// openQueue opens a queue of the TUN with the extra flags,
+// creating the interface if it does not exist yet.
+func openQueue(name string, flags uint16) (int, error) {
+	fd, err := unix.Open("/dev/net/tun", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI | flags)
+	if err := unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	return fd, nil
 }
 
 func setMTU(name string, n uint32) error {
@@ -0,0 +1,136 @@
+//go:build linux
+
+package tun
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// countingDispatcher counts the packets delivered by the TUN.
+type countingDispatcher struct {
+	n atomic.Int64
+}
+
+func (d *countingDispatcher) DeliverNetworkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {
+	d.n.Add(1)
+}
+
+func (d *countingDispatcher) DeliverLinkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {}
+
+// BenchmarkMultiQueue measures the rate of packets read from the TUN, which
+// are sent by local UDP flows to the peer address of it. It requires the
+// CAP_NET_ADMIN capability.
+func BenchmarkMultiQueue(b *testing.B) {
+	for _, queues := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("queues=%d", queues), func(b *testing.B) {
+			benchmarkMultiQueue(b, queues)
+		})
+	}
+}
+
+func benchmarkMultiQueue(b *testing.B, queues int) {
+	const name = "tunbench0"
+
+	d, err := OpenWithOptions(name, 0, Options{Queues: queues})
+	if err != nil {
+		b.Skipf("open tun: %v", err)
+	}
+	defer d.Close()
+
+	dispatcher := &countingDispatcher{}
+	d.Attach(dispatcher)
+	// Detaching stops the dispatchers, which release the queues
+	// so that the interface is deleted.
+	defer d.Attach(nil)
+
+	if err := setUp(name, net.IPv4(198, 18, 0, 1), net.IPv4Mask(255, 255, 255, 0)); err != nil {
+		b.Fatalf("set up tun: %v", err)
+	}
+
+	flows := runtime.GOMAXPROCS(0) * 4
+	conns := make([]net.Conn, flows)
+	for i := range conns {
+		// Flows of different ports are hashed to different queues.
+		c, err := net.Dial("udp4", fmt.Sprintf("198.18.0.2:%d", 10000+i))
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer c.Close()
+		conns[i] = c
+	}
+
+	payload := make([]byte, 64)
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i, c := range conns {
+		n := b.N / flows
+		if i < b.N%flows {
+			n++
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range n {
+				_, _ = c.Write(payload)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Wait for the queues to be drained.
+	for last := int64(-1); last != dispatcher.n.Load(); {
+		last = dispatcher.n.Load()
+		time.Sleep(10 * time.Millisecond)
+	}
+	elapsed := time.Since(start)
+	b.StopTimer()
+
+	received := dispatcher.n.Load()
+	b.ReportMetric(float64(received)/elapsed.Seconds(), "pkts/s")
+	b.ReportMetric(100*float64(int64(b.N)-received)/float64(b.N), "%drop")
+}
+
+// setUp brings the interface up with the given address.
+func setUp(name string, ip net.IP, mask net.IPMask) error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return err
+	}
+	if err := ifr.SetInet4Addr(ip.To4()); err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFADDR, ifr); err != nil {
+		return err
+	}
+	if err := ifr.SetInet4Addr(net.IP(mask).To4()); err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFNETMASK, ifr); err != nil {
+		return err
+	}
+
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return err
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+}
@@ -0,0 +1,379 @@
+//go:build linux
+
+package tun
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/rawfile"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/stopfd"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// Offload features of TUN, as declared in linux/if_tun.h.
+const (
+	tunFCsum   = 0x01
+	tunFTSO4   = 0x02
+	tunFTSO6   = 0x04
+	tunFTSOECN = 0x08
+	tunFUSO4   = 0x20
+	tunFUSO6   = 0x40
+)
+
+// virtio-net header, as declared in linux/virtio_net.h.
+const (
+	virtioNetHdrSize = 10
+
+	virtioNetHdrFNeedsCsum = 1
+
+	virtioNetHdrGSOTCPv4 = 1
+	virtioNetHdrGSOTCPv6 = 4
+	virtioNetHdrGSOUDPL4 = 5
+	virtioNetHdrGSOECN   = 0x80
+)
+
+// offloadMaxSize is the maximum size of packets exchanged with the kernel,
+// which is also the maximum size of GSO packets sent by the stack.
+const offloadMaxSize = math.MaxUint16
+
+type virtioNetHdr struct {
+	flags      uint8
+	gsoType    uint8
+	hdrLen     uint16
+	gsoSize    uint16
+	csumStart  uint16
+	csumOffset uint16
+}
+
+func (h *virtioNetHdr) decode(b []byte) {
+	h.flags = b[0]
+	h.gsoType = b[1]
+	h.hdrLen = binary.NativeEndian.Uint16(b[2:])
+	h.gsoSize = binary.NativeEndian.Uint16(b[4:])
+	h.csumStart = binary.NativeEndian.Uint16(b[6:])
+	h.csumOffset = binary.NativeEndian.Uint16(b[8:])
+}
+
+func (h *virtioNetHdr) encode(b []byte) {
+	b[0] = h.flags
+	b[1] = h.gsoType
+	binary.NativeEndian.PutUint16(b[2:], h.hdrLen)
+	binary.NativeEndian.PutUint16(b[4:], h.gsoSize)
+	binary.NativeEndian.PutUint16(b[6:], h.csumStart)
+	binary.NativeEndian.PutUint16(b[8:], h.csumOffset)
+}
+
+// setOffload enables checksum and segmentation offloads on the TUN queue fd,
+// which has to be opened with IFF_VNET_HDR. UDP segmentation offload is only
+// available since Linux 6.2, so it is enabled if possible. It's replaced in
+// tests to fail.
+var setOffload = func(fd int) error {
+	const tso = tunFCsum | tunFTSO4 | tunFTSO6 | tunFTSOECN
+	if err := unix.IoctlSetInt(fd, unix.TUNSETOFFLOAD, tso|tunFUSO4|tunFUSO6); err == nil {
+		return nil
+	}
+	return unix.IoctlSetInt(fd, unix.TUNSETOFFLOAD, tso)
+}
+
+var _ stack.LinkEndpoint = (*offloadEndpoint)(nil)
+var _ stack.GSOEndpoint = (*offloadEndpoint)(nil)
+
+// offloadEndpoint is a link endpoint of TUN queues with virtio-net headers.
+// It receives large TCP segments coalesced by the kernel (GRO), and sends
+// large TCP segments, leaving segmentation and checksums to the kernel (TSO).
+//
+// It's not fdbased, whose host GSO is only enabled on socket fds, and is
+// for ethernet frames, e.g. the checksum offsets include the ethernet
+// header. Nor does it handle the virtio-net headers received, but drops
+// them, leaving the partial checksums and UDP segments as they are.
+type offloadEndpoint struct {
+	fds  []int
+	stop stopfd.StopFD
+	wg   sync.WaitGroup
+
+	mu         sync.RWMutex
+	mtu        uint32
+	dispatcher stack.NetworkDispatcher
+	stopped    bool
+}
+
+func newOffloadEndpoint(fds []int, mtu uint32) (*offloadEndpoint, error) {
+	stop, err := stopfd.New()
+	if err != nil {
+		return nil, err
+	}
+	return &offloadEndpoint{fds: fds, stop: stop, mtu: mtu}, nil
+}
+
+// Attach launches one goroutine per queue, which reads packets from it
+// and dispatches them to dispatcher.
+func (e *offloadEndpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	e.mu.Lock()
+	if dispatcher == nil {
+		if e.dispatcher != nil && !e.stopped {
+			e.stopped = true
+			e.stop.Stop()
+		}
+		e.dispatcher = nil
+		e.mu.Unlock()
+		e.Wait()
+		return
+	}
+	defer e.mu.Unlock()
+	if e.dispatcher == nil && !e.stopped {
+		e.dispatcher = dispatcher
+		for _, fd := range e.fds {
+			e.wg.Add(1)
+			go func() {
+				defer e.wg.Done()
+				e.dispatchLoop(fd)
+			}()
+		}
+	}
+}
+
+func (e *offloadEndpoint) IsAttached() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.dispatcher != nil
+}
+
+func (e *offloadEndpoint) Wait() {
+	e.wg.Wait()
+}
+
+func (e *offloadEndpoint) dispatchLoop(fd int) {
+	buf := make([]byte, virtioNetHdrSize+offloadMaxSize)
+	iov := []unix.Iovec{rawfile.IovecFromBytes(buf)}
+	for {
+		n, errno := rawfile.BlockingReadvUntilStopped(e.stop.EFD, fd, iov)
+		if n < 0 || (errno != 0 && errno != unix.EINTR) {
+			return
+		}
+		if n <= virtioNetHdrSize {
+			continue
+		}
+
+		var hdr virtioNetHdr
+		hdr.decode(buf)
+		e.deliver(&hdr, buf[virtioNetHdrSize:n])
+	}
+}
+
+// deliver delivers the packet b read with the virtio-net header hdr. Packets
+// from the kernel may carry partial checksums, which are completed so that
+// the stack verifies them as usual. TCP segments coalesced by the kernel are
+// delivered as a whole, while UDP ones are split back into datagrams.
+func (e *offloadEndpoint) deliver(hdr *virtioNetHdr, b []byte) {
+	e.mu.RLock()
+	d := e.dispatcher
+	e.mu.RUnlock()
+	if d == nil {
+		return
+	}
+
+	var proto tcpip.NetworkProtocolNumber
+	switch header.IPVersion(b) {
+	case header.IPv4Version:
+		proto = header.IPv4ProtocolNumber
+	case header.IPv6Version:
+		proto = header.IPv6ProtocolNumber
+	default:
+		return
+	}
+
+	if hdr.gsoType&^virtioNetHdrGSOECN == virtioNetHdrGSOUDPL4 {
+		segs, err := splitUDP(b, int(hdr.csumStart), int(hdr.gsoSize))
+		if err != nil {
+			return
+		}
+		for _, seg := range segs {
+			e.dispatch(d, proto, seg)
+		}
+		return
+	}
+
+	b = append([]byte(nil), b...)
+	if hdr.flags&virtioNetHdrFNeedsCsum != 0 {
+		if err := completeChecksum(b, int(hdr.csumStart), int(hdr.csumOffset)); err != nil {
+			return
+		}
+	}
+	e.dispatch(d, proto, b)
+}
+
+func (e *offloadEndpoint) dispatch(d stack.NetworkDispatcher, proto tcpip.NetworkProtocolNumber, b []byte) {
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(b),
+	})
+	d.DeliverNetworkPacket(proto, pkt)
+	pkt.DecRef()
+}
+
+// completeChecksum completes the partial checksum of b, i.e. the checksum of
+// the pseudo-header, at start+offset, with the checksum of b from start.
+func completeChecksum(b []byte, start, offset int) error {
+	if start < header.IPv4MinimumSize || start+offset+2 > len(b) {
+		return errors.New("malformed partial checksum")
+	}
+	binary.BigEndian.PutUint16(b[start+offset:], ^checksum.Checksum(b[start:], 0))
+	return nil
+}
+
+// splitUDP splits the UDP GSO packet b, whose UDP header starts at off, into
+// datagrams carrying size bytes of payload each. The checksum of the packet
+// is partial, so those of the datagrams are calculated as a whole.
+func splitUDP(b []byte, off, size int) ([][]byte, error) {
+	hdrLen := off + header.UDPMinimumSize
+	if size <= 0 || off < header.IPv4MinimumSize || len(b) < hdrLen {
+		return nil, errors.New("malformed udp gso packet")
+	}
+
+	var segs [][]byte
+	for i, payload := 0, b[hdrLen:]; len(payload) > 0; i++ {
+		n := min(size, len(payload))
+		seg := make([]byte, hdrLen+n)
+		copy(seg, b[:hdrLen])
+		copy(seg[hdrLen:], payload[:n])
+		payload = payload[n:]
+
+		var src, dst tcpip.Address
+		if header.IPVersion(seg) == header.IPv4Version {
+			ip := header.IPv4(seg)
+			ip.SetTotalLength(uint16(len(seg)))
+			ip.SetID(ip.ID() + uint16(i))
+			ip.SetChecksum(0)
+			ip.SetChecksum(^ip.CalculateChecksum())
+			src, dst = ip.SourceAddress(), ip.DestinationAddress()
+		} else {
+			ip := header.IPv6(seg)
+			ip.SetPayloadLength(uint16(len(seg) - header.IPv6MinimumSize))
+			src, dst = ip.SourceAddress(), ip.DestinationAddress()
+		}
+
+		udp := header.UDP(seg[off:])
+		length := uint16(header.UDPMinimumSize + n)
+		udp.SetLength(length)
+		udp.SetChecksum(0)
+		xsum := header.PseudoHeaderChecksum(header.UDPProtocolNumber, src, dst, length)
+		xsum = checksum.Checksum(udp.Payload(), xsum)
+		// A checksum of zero is sent as all ones, as zero means none.
+		if xsum = ^udp.CalculateChecksum(xsum); xsum == 0 {
+			xsum = 0xffff
+		}
+		udp.SetChecksum(xsum)
+		segs = append(segs, seg)
+	}
+	return segs, nil
+}
+
+// WritePackets writes packets with virtio-net headers, which describe the
+// segmentation and checksum offloads requested by the stack.
+func (e *offloadEndpoint) WritePackets(pkts stack.PacketBufferList) (int, tcpip.Error) {
+	n := 0
+	for _, pkt := range pkts.AsSlice() {
+		if err := e.writePacket(pkt); err != nil {
+			if n == 0 {
+				return 0, err
+			}
+			break
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (e *offloadEndpoint) writePacket(pkt *stack.PacketBuffer) tcpip.Error {
+	hdr := virtioNetHdr{}
+	if pkt.GSOOptions.Type != stack.GSONone {
+		hdr.hdrLen = uint16(pkt.HeaderSize())
+		if pkt.GSOOptions.NeedsCsum {
+			hdr.flags = virtioNetHdrFNeedsCsum
+			hdr.csumStart = pkt.GSOOptions.L3HdrLen
+			hdr.csumOffset = pkt.GSOOptions.CsumOffset
+		}
+		if uint16(pkt.Data().Size()) > pkt.GSOOptions.MSS {
+			switch pkt.GSOOptions.Type {
+			case stack.GSOTCPv4:
+				hdr.gsoType = virtioNetHdrGSOTCPv4
+			case stack.GSOTCPv6:
+				hdr.gsoType = virtioNetHdrGSOTCPv6
+			}
+			hdr.gsoSize = pkt.GSOOptions.MSS
+		}
+	}
+	var vnetHdr [virtioNetHdrSize]byte
+	hdr.encode(vnetHdr[:])
+
+	views := pkt.AsSlices()
+	iovecs := make([]unix.Iovec, 0, 1+len(views))
+	iovecs = append(iovecs, rawfile.IovecFromBytes(vnetHdr[:]))
+	for _, v := range views {
+		iovecs = append(iovecs, rawfile.IovecFromBytes(v))
+	}
+
+	fd := e.fds[pkt.Hash%uint32(len(e.fds))]
+	if errno := rawfile.NonBlockingWriteIovec(fd, iovecs); errno != 0 {
+		return tcpip.TranslateErrno(errno)
+	}
+	return nil
+}
+
+func (e *offloadEndpoint) MTU() uint32 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.mtu
+}
+
+func (e *offloadEndpoint) SetMTU(mtu uint32) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mtu = mtu
+}
+
+func (*offloadEndpoint) MaxHeaderLength() uint16 {
+	return 0
+}
+
+func (*offloadEndpoint) LinkAddress() tcpip.LinkAddress {
+	return ""
+}
+
+func (*offloadEndpoint) SetLinkAddress(tcpip.LinkAddress) {}
+
+func (*offloadEndpoint) Capabilities() stack.LinkEndpointCapabilities {
+	return stack.CapabilityNone
+}
+
+func (*offloadEndpoint) GSOMaxSize() uint32 {
+	return offloadMaxSize
+}
+
+func (*offloadEndpoint) SupportedGSO() stack.SupportedGSO {
+	return stack.HostGSOSupported
+}
+
+func (*offloadEndpoint) ARPHardwareType() header.ARPHardwareType {
+	return header.ARPHardwareNone
+}
+
+func (*offloadEndpoint) AddHeader(*stack.PacketBuffer) {}
+
+func (*offloadEndpoint) ParseHeader(*stack.PacketBuffer) bool {
+	return true
+}
+
+func (e *offloadEndpoint) Close() {
+	e.Attach(nil)
+	_ = unix.Close(e.stop.EFD)
+}
+
+func (*offloadEndpoint) SetOnCloseAction(func()) {}
@@ -0,0 +1,99 @@
+//go:build linux
+
+package tun
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func TestSplitUDP(t *testing.T) {
+	const payloadSize = 2500
+	b := make([]byte, header.IPv4MinimumSize+header.UDPMinimumSize+payloadSize)
+	header.IPv4(b).Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(b)),
+		ID:          1,
+		TTL:         64,
+		Protocol:    uint8(header.UDPProtocolNumber),
+		SrcAddr:     tcpip.AddrFrom4([4]byte{198, 18, 0, 1}),
+		DstAddr:     tcpip.AddrFrom4([4]byte{198, 18, 0, 2}),
+	})
+	header.UDP(b[header.IPv4MinimumSize:]).Encode(&header.UDPFields{
+		SrcPort: 1000,
+		DstPort: 2000,
+		Length:  uint16(header.UDPMinimumSize + payloadSize),
+	})
+
+	segs, err := splitUDP(b, header.IPv4MinimumSize, 1000)
+	require.NoError(t, err)
+	require.Len(t, segs, 3)
+
+	for i, size := range []int{1000, 1000, 500} {
+		ip := header.IPv4(segs[i])
+		require.True(t, ip.IsValid(len(segs[i])))
+		assert.True(t, ip.IsChecksumValid())
+		assert.Equal(t, uint16(1+i), ip.ID())
+
+		udp := header.UDP(ip.Payload())
+		assert.Equal(t, uint16(header.UDPMinimumSize+size), udp.Length())
+		assert.Len(t, udp.Payload(), size)
+		assert.True(t, udp.IsChecksumValid(ip.SourceAddress(), ip.DestinationAddress(), checksum.Checksum(udp.Payload(), 0)))
+	}
+
+	_, err = splitUDP(b[:header.IPv4MinimumSize], header.IPv4MinimumSize, 1000)
+	assert.Error(t, err)
+}
+
+func TestCompleteChecksum(t *testing.T) {
+	const payloadSize = 100
+	b := make([]byte, header.IPv4MinimumSize+header.TCPMinimumSize+payloadSize)
+	src, dst := tcpip.AddrFrom4([4]byte{198, 18, 0, 1}), tcpip.AddrFrom4([4]byte{198, 18, 0, 2})
+	header.IPv4(b).Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(b)),
+		TTL:         64,
+		Protocol:    uint8(header.TCPProtocolNumber),
+		SrcAddr:     src,
+		DstAddr:     dst,
+	})
+	tcp := header.TCP(b[header.IPv4MinimumSize:])
+	tcp.Encode(&header.TCPFields{
+		SrcPort:    1000,
+		DstPort:    2000,
+		DataOffset: header.TCPMinimumSize,
+		Flags:      header.TCPFlagAck,
+	})
+	// The kernel leaves the checksum of the pseudo-header only.
+	length := uint16(header.TCPMinimumSize + payloadSize)
+	tcp.SetChecksum(header.PseudoHeaderChecksum(header.TCPProtocolNumber, src, dst, length))
+
+	require.NoError(t, completeChecksum(b, header.IPv4MinimumSize, header.TCPChecksumOffset))
+	assert.True(t, tcp.IsChecksumValid(src, dst, checksum.Checksum(tcp.Payload(), 0), uint16(len(tcp.Payload()))))
+
+	assert.Error(t, completeChecksum(b[:header.IPv4MinimumSize], header.IPv4MinimumSize, header.TCPChecksumOffset))
+}
+
+// TestOffloadFallback opens the TUN with plain queues if offloads fail to
+// be enabled. It requires the CAP_NET_ADMIN capability.
+func TestOffloadFallback(t *testing.T) {
+	prev := setOffload
+	setOffload = func(int) error { return errors.New("not supported") }
+	defer func() { setOffload = prev }()
+
+	d, err := OpenWithOptions("tunoff0", 0, Options{Queues: 2, Offload: true})
+	if err != nil {
+		t.Skipf("open tun: %v", err)
+	}
+	defer d.Close()
+
+	tun := d.(*TUN)
+	assert.False(t, tun.Offload())
+	assert.Len(t, tun.fds, 2)
+	assert.Equal(t, stack.GSONotSupported, tun.SupportedGSO())
+}
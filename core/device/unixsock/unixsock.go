@@ -0,0 +1,58 @@
+// Package unixsock provides Socket which implemented device.Device
+// interface, exchanging raw IP packets over a Unix socket.
+package unixsock
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xjasonlyu/tun2socks/v2/core/device"
+)
+
+const Driver = "unix"
+
+const defaultMTU = 1500
+
+// SocketType is the type of Unix sockets, which preserve
+// message boundaries so that each message is a packet.
+type SocketType string
+
+const (
+	Datagram  SocketType = "dgram"
+	SeqPacket SocketType = "seqpacket"
+)
+
+// ParseSocketType parses the socket type, Datagram if s is empty.
+func ParseSocketType(s string) (SocketType, error) {
+	switch t := SocketType(strings.ToLower(s)); t {
+	case "":
+		return Datagram, nil
+	case Datagram, SeqPacket:
+		return t, nil
+	default:
+		return "", fmt.Errorf("invalid socket type: %s", s)
+	}
+}
+
+// Options are the options to open the Socket with.
+type Options struct {
+	// Type is the type of the socket.
+	Type SocketType
+
+	// Listen makes the Socket listen on the path instead of
+	// connecting to it. A listening seqpacket socket serves one
+	// peer at a time, while a listening datagram socket replies
+	// to the peer which sent the last packet.
+	Listen bool
+
+	// Local is the path to bind a connecting datagram socket to,
+	// so that the peer can reply to it. It is bound to a unique
+	// abstract address on Linux if empty.
+	Local string
+}
+
+func (s *Socket) Type() string {
+	return Driver
+}
+
+var _ device.Device = (*Socket)(nil)
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package unixsock
+
+import (
+	"errors"
+
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+
+	"github.com/xjasonlyu/tun2socks/v2/core/device"
+)
+
+type Socket struct {
+	stack.LinkEndpoint
+}
+
+func Open(path string, mtu uint32, opts Options) (device.Device, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (s *Socket) Name() string {
+	return ""
+}
@@ -0,0 +1,121 @@
+//go:build linux
+
+package unixsock
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+
+	"github.com/xjasonlyu/tun2socks/v2/core/device"
+)
+
+// chanDispatcher sends the packets delivered to a channel.
+type chanDispatcher chan []byte
+
+func (d chanDispatcher) DeliverNetworkPacket(_ tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	d <- pkt.ToView().AsSlice()
+}
+
+func (d chanDispatcher) DeliverLinkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {}
+
+func newPacket() []byte {
+	b := make([]byte, header.IPv4MinimumSize)
+	header.IPv4(b).Encode(&header.IPv4Fields{
+		TotalLength: header.IPv4MinimumSize,
+		TTL:         64,
+		SrcAddr:     tcpip.AddrFrom4([4]byte{10, 0, 0, 1}),
+		DstAddr:     tcpip.AddrFrom4([4]byte{10, 0, 0, 2}),
+	})
+	return b
+}
+
+// exchange checks that packets are exchanged between d and peer.
+func exchange(t *testing.T, d device.Device, peer net.Conn, write func([]byte) error) {
+	dispatcher := make(chanDispatcher, 1)
+	d.Attach(dispatcher)
+
+	pkt := newPacket()
+	require.NoError(t, write(pkt))
+	select {
+	case b := <-dispatcher:
+		assert.Equal(t, pkt, b)
+	case <-time.After(time.Second):
+		t.Fatal("packet is not delivered")
+	}
+
+	var pkts stack.PacketBufferList
+	pkts.PushBack(stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(pkt),
+	}))
+	_, tcpipErr := d.WritePackets(pkts)
+	require.Nil(t, tcpipErr)
+	pkts.Reset()
+
+	require.NoError(t, peer.SetReadDeadline(time.Now().Add(time.Second)))
+	b := make([]byte, 1500)
+	n, err := peer.Read(b)
+	require.NoError(t, err)
+	assert.Equal(t, pkt, b[:n])
+}
+
+func TestListen(t *testing.T) {
+	for _, typ := range []SocketType{Datagram, SeqPacket} {
+		t.Run(string(typ), func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "tun2socks.sock")
+			d, err := Open(path, 0, Options{Type: typ, Listen: true})
+			require.NoError(t, err)
+			defer d.Close()
+
+			network := map[SocketType]string{Datagram: "unixgram", SeqPacket: "unixpacket"}[typ]
+			var laddr *net.UnixAddr
+			if typ == Datagram {
+				laddr = &net.UnixAddr{Name: filepath.Join(dir, "peer.sock"), Net: network}
+			}
+			peer, err := net.DialUnix(network, laddr, &net.UnixAddr{Name: path, Net: network})
+			require.NoError(t, err)
+			defer peer.Close()
+
+			exchange(t, d, peer, func(b []byte) error {
+				_, err := peer.Write(b)
+				return err
+			})
+		})
+	}
+}
+
+func TestConnect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vmm.sock")
+	peer, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer peer.Close()
+
+	// The device is bound to an abstract address, so that the
+	// peer can reply to it.
+	d, err := Open(path, 0, Options{Type: Datagram})
+	require.NoError(t, err)
+	defer d.Close()
+
+	var addr *net.UnixAddr
+	exchange(t, d, peer, func(b []byte) error {
+		// Learn the address of the device by a packet from it.
+		_, err := d.(*Socket).conn.Write(newPacket())
+		if err != nil {
+			return err
+		}
+		if _, addr, err = peer.ReadFromUnix(make([]byte, 1500)); err != nil {
+			return err
+		}
+		_, err = peer.WriteToUnix(b, addr)
+		return err
+	})
+}
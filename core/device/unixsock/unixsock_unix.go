@@ -0,0 +1,217 @@
+//go:build unix
+
+package unixsock
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/xjasonlyu/tun2socks/v2/core/device"
+	"github.com/xjasonlyu/tun2socks/v2/core/device/iobased"
+)
+
+type Socket struct {
+	*iobased.Endpoint
+
+	path string
+	conn packetConn
+}
+
+// packetConn reads and writes a packet per call, and it
+// is closed to stop the endpoint reading from it.
+type packetConn interface {
+	Read([]byte) (int, error)
+	Write([]byte) (int, error)
+	Close() error
+}
+
+func Open(path string, mtu uint32, opts Options) (device.Device, error) {
+	if path == "" {
+		return nil, errors.New("empty socket path")
+	}
+	if mtu == 0 {
+		mtu = defaultMTU
+	}
+
+	network := "unixgram"
+	if opts.Type == SeqPacket {
+		network = "unixpacket"
+	}
+
+	var (
+		conn packetConn
+		err  error
+	)
+	switch {
+	case opts.Listen && opts.Type == SeqPacket:
+		conn, err = listenSeqPacket(path)
+	case opts.Listen:
+		conn, err = listenDatagram(path)
+	default:
+		var laddr *net.UnixAddr
+		if network == "unixgram" {
+			local, err := localName(opts.Local)
+			if err != nil {
+				return nil, err
+			}
+			laddr = &net.UnixAddr{Name: local, Net: network}
+		}
+		conn, err = net.DialUnix(network, laddr, &net.UnixAddr{Name: path, Net: network})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open socket: %w", err)
+	}
+
+	ep, err := iobased.New(conn, mtu, 0)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("create endpoint: %w", err)
+	}
+	return &Socket{Endpoint: ep, path: path, conn: conn}, nil
+}
+
+// localName returns the name to bind a connecting datagram socket to,
+// which is a unique abstract name on Linux if local is empty.
+func localName(local string) (string, error) {
+	if local != "" {
+		return local, nil
+	}
+	if runtime.GOOS != "linux" {
+		return "", errors.New("local path is required for datagram socket")
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("@tun2socks-%d-%x", os.Getpid(), b), nil
+}
+
+func (s *Socket) Name() string {
+	return s.path
+}
+
+func (s *Socket) Close() {
+	defer s.Endpoint.Close()
+	_ = s.conn.Close()
+}
+
+// datagramListener is a listening datagram socket, which replies
+// to the peer of the last packet received.
+type datagramListener struct {
+	*net.UnixConn
+
+	path string
+
+	mu   sync.RWMutex
+	peer *net.UnixAddr
+}
+
+func listenDatagram(path string) (*datagramListener, error) {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &datagramListener{UnixConn: conn, path: path}, nil
+}
+
+func (l *datagramListener) Read(b []byte) (int, error) {
+	n, addr, err := l.ReadFromUnix(b)
+	if err != nil {
+		return n, err
+	}
+	// Packets from unbound peers can not be replied.
+	if addr != nil && addr.Name != "" {
+		l.mu.Lock()
+		l.peer = addr
+		l.mu.Unlock()
+	}
+	return n, nil
+}
+
+func (l *datagramListener) Write(b []byte) (int, error) {
+	l.mu.RLock()
+	peer := l.peer
+	l.mu.RUnlock()
+	if peer == nil {
+		return len(b), nil /* no peer yet, drop packet */
+	}
+	return l.WriteToUnix(b, peer)
+}
+
+func (l *datagramListener) Close() error {
+	defer os.Remove(l.path)
+	return l.UnixConn.Close()
+}
+
+// seqPacketListener is a listening seqpacket socket, which serves
+// one peer at a time, and accepts the next one on disconnection.
+type seqPacketListener struct {
+	*net.UnixListener
+
+	mu   sync.RWMutex
+	conn *net.UnixConn
+}
+
+func listenSeqPacket(path string) (*seqPacketListener, error) {
+	ln, err := net.ListenUnix("unixpacket", &net.UnixAddr{Name: path, Net: "unixpacket"})
+	if err != nil {
+		return nil, err
+	}
+	// The socket file is removed on close.
+	ln.SetUnlinkOnClose(true)
+	return &seqPacketListener{UnixListener: ln}, nil
+}
+
+// Read reads a packet from the current peer, accepting one if
+// there is none. It fails only if the listener is closed.
+func (l *seqPacketListener) Read(b []byte) (int, error) {
+	for {
+		l.mu.RLock()
+		conn := l.conn
+		l.mu.RUnlock()
+
+		if conn == nil {
+			c, err := l.AcceptUnix()
+			if err != nil {
+				return 0, err
+			}
+			l.mu.Lock()
+			l.conn = c
+			l.mu.Unlock()
+			continue
+		}
+
+		n, err := conn.Read(b)
+		if err == nil {
+			return n, nil
+		}
+		l.mu.Lock()
+		l.conn = nil
+		l.mu.Unlock()
+		conn.Close()
+	}
+}
+
+func (l *seqPacketListener) Write(b []byte) (int, error) {
+	l.mu.RLock()
+	conn := l.conn
+	l.mu.RUnlock()
+	if conn == nil {
+		return len(b), nil /* no peer yet, drop packet */
+	}
+	return conn.Write(b)
+}
+
+func (l *seqPacketListener) Close() error {
+	l.mu.Lock()
+	if l.conn != nil {
+		l.conn.Close()
+	}
+	l.mu.Unlock()
+	return l.UnixListener.Close()
+}
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"errors"
+
 	"gvisor.dev/gvisor/pkg/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/checksum"
@@ -23,6 +25,7 @@ func withICMPHandler(h adapter.NetworkHandler) option.Option {
 			return false
 		})
 		s.SetTransportProtocolHandler(icmp.ProtocolNumber4, f.HandlePacket)
+		s.SetTransportProtocolHandler(icmp.ProtocolNumber6, f.HandlePacket)
 		return nil
 	}
 }
@@ -39,7 +42,7 @@ func newICMPForwarder(s *stack.Stack, h icmpForwarderHandler) *icmpForwarder {
 }
 
 func (f *icmpForwarder) HandlePacket(id stack.TransportEndpointID, pkt *stack.PacketBuffer) bool {
-	if f.h(&icmpForwarderRequest{pkt: pkt.Clone(), id: id, stack: f.s}) {
+	if f.h(newICMPForwarderRequest(f.s, id, pkt.Clone())) {
 		return true /* handled */
 	}
 	switch pkt.NetworkProtocolNumber {
@@ -104,14 +107,92 @@ func (f *icmpForwarder) handlePacket4(_ stack.TransportEndpointID, pkt *stack.Pa
 	return true
 }
 
-func (f *icmpForwarder) handlePacket6(id stack.TransportEndpointID, pkt *stack.PacketBuffer) bool {
-	return false // not implemented
+// Ref: https://github.com/google/gvisor/blob/master/pkg/tcpip/network/ipv6/icmp.go (sendICMPEchoReply)
+func (f *icmpForwarder) handlePacket6(_ stack.TransportEndpointID, pkt *stack.PacketBuffer) (handled bool) {
+	if h := header.ICMPv6(pkt.TransportHeader().Slice()); len(h) < header.ICMPv6EchoMinimumSize || h.Type() != header.ICMPv6EchoRequest {
+		return false
+	}
+
+	ipHdr := header.IPv6(pkt.NetworkHeader().Slice())
+
+	// As per RFC 4291 section 2.7, multicast addresses must not be used as
+	// source addresses in IPv6 packets.
+	localAddr := ipHdr.DestinationAddress()
+	if header.IsV6MulticastAddress(localAddr) {
+		localAddr = tcpip.Address{}
+	}
+
+	r, err := f.s.FindRoute(pkt.NICID, localAddr, ipHdr.SourceAddress(), ipv6.ProtocolNumber, false /* multicastLoop */)
+	if err != nil {
+		// If we cannot find a route to the destination, silently drop the packet.
+		return false
+	}
+	defer r.Release()
+
+	replyData := stack.PayloadSince(pkt.TransportHeader())
+	defer replyData.Release()
+
+	replyICMPHdr := header.ICMPv6(replyData.AsSlice())
+	replyICMPHdr.SetType(header.ICMPv6EchoReply)
+	replyICMPHdr.SetCode(0) // RFC 4443: EchoReply must have Code=0.
+	// Unlike ICMPv4, the ICMPv6 checksum covers the IPv6 pseudo-header,
+	// which holds the addresses the reply is actually sent with.
+	replyICMPHdr.SetChecksum(header.ICMPv6Checksum(header.ICMPv6ChecksumParams{
+		Header: replyICMPHdr,
+		Src:    r.LocalAddress(),
+		Dst:    r.RemoteAddress(),
+	}))
+
+	replyBuf := buffer.MakeWithView(replyData.Clone())
+	replyPkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: int(r.MaxHeaderLength()),
+		Payload:            replyBuf,
+	})
+	defer replyPkt.DecRef()
+
+	sent := f.s.Stats().ICMP.V6.PacketsSent
+	if err := r.WritePacket(stack.NetworkHeaderParams{
+		Protocol: header.ICMPv6ProtocolNumber,
+		TTL:      r.DefaultTTL(),
+	}, replyPkt); err != nil {
+		sent.Dropped.Increment()
+		return false
+	}
+	sent.EchoReply.Increment()
+	return true
 }
 
 type icmpForwarderRequest struct {
 	stack *stack.Stack
 	id    stack.TransportEndpointID
 	pkt   *stack.PacketBuffer
+
+	// Where the request came from, so that it can be replied to
+	// after pkt has been released by the handler.
+	nicID      tcpip.NICID
+	netProto   tcpip.NetworkProtocolNumber
+	localAddr  tcpip.Address
+	remoteAddr tcpip.Address
+}
+
+func newICMPForwarderRequest(s *stack.Stack, id stack.TransportEndpointID, pkt *stack.PacketBuffer) *icmpForwarderRequest {
+	netHdr := pkt.Network()
+	localAddr := netHdr.DestinationAddress()
+	// Never reply from a broadcast or multicast address, let the
+	// stack pick one of its own instead.
+	if pkt.NetworkPacketInfo.LocalAddressBroadcast ||
+		header.IsV4MulticastAddress(localAddr) || header.IsV6MulticastAddress(localAddr) {
+		localAddr = tcpip.Address{}
+	}
+	return &icmpForwarderRequest{
+		stack:      s,
+		id:         id,
+		pkt:        pkt,
+		nicID:      pkt.NICID,
+		netProto:   pkt.NetworkProtocolNumber,
+		localAddr:  localAddr,
+		remoteAddr: netHdr.SourceAddress(),
+	}
 }
 
 func (r *icmpForwarderRequest) Stack() *stack.Stack { return r.stack }
@@ -120,4 +201,61 @@ func (r *icmpForwarderRequest) ID() stack.TransportEndpointID { return r.id }
 
 func (r *icmpForwarderRequest) Buffer() *stack.PacketBuffer { return r.pkt }
 
+// WriteBack writes the ICMP message b back to the source of the request,
+// from the destination of it. The checksum of b is filled in.
+func (r *icmpForwarderRequest) WriteBack(b []byte) error {
+	route, err := r.stack.FindRoute(r.nicID, r.localAddr, r.remoteAddr, r.netProto, false /* multicastLoop */)
+	if err != nil {
+		return errors.New(err.String())
+	}
+	defer route.Release()
+
+	data := buffer.NewViewWithData(b)
+	var (
+		proto   tcpip.TransportProtocolNumber
+		dropped *tcpip.StatCounter
+	)
+	switch r.netProto {
+	case ipv4.ProtocolNumber:
+		if len(b) < header.ICMPv4MinimumSize {
+			data.Release()
+			return errors.New("invalid icmp message")
+		}
+		h := header.ICMPv4(data.AsSlice())
+		h.SetChecksum(0)
+		h.SetChecksum(^checksum.Checksum(h, 0))
+		proto, dropped = header.ICMPv4ProtocolNumber, r.stack.Stats().ICMP.V4.PacketsSent.Dropped
+	case ipv6.ProtocolNumber:
+		if len(b) < header.ICMPv6MinimumSize {
+			data.Release()
+			return errors.New("invalid icmpv6 message")
+		}
+		h := header.ICMPv6(data.AsSlice())
+		h.SetChecksum(header.ICMPv6Checksum(header.ICMPv6ChecksumParams{
+			Header: h,
+			Src:    route.LocalAddress(),
+			Dst:    route.RemoteAddress(),
+		}))
+		proto, dropped = header.ICMPv6ProtocolNumber, r.stack.Stats().ICMP.V6.PacketsSent.Dropped
+	default:
+		data.Release()
+		return errors.New("unknown network protocol")
+	}
+
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: int(route.MaxHeaderLength()),
+		Payload:            buffer.MakeWithView(data),
+	})
+	defer pkt.DecRef()
+
+	if err := route.WritePacket(stack.NetworkHeaderParams{
+		Protocol: proto,
+		TTL:      route.DefaultTTL(),
+	}, pkt); err != nil {
+		dropped.Increment()
+		return errors.New(err.String())
+	}
+	return nil
+}
+
 var _ adapter.Packet = (*icmpForwarderRequest)(nil)
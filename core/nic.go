@@ -22,11 +22,17 @@ const (
 	nicSpoofingEnabled = true
 )
 
-// withCreatingNIC creates NIC for stack.
+// withCreatingNIC creates NIC for stack. The NIC is named after
+// the link endpoint, if it has a name (e.g. device.Device).
 func withCreatingNIC(nicID tcpip.NICID, ep stack.LinkEndpoint) option.Option {
 	return func(s *stack.Stack) error {
+		var name string
+		if n, ok := ep.(interface{ Name() string }); ok {
+			name = n.Name()
+		}
 		if err := s.CreateNICWithOptions(nicID, ep,
 			stack.NICOptions{
+				Name:     name,
 				Disabled: false,
 				// If no queueing discipline was specified
 				// provide a stub implementation that just
@@ -8,18 +8,22 @@ import (
 	"github.com/xjasonlyu/tun2socks/v2/core/option"
 )
 
-func withRouteTable(nicID tcpip.NICID) option.Option {
+func withRouteTable(nicIDs ...tcpip.NICID) option.Option {
 	return func(s *stack.Stack) error {
-		s.SetRouteTable([]tcpip.Route{
-			{
-				Destination: header.IPv4EmptySubnet,
-				NIC:         nicID,
-			},
-			{
-				Destination: header.IPv6EmptySubnet,
-				NIC:         nicID,
-			},
-		})
+		routes := make([]tcpip.Route, 0, 2*len(nicIDs))
+		for _, nicID := range nicIDs {
+			routes = append(routes,
+				tcpip.Route{
+					Destination: header.IPv4EmptySubnet,
+					NIC:         nicID,
+				},
+				tcpip.Route{
+					Destination: header.IPv6EmptySubnet,
+					NIC:         nicID,
+				},
+			)
+		}
+		s.SetRouteTable(routes)
 		return nil
 	}
 }
@@ -1,8 +1,11 @@
 package core
 
 import (
+	"errors"
 	"net/netip"
 
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
@@ -16,10 +19,14 @@ import (
 
 // Config is the configuration to create *stack.Stack.
 type Config struct {
-	// LinkEndpoints is the interface implemented by
+	// LinkEndpoint is the interface implemented by
 	// data link layer protocols.
 	LinkEndpoint stack.LinkEndpoint
 
+	// LinkEndpoints are additional link endpoints, each of
+	// which is attached to the stack as a NIC of its own.
+	LinkEndpoints []stack.LinkEndpoint
+
 	// TransportHandler is the handler used by internal
 	// stack to set transport handlers.
 	TransportHandler adapter.TransportHandler
@@ -32,6 +39,11 @@ type Config struct {
 	// nic to given groups.
 	MulticastGroups []netip.Addr
 
+	// TCPMaxConnAttempts is the maximum number of in-flight
+	// TCP handshakes, beyond which SYNs are dropped. If zero,
+	// the default is used.
+	TCPMaxConnAttempts int
+
 	// Options are supplement options to apply settings
 	// for the internal stack.
 	Options []option.Option
@@ -46,6 +58,9 @@ func CreateStack(cfg *Config) (*stack.Stack, error) {
 
 	s := stack.New(stack.Options{
 		NetworkProtocols: []stack.NetworkProtocolFactory{
+			// ARP is only used by NICs with link address
+			// resolution, i.e. those of ethernet devices.
+			arp.NewProtocol,
 			ipv4.NewProtocol,
 			ipv6.NewProtocol,
 		},
@@ -57,15 +72,21 @@ func CreateStack(cfg *Config) (*stack.Stack, error) {
 		},
 	})
 
-	// Generate unique NIC id.
-	nicID := s.NextNICID()
+	var endpoints []stack.LinkEndpoint
+	if cfg.LinkEndpoint != nil {
+		endpoints = append(endpoints, cfg.LinkEndpoint)
+	}
+	endpoints = append(endpoints, cfg.LinkEndpoints...)
+	if len(endpoints) == 0 {
+		return nil, errors.New("no link endpoint")
+	}
 
 	opts = append(opts,
 		// Important: We must initiate transport protocol handlers
 		// before creating NIC, otherwise NIC would dispatch packets
 		// to stack and cause race condition.
 		// Initiate transport protocol (TCP/UDP) with given handler.
-		withTCPHandler(cfg.TransportHandler),
+		withTCPHandler(cfg.TransportHandler, cfg.TCPMaxConnAttempts),
 		withUDPHandler(cfg.TransportHandler),
 
 		// gVisor added NetworkPacketInfo.LocalAddressTemporary to
@@ -78,40 +99,50 @@ func CreateStack(cfg *Config) (*stack.Stack, error) {
 		//  - https://github.com/google/gvisor/issues/8657
 		//  - https://github.com/google/gvisor/pull/11681
 		withICMPHandler(cfg.ICMPHandler),
+	)
 
-		// Create stack NIC and then bind link endpoint to it.
-		withCreatingNIC(nicID, cfg.LinkEndpoint),
-
-		// In the past we did s.AddAddressRange to assign 0.0.0.0/0
-		// onto the interface. We need that to be able to terminate
-		// all the incoming connections - to any ip. AddressRange API
-		// has been removed and the suggested workaround is to use
-		// Promiscuous mode. https://github.com/google/gvisor/issues/3876
-		//
-		// Ref: https://github.com/cloudflare/slirpnetstack/blob/master/stack.go
-		withPromiscuousMode(nicID, nicPromiscuousModeEnabled),
-
-		// Enable spoofing if a stack may send packets from unowned
-		// addresses. This change required changes to some netgophers
-		// since previously, promiscuous mode was enough to let the
-		// netstack respond to all incoming packets regardless of the
-		// packet's destination address. Now that a stack.Route is not
-		// held for each incoming packet, finding a route may fail with
-		// local addresses we don't own but accepted packets for while
-		// in promiscuous mode. Since we also want to be able to send
-		// from any address (in response the received promiscuous mode
-		// packets), we need to enable spoofing.
-		//
-		// Ref: https://github.com/google/gvisor/commit/8c0701462a84ff77e602f1626aec49479c308127
-		withSpoofing(nicID, nicSpoofingEnabled),
-
-		// Add default route table for IPv4 and IPv6. This will handle
-		// all incoming ICMP packets.
-		withRouteTable(nicID),
+	nicIDs := make([]tcpip.NICID, 0, len(endpoints))
+	for _, ep := range endpoints {
+		// Generate unique NIC id.
+		nicID := s.NextNICID()
+		nicIDs = append(nicIDs, nicID)
+
+		opts = append(opts,
+			// Create stack NIC and then bind link endpoint to it.
+			withCreatingNIC(nicID, ep),
+
+			// In the past we did s.AddAddressRange to assign 0.0.0.0/0
+			// onto the interface. We need that to be able to terminate
+			// all the incoming connections - to any ip. AddressRange API
+			// has been removed and the suggested workaround is to use
+			// Promiscuous mode. https://github.com/google/gvisor/issues/3876
+			//
+			// Ref: https://github.com/cloudflare/slirpnetstack/blob/master/stack.go
+			withPromiscuousMode(nicID, nicPromiscuousModeEnabled),
+
+			// Enable spoofing if a stack may send packets from unowned
+			// addresses. This change required changes to some netgophers
+			// since previously, promiscuous mode was enough to let the
+			// netstack respond to all incoming packets regardless of the
+			// packet's destination address. Now that a stack.Route is not
+			// held for each incoming packet, finding a route may fail with
+			// local addresses we don't own but accepted packets for while
+			// in promiscuous mode. Since we also want to be able to send
+			// from any address (in response the received promiscuous mode
+			// packets), we need to enable spoofing.
+			//
+			// Ref: https://github.com/google/gvisor/commit/8c0701462a84ff77e602f1626aec49479c308127
+			withSpoofing(nicID, nicSpoofingEnabled),
+
+			// Add NIC to the given multicast groups.
+			withMulticastGroups(nicID, cfg.MulticastGroups),
+		)
+	}
 
-		// Add default NIC to the given multicast groups.
-		withMulticastGroups(nicID, cfg.MulticastGroups),
-	)
+	// Add default route table for IPv4 and IPv6 of every NIC. This
+	// will handle all incoming ICMP packets. Connections are bound to
+	// the NIC they came in on, so replies go out through the same NIC.
+	opts = append(opts, withRouteTable(nicIDs...))
 
 	for _, opt := range opts {
 		if err := opt(s); err != nil {
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"sync"
 	"time"
 
 	glog "gvisor.dev/gvisor/pkg/log"
@@ -20,9 +21,9 @@ const (
 	// receive window buffer size is used instead.
 	defaultWndSize = 0
 
-	// maxConnAttempts specifies the maximum number
-	// of in-flight tcp connection attempts.
-	maxConnAttempts = 2 << 10
+	// defaultMaxConnAttempts specifies the default maximum
+	// number of in-flight tcp connection attempts.
+	defaultMaxConnAttempts = 2 << 10
 
 	// tcpKeepaliveCount is the maximum number of
 	// TCP keep-alive probes to send before giving up
@@ -41,9 +42,17 @@ const (
 	tcpKeepaliveInterval = 30 * time.Second
 )
 
-func withTCPHandler(h adapter.TransportHandler) option.Option {
+// withTCPHandler sets h as the handler of TCP connections, which have at
+// most maxConnAttempts handshakes in flight on each NIC, or the default
+// number if it's not positive. SYNs beyond are dropped.
+func withTCPHandler(h adapter.TransportHandler, maxConnAttempts int) option.Option {
+	if maxConnAttempts <= 0 {
+		maxConnAttempts = defaultMaxConnAttempts
+	}
+	limiter, _ := h.(adapter.Limiter)
+
 	return func(s *stack.Stack) error {
-		f := tcp.NewForwarder(s, defaultWndSize, maxConnAttempts, func(r *tcp.ForwarderRequest) {
+		handle := func(nicID tcpip.NICID, r *tcp.ForwarderRequest) {
 			var (
 				wq  waiter.Queue
 				ep  tcpip.Endpoint
@@ -51,6 +60,17 @@ func withTCPHandler(h adapter.TransportHandler) option.Option {
 				id  = r.ID()
 			)
 
+			if d, ok := h.(adapter.Drainer); ok && d.Draining() {
+				// RST: no more connections are accepted.
+				r.Complete(true)
+				return
+			}
+			if limiter != nil && !limiter.Admit(nicID, tcp.ProtocolNumber, id) {
+				// RST: over the limits of connections.
+				r.Complete(true)
+				return
+			}
+
 			defer func() {
 				if err != nil {
 					glog.Debugf("forward tcp request: %s:%d->%s:%d: %s",
@@ -61,6 +81,9 @@ func withTCPHandler(h adapter.TransportHandler) option.Option {
 			// Perform a TCP three-way handshake.
 			ep, err = r.CreateEndpoint(&wq)
 			if err != nil {
+				if limiter != nil {
+					limiter.Release(nicID, tcp.ProtocolNumber, id)
+				}
 				// RST: prevent potential half-open TCP connection leak.
 				r.Complete(true)
 				return
@@ -72,10 +95,33 @@ func withTCPHandler(h adapter.TransportHandler) option.Option {
 			conn := &tcpConn{
 				TCPConn: gonet.NewTCPConn(&wq, ep),
 				id:      id,
+				device:  s.FindNICNameFromID(nicID),
+				nicID:   nicID,
 			}
 			h.HandleTCP(conn)
+		}
+
+		// A forwarder of each NIC, as the requests don't tell the NIC
+		// the SYNs came in on, and a forwarder takes a SYN of the same
+		// transport endpoint ID as one in flight for a retransmission,
+		// even if it's of another NIC.
+		var (
+			mu         sync.Mutex
+			forwarders = make(map[tcpip.NICID]*tcp.Forwarder)
+		)
+		s.SetTransportProtocolHandler(tcp.ProtocolNumber, func(id stack.TransportEndpointID, pkt *stack.PacketBuffer) bool {
+			nicID := pkt.NICID
+			mu.Lock()
+			f := forwarders[nicID]
+			if f == nil {
+				f = tcp.NewForwarder(s, defaultWndSize, maxConnAttempts, func(r *tcp.ForwarderRequest) {
+					handle(nicID, r)
+				})
+				forwarders[nicID] = f
+			}
+			mu.Unlock()
+			return f.HandlePacket(id, pkt)
 		})
-		s.SetTransportProtocolHandler(tcp.ProtocolNumber, f.HandlePacket)
 		return nil
 	}
 }
@@ -114,9 +160,19 @@ func setSocketOptions(s *stack.Stack, ep tcpip.Endpoint) tcpip.Error {
 
 type tcpConn struct {
 	*gonet.TCPConn
-	id stack.TransportEndpointID
+	id     stack.TransportEndpointID
+	device string
+	nicID  tcpip.NICID
 }
 
 func (c *tcpConn) ID() stack.TransportEndpointID {
 	return c.id
 }
+
+func (c *tcpConn) Device() string {
+	return c.device
+}
+
+func (c *tcpConn) NICID() tcpip.NICID {
+	return c.nicID
+}
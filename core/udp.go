@@ -1,8 +1,16 @@
 package core
 
 import (
+	"errors"
+	"math"
+	"net/netip"
+
+	"gvisor.dev/gvisor/pkg/buffer"
 	glog "gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
 	"gvisor.dev/gvisor/pkg/waiter"
@@ -12,36 +20,244 @@ import (
 )
 
 func withUDPHandler(h adapter.TransportHandler) option.Option {
+	limiter, _ := h.(adapter.Limiter)
+
 	return func(s *stack.Stack) error {
-		f := udp.NewForwarder(s, func(r *udp.ForwarderRequest) bool {
+		handle := func(r *udp.ForwarderRequest, pkt *stack.PacketBuffer) bool {
 			var (
 				wq waiter.Queue
 				id = r.ID()
 			)
+			if d, ok := h.(adapter.Drainer); ok && d.Draining() {
+				// Unhandled packets are replied with port unreachable.
+				return false
+			}
+			if limiter != nil && !limiter.Admit(pkt.NICID, udp.ProtocolNumber, id) {
+				// Dropped: over the limits of sessions.
+				return true
+			}
 			ep, err := r.CreateEndpoint(&wq)
 			if err != nil {
+				if limiter != nil {
+					limiter.Release(pkt.NICID, udp.ProtocolNumber, id)
+				}
 				glog.Debugf("forward udp request: %s:%d->%s:%d: %s",
 					id.RemoteAddress, id.RemotePort, id.LocalAddress, id.LocalPort, err)
 				return false
 			}
 
 			conn := &udpConn{
-				UDPConn: gonet.NewUDPConn(&wq, ep),
-				id:      id,
+				UDPConn:  gonet.NewUDPConn(&wq, ep),
+				id:       id,
+				device:   s.FindNICNameFromID(pkt.NICID),
+				stack:    s,
+				nicID:    pkt.NICID,
+				netProto: pkt.NetworkProtocolNumber,
+				quote:    quotePacket(pkt),
 			}
 			h.HandleUDP(conn)
 			return true
+		}
+		// Same as (*udp.Forwarder).HandlePacket, but keeps the original
+		// packet at hand, so that the NIC it came in on is known.
+		s.SetTransportProtocolHandler(udp.ProtocolNumber, func(id stack.TransportEndpointID, pkt *stack.PacketBuffer) bool {
+			return handle(udp.NewForwarderRequest(s, id, pkt.Clone()), pkt)
 		})
-		s.SetTransportProtocolHandler(udp.ProtocolNumber, f.HandlePacket)
 		return nil
 	}
 }
 
 type udpConn struct {
 	*gonet.UDPConn
-	id stack.TransportEndpointID
+	id     stack.TransportEndpointID
+	device string
+
+	stack    *stack.Stack
+	nicID    tcpip.NICID
+	netProto tcpip.NetworkProtocolNumber
+
+	// quote holds the leading bytes of the first packet of the
+	// connection, which ICMP errors sent back to the source carry.
+	quote []byte
 }
 
 func (c *udpConn) ID() stack.TransportEndpointID {
 	return c.id
 }
+
+func (c *udpConn) Device() string {
+	return c.device
+}
+
+func (c *udpConn) NICID() tcpip.NICID {
+	return c.nicID
+}
+
+// WriteFrom writes a UDP datagram to the remote end of the connection
+// with from as its source address, which does not need to be the local
+// address of the connection. This relies on NIC spoofing being enabled.
+func (c *udpConn) WriteFrom(b []byte, from netip.AddrPort) (int, error) {
+	if len(b) > math.MaxUint16-header.UDPMinimumSize {
+		return 0, errors.New("udp payload too large")
+	}
+
+	src := from.Addr().Unmap()
+	if src.Is4() != (c.netProto == header.IPv4ProtocolNumber) {
+		return 0, errors.New("address family mismatch")
+	}
+	srcAddr := tcpip.AddrFromSlice(src.AsSlice())
+
+	r, err := c.stack.FindRoute(c.nicID, srcAddr, c.id.RemoteAddress, c.netProto, false /* multicastLoop */)
+	if err != nil {
+		return 0, errors.New(err.String())
+	}
+	defer r.Release()
+
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: header.UDPMinimumSize + int(r.MaxHeaderLength()),
+		Payload:            buffer.MakeWithData(b),
+	})
+	defer pkt.DecRef()
+
+	length := uint16(pkt.Size()) + header.UDPMinimumSize
+	udpHdr := header.UDP(pkt.TransportHeader().Push(header.UDPMinimumSize))
+	pkt.TransportProtocolNumber = udp.ProtocolNumber
+	udpHdr.Encode(&header.UDPFields{
+		SrcPort: from.Port(),
+		DstPort: c.id.RemotePort,
+		Length:  length,
+	})
+
+	if r.RequiresTXTransportChecksum() {
+		xsum := udpHdr.CalculateChecksum(checksum.Combine(
+			r.PseudoHeaderChecksum(udp.ProtocolNumber, length),
+			pkt.Data().Checksum(),
+		))
+		// As per RFC 768, a computed checksum of zero is transmitted
+		// as all ones.
+		if xsum != math.MaxUint16 {
+			xsum = ^xsum
+		}
+		udpHdr.SetChecksum(xsum)
+	}
+
+	if err := r.WritePacket(stack.NetworkHeaderParams{
+		Protocol: udp.ProtocolNumber,
+		TTL:      r.DefaultTTL(),
+	}, pkt); err != nil {
+		return 0, errors.New(err.String())
+	}
+	return len(b), nil
+}
+
+// WriteUnreachable sends an ICMP destination unreachable error, quoting the
+// first packet of the connection, back to the source of it. ICMP errors are
+// subject to the ICMP rate limit of the stack.
+func (c *udpConn) WriteUnreachable(code adapter.UnreachableCode) error {
+	if len(c.quote) == 0 {
+		return nil /* never reply to broadcast or multicast */
+	}
+
+	r, err := c.stack.FindRoute(c.nicID, c.id.LocalAddress, c.id.RemoteAddress, c.netProto, false /* multicastLoop */)
+	if err != nil {
+		return errors.New(err.String())
+	}
+	defer r.Release()
+
+	var (
+		hdr   []byte
+		proto tcpip.TransportProtocolNumber
+		stats = c.stack.Stats().ICMP
+
+		// sent ICMP packet counters of the network protocol.
+		dstUnreachable, dropped, rateLimited *tcpip.StatCounter
+	)
+	switch c.netProto {
+	case header.IPv4ProtocolNumber:
+		sent := stats.V4.PacketsSent
+		dstUnreachable, dropped, rateLimited = sent.DstUnreachable, sent.Dropped, sent.RateLimited
+		if !c.stack.AllowICMPMessage() {
+			rateLimited.Increment()
+			return nil
+		}
+		h := header.ICMPv4(make([]byte, header.ICMPv4MinimumSize))
+		h.SetType(header.ICMPv4DstUnreachable)
+		h.SetCode(header.ICMPv4PortUnreachable)
+		if code == adapter.AdminProhibited {
+			h.SetCode(header.ICMPv4AdminProhibited)
+		}
+		h.SetChecksum(header.ICMPv4Checksum(h, checksum.Checksum(c.quote, 0)))
+		hdr, proto = h, header.ICMPv4ProtocolNumber
+	case header.IPv6ProtocolNumber:
+		sent := stats.V6.PacketsSent
+		dstUnreachable, dropped, rateLimited = sent.DstUnreachable, sent.Dropped, sent.RateLimited
+		if !c.stack.AllowICMPMessage() {
+			rateLimited.Increment()
+			return nil
+		}
+		h := header.ICMPv6(make([]byte, header.ICMPv6DstUnreachableMinimumSize))
+		h.SetType(header.ICMPv6DstUnreachable)
+		h.SetCode(header.ICMPv6PortUnreachable)
+		if code == adapter.AdminProhibited {
+			h.SetCode(header.ICMPv6Prohibited)
+		}
+		h.SetChecksum(header.ICMPv6Checksum(header.ICMPv6ChecksumParams{
+			Header:      h,
+			Src:         r.LocalAddress(),
+			Dst:         r.RemoteAddress(),
+			PayloadCsum: checksum.Checksum(c.quote, 0),
+			PayloadLen:  len(c.quote),
+		}))
+		hdr, proto = h, header.ICMPv6ProtocolNumber
+	default:
+		return errors.New("unknown network protocol")
+	}
+
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: int(r.MaxHeaderLength()) + len(hdr),
+		Payload:            buffer.MakeWithData(c.quote),
+	})
+	defer pkt.DecRef()
+
+	copy(pkt.TransportHeader().Push(len(hdr)), hdr)
+	pkt.TransportProtocolNumber = proto
+
+	if err := r.WritePacket(stack.NetworkHeaderParams{
+		Protocol: proto,
+		TTL:      r.DefaultTTL(),
+	}, pkt); err != nil {
+		dropped.Increment()
+		return errors.New(err.String())
+	}
+	dstUnreachable.Increment()
+	return nil
+}
+
+// quotePacket returns the leading bytes of pkt, starting from its network
+// header, to be carried by ICMP errors. It returns nil for packets sent to
+// broadcast or multicast addresses, which must not be replied with errors.
+func quotePacket(pkt *stack.PacketBuffer) []byte {
+	var n int
+	switch netHdr := pkt.Network(); pkt.NetworkProtocolNumber {
+	case header.IPv4ProtocolNumber:
+		if pkt.NetworkPacketInfo.LocalAddressBroadcast || header.IsV4MulticastAddress(netHdr.DestinationAddress()) {
+			return nil
+		}
+		// As per RFC 1812 section 4.3.2.3, as much of the original datagram
+		// as possible without the ICMP datagram exceeding 576 bytes.
+		n = header.IPv4MinimumProcessableDatagramSize - header.IPv4MinimumSize - header.ICMPv4MinimumSize
+	case header.IPv6ProtocolNumber:
+		if header.IsV6MulticastAddress(netHdr.DestinationAddress()) {
+			return nil
+		}
+		// As per RFC 4443 section 2.4 (c), as much of the invoking packet
+		// as possible without the ICMPv6 packet exceeding the minimum MTU.
+		n = header.IPv6MinimumMTU - header.IPv6MinimumSize - header.ICMPv6DstUnreachableMinimumSize
+	default:
+		return nil
+	}
+
+	data := stack.PayloadSince(pkt.NetworkHeader())
+	defer data.Release()
+	return append([]byte(nil), data.AsSlice()[:min(n, data.Size())]...)
+}
@@ -2,6 +2,7 @@ package dialer
 
 import (
 	"context"
+	"errors"
 	"net"
 	"sync"
 	"syscall"
@@ -33,6 +34,11 @@ func ListenPacket(network, address string) (net.PacketConn, error) {
 	return DefaultDialer.ListenPacket(network, address)
 }
 
+// ListenICMP listens for ICMP using the DefaultDialer.
+func ListenICMP(network string) (net.PacketConn, error) {
+	return DefaultDialer.ListenICMP(network)
+}
+
 // Dialer applies registered SocketOptions to all dials/listens.
 type Dialer struct {
 	optsMu     sync.Mutex
@@ -97,3 +103,30 @@ func (d *Dialer) ListenPacket(network, address string) (net.PacketConn, error) {
 	}
 	return lc.ListenPacket(context.Background(), network, address)
 }
+
+// ListenICMP opens an unprivileged ICMP datagram socket for the network
+// "ip4" or "ip6", applying registered SocketOptions. The packets read from
+// and written to it start with the ICMP header. The ID field of outgoing
+// echo requests may be rewritten by the kernel.
+func (d *Dialer) ListenICMP(network string) (net.PacketConn, error) {
+	pc, err := listenICMP(network)
+	if err != nil {
+		return nil, err
+	}
+
+	sc, ok := pc.(syscall.Conn)
+	if !ok {
+		pc.Close()
+		return nil, errors.ErrUnsupported
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	if err = d.applySockOpts(network, "", rc); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return pc, nil
+}
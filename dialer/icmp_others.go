@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package dialer
+
+import (
+	"errors"
+	"net"
+)
+
+func listenICMP(string) (net.PacketConn, error) {
+	return nil, errors.ErrUnsupported
+}
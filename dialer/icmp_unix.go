@@ -0,0 +1,34 @@
+//go:build linux || darwin
+
+package dialer
+
+import (
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenICMP opens an unprivileged ICMP datagram socket, a.k.a. ping
+// socket. On Linux, its use is restricted by net.ipv4.ping_group_range.
+func listenICMP(network string) (net.PacketConn, error) {
+	var family, proto int
+	switch network {
+	case "ip4":
+		family, proto = unix.AF_INET, unix.IPPROTO_ICMP
+	case "ip6":
+		family, proto = unix.AF_INET6, unix.IPPROTO_ICMPV6
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+
+	fd, err := unix.Socket(family, unix.SOCK_DGRAM, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	unix.CloseOnExec(fd)
+
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
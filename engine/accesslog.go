@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"github.com/docker/go-units"
+
+	"github.com/xjasonlyu/tun2socks/v2/accesslog"
+	"github.com/xjasonlyu/tun2socks/v2/log"
+)
+
+// accessLog writes the access log of connections to the file k.AccessLog,
+// if it's set.
+func (e *Engine) accessLog(k *Key) error {
+	if k.AccessLog == "" {
+		return nil
+	}
+
+	cfg := accesslog.Config{
+		Path:           k.AccessLog,
+		Format:         k.AccessLogFormat,
+		RotateInterval: k.AccessLogRotateInterval,
+		MaxBackups:     k.AccessLogMaxBackups,
+		MaxAge:         k.AccessLogMaxAge,
+	}
+	if k.AccessLogMaxSize != "" {
+		size, err := units.RAMInBytes(k.AccessLogMaxSize)
+		if err != nil {
+			return err
+		}
+		cfg.MaxSize = size
+	}
+
+	l, err := accesslog.New(cfg)
+	if err != nil {
+		return err
+	}
+	e.manager.SetClosedHandler(l.Log)
+	e.addCleanup(func() error {
+		e.manager.SetClosedHandler(nil)
+		return l.Close()
+	})
+	log.Infof("[ACCESS] write to %s", k.AccessLog)
+	return nil
+}
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/xjasonlyu/tun2socks/v2/log"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/statistic"
+)
+
+// defaultAccountingInterval is how often the accounts are saved by
+// default.
+const defaultAccountingInterval = time.Minute
+
+// accounting restores the accounts of sources and destinations from the
+// file k.AccountingFile if it's set, and saves them to it periodically,
+// as well as on stop, so that they survive restarts.
+func (e *Engine) accounting(k *Key) error {
+	if k.AccountingFile == "" {
+		return nil
+	}
+	path, manager := k.AccountingFile, e.manager
+
+	interval := k.AccountingInterval
+	if interval <= 0 {
+		interval = defaultAccountingInterval
+	}
+
+	if err := loadAccounts(path, manager); err != nil {
+		return err
+	}
+
+	e.persistEvery(path, interval, func() any { return manager.Accounts() })
+	log.Infof("[ACCOUNTING] save to %s every %s", path, interval)
+	return nil
+}
+
+func loadAccounts(path string, manager *statistic.Manager) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	accounts := &statistic.Accounts{}
+	if err := json.Unmarshal(data, accounts); err != nil {
+		return err
+	}
+	manager.RestoreAccounts(accounts)
+	return nil
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/go-units"
+
+	"github.com/xjasonlyu/tun2socks/v2/core/capture"
+	"github.com/xjasonlyu/tun2socks/v2/log"
+)
+
+// startCapture starts capturing packets of all devices to the file
+// k.Capture, in pcap format if it ends with .pcap, or pcapng if not.
+func (e *Engine) startCapture(k *Key) error {
+	opts := &capture.Options{
+		Format:   capture.FormatPcapNG,
+		Duration: k.CaptureDuration,
+	}
+	if strings.EqualFold(filepath.Ext(k.Capture), ".pcap") {
+		opts.Format = capture.FormatPcap
+	}
+
+	if k.CaptureFilter != "" {
+		filter, err := capture.ParseFilter(k.CaptureFilter)
+		if err != nil {
+			return err
+		}
+		opts.Filter = filter
+	}
+
+	if k.CaptureMaxSize != "" {
+		size, err := units.RAMInBytes(k.CaptureMaxSize)
+		if err != nil {
+			return err
+		}
+		opts.MaxSize = size
+	}
+
+	f, err := os.Create(k.Capture)
+	if err != nil {
+		return err
+	}
+
+	s, err := capture.Start(f, e.captureEndpoints, opts)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	e.addCleanup(func() error {
+		s.Stop()
+		return f.Close()
+	})
+
+	go func() {
+		<-s.Done()
+		if n := s.Dropped(); n > 0 {
+			log.Warnf("[CAPTURE] %s: %d packets dropped", k.Capture, n)
+		}
+		if err := s.Err(); errors.Is(err, capture.ErrLimitReached) {
+			log.Infof("[CAPTURE] %s: %v", k.Capture, err)
+		} else if err != nil {
+			log.Errorf("[CAPTURE] %s: %v", k.Capture, err)
+		}
+	}()
+	log.Infof("[CAPTURE] write %s to %s", opts.Format, k.Capture)
+	return nil
+}
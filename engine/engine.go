@@ -1,154 +1,472 @@
 package engine
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"net"
-	"os/exec"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/docker/go-units"
-	"github.com/google/shlex"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 
 	"github.com/xjasonlyu/tun2socks/v2/core"
 	"github.com/xjasonlyu/tun2socks/v2/core/adapter"
+	"github.com/xjasonlyu/tun2socks/v2/core/capture"
 	"github.com/xjasonlyu/tun2socks/v2/core/device"
-	"github.com/xjasonlyu/tun2socks/v2/core/option"
-	"github.com/xjasonlyu/tun2socks/v2/dialer"
 	"github.com/xjasonlyu/tun2socks/v2/log"
 	"github.com/xjasonlyu/tun2socks/v2/proxy"
+	"github.com/xjasonlyu/tun2socks/v2/proxy/reject"
 	"github.com/xjasonlyu/tun2socks/v2/restapi"
 	"github.com/xjasonlyu/tun2socks/v2/tunnel"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/shaper"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/statistic"
 )
 
-var (
-	_engineMu sync.Mutex
-
-	// _defaultKey holds the default key for the engine.
-	_defaultKey *Key
-
-	// _defaultProxy holds the default proxy for the engine.
-	_defaultProxy proxy.Proxy
-
-	// _defaultDevice holds the default device for the engine.
-	_defaultDevice device.Device
-
-	// _defaultStack holds the default stack for the engine.
-	_defaultStack *stack.Stack
-
-	// _icmpHandler holds the custom ICMP handler for the engine.
-	_icmpHandler adapter.NetworkHandler
-)
+// _defaultEngine is the engine of the package-level functions, which
+// uses the global Tunnel and statistic manager.
+var _defaultEngine = &Engine{global: true}
 
 // Start starts the default engine up.
 func Start() {
-	if err := start(); err != nil {
+	if err := _defaultEngine.Start(); err != nil {
 		log.Fatalf("[ENGINE] failed to start: %v", err)
 	}
 }
 
 // Stop shuts the default engine down.
 func Stop() {
-	if err := stop(); err != nil {
+	if err := _defaultEngine.Stop(); err != nil {
+		log.Fatalf("[ENGINE] failed to stop: %v", err)
+	}
+}
+
+// Shutdown shuts the default engine down gracefully.
+func Shutdown(ctx context.Context) {
+	if err := _defaultEngine.Shutdown(ctx); err != nil {
 		log.Fatalf("[ENGINE] failed to stop: %v", err)
 	}
 }
 
 // Insert loads *Key to the default engine.
 func Insert(k *Key) {
-	_engineMu.Lock()
-	_defaultKey = k
-	_engineMu.Unlock()
+	_defaultEngine.mu.Lock()
+	_defaultEngine.key = k
+	_defaultEngine.mu.Unlock()
 }
 
 // SetICMPHandler sets the custom ICMP handler for the default engine.
 func SetICMPHandler(h adapter.NetworkHandler) {
-	_engineMu.Lock()
-	_icmpHandler = h
-	_engineMu.Unlock()
+	_defaultEngine.mu.Lock()
+	_defaultEngine.icmpHandler = h
+	_defaultEngine.mu.Unlock()
+}
+
+// Config is the configuration of an Engine.
+type Config struct {
+	// Key is the settings of the Engine, the same as the
+	// command line flags and the configuration file.
+	Key *Key
+
+	// ICMPHandler is the custom ICMP handler. If nil, the
+	// Tunnel of the Engine handles ICMP packets.
+	ICMPHandler adapter.NetworkHandler
+
+	// Loader loads the Key to reload the Engine with, on the
+	// PUT /configs request of the REST API. If nil, the
+	// request is not served.
+	Loader func() (*Key, error)
+}
+
+// Engine is an instance of tun2socks, which owns its devices, stack,
+// Tunnel, statistic manager and REST API server, so that several of
+// them can run in a process. The log level and the dialer options,
+// i.e. the interface and the fwmark, are still process-wide, and set
+// by the Engine started last.
+type Engine struct {
+	mu sync.Mutex
+
+	key         *Key
+	icmpHandler adapter.NetworkHandler
+	loader      func() (*Key, error)
+
+	// global makes the Engine use the global Tunnel and
+	// statistic manager, instead of its own ones.
+	global bool
+
+	running          bool
+	tunnel           *tunnel.Tunnel
+	manager          *statistic.Manager
+	proxy            proxy.Proxy
+	devices          []device.Device
+	captureEndpoints []*capture.Endpoint
+	stack            *stack.Stack
+
+	// cleanups holds the cleanup functions registered on start,
+	// which are run in reverse order on stop.
+	cleanups []func() error
+}
+
+// New returns an Engine of cfg, which is not started yet.
+func New(cfg Config) (*Engine, error) {
+	if cfg.Key == nil {
+		return nil, errors.New("empty key")
+	}
+	return &Engine{
+		key:         cfg.Key,
+		icmpHandler: cfg.ICMPHandler,
+		loader:      cfg.Loader,
+	}, nil
 }
 
-func start() error {
-	_engineMu.Lock()
-	defer _engineMu.Unlock()
+// Start starts the Engine up. Whatever has been set up is reverted
+// if it fails. A stopped Engine can be started again.
+func (e *Engine) Start() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 
-	if _defaultKey == nil {
+	if e.key == nil {
 		return errors.New("empty key")
 	}
+	if e.running {
+		return errors.New("already started")
+	}
 
 	for _, f := range []func(*Key) error{
-		general,
-		restAPI,
-		netstack,
+		e.setupTunnel,
+		e.general,
+		e.accessLog,
+		e.accounting,
+		e.quotas,
+		e.restAPI,
+		e.netstack,
 	} {
-		if err := f(_defaultKey); err != nil {
+		if err := f(e.key); err != nil {
+			// Revert whatever has been set up so far.
+			if cleanupErr := e.cleanup(); cleanupErr != nil {
+				log.Errorf("[ENGINE] failed to clean up: %v", cleanupErr)
+			}
 			return err
 		}
 	}
+	e.running = true
 	return nil
 }
 
-func stop() (err error) {
-	_engineMu.Lock()
-	if _defaultDevice != nil {
-		_defaultDevice.Close()
+// Stop shuts the Engine down, and returns the errors of cleaning up.
+func (e *Engine) Stop() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.running = false
+	return e.cleanup()
+}
+
+// Shutdown shuts the Engine down gracefully. New connections are refused,
+// and the active ones are given the drain timeout to finish, before the
+// Engine stops. It stops immediately once ctx is done.
+func (e *Engine) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	t, timeout := e.tunnel, time.Duration(0)
+	if e.running {
+		timeout = e.key.DrainTimeout
 	}
-	if _defaultStack != nil {
-		_defaultStack.Close()
-		_defaultStack.Wait()
+	e.mu.Unlock()
+
+	if t != nil && timeout > 0 {
+		drain(ctx, t, timeout)
 	}
-	_engineMu.Unlock()
-	return nil
+	return e.Stop()
+}
+
+// drain refuses new connections of t, and waits for the active ones
+// to finish, until the timeout or ctx is done.
+func drain(ctx context.Context, t *tunnel.Tunnel, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	t.SetDraining(true)
+	log.Infof("[ENGINE] draining %d connections in %s", t.Active(), timeout)
+
+	poll := time.NewTicker(100 * time.Millisecond)
+	defer poll.Stop()
+	progress := time.NewTicker(time.Second)
+	defer progress.Stop()
+
+	for {
+		n := t.Active()
+		if n == 0 {
+			log.Infof("[ENGINE] all connections drained")
+			return
+		}
+		select {
+		case <-ctx.Done():
+			log.Warnf("[ENGINE] stop with %d connections left", n)
+			return
+		case <-progress.C:
+			log.Infof("[ENGINE] waiting for %d connections", n)
+		case <-poll.C:
+		}
+	}
+}
+
+// Tunnel returns the Tunnel of the Engine, nil if it's not started.
+func (e *Engine) Tunnel() *tunnel.Tunnel {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.tunnel
 }
 
-func execCommand(cmd string) error {
-	parts, err := shlex.Split(cmd)
+// Manager returns the statistic manager of the Engine, nil if it's
+// not started.
+func (e *Engine) Manager() *statistic.Manager {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.manager
+}
+
+// Proxy returns the name of the current proxy of the Engine, i.e.
+// its URL without the password.
+func (e *Engine) Proxy() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.proxy == nil {
+		return ""
+	}
+	return fmt.Sprint(e.proxy)
+}
+
+// SetProxy switches the Engine to the proxy of URL s, for the new
+// connections. Connections established keep their proxies.
+func (e *Engine) SetProxy(s string) error {
+	p, err := parseProxy(s)
 	if err != nil {
 		return err
 	}
-	if len(parts) == 0 {
-		return errors.New("empty command")
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	next := *e.key
+	next.Proxy = s
+	e.key = &next
+	if e.running {
+		e.proxy = p
+		e.tunnel.SetProxy(p)
+		log.Infof("[ENGINE] switch proxy to %s", p)
 	}
-	_, err = exec.Command(parts[0], parts[1:]...).Output()
-	return err
+	return nil
+}
+
+// RateLimits returns the rate limits of the Engine in bytes per second,
+// which are none if it's not running.
+func (e *Engine) RateLimits() shaper.Limits {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.running {
+		return shaper.Limits{}
+	}
+	return e.tunnel.Shaper().Limits()
+}
+
+// SetRateLimits sets the rate limits of the Engine, which apply to the
+// connections established as well.
+func (e *Engine) SetRateLimits(l shaper.Limits) error {
+	for _, r := range []int64{
+		l.Global.Upload, l.Global.Download,
+		l.Source.Upload, l.Source.Download,
+		l.Outbound.Upload, l.Outbound.Download,
+		l.Connection.Upload, l.Connection.Download,
+	} {
+		if r < 0 {
+			return fmt.Errorf("invalid rate limit: %d", r)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	next := *e.key
+	next.RateLimitUpload = formatRate(l.Global.Upload)
+	next.RateLimitDownload = formatRate(l.Global.Download)
+	next.RateLimitSourceUpload = formatRate(l.Source.Upload)
+	next.RateLimitSourceDownload = formatRate(l.Source.Download)
+	next.RateLimitOutboundUpload = formatRate(l.Outbound.Upload)
+	next.RateLimitOutboundDownload = formatRate(l.Outbound.Download)
+	next.RateLimitConnUpload = formatRate(l.Connection.Upload)
+	next.RateLimitConnDownload = formatRate(l.Connection.Download)
+	e.key = &next
+	if e.running {
+		e.tunnel.Shaper().SetLimits(l)
+		log.Infof("[SHAPER] set rate limits: %+v", l)
+	}
+	return nil
+}
+
+// SessionStats returns the statistics of the sessions of the Engine,
+// which are none if it's not running.
+func (e *Engine) SessionStats() tunnel.SessionStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.running {
+		return tunnel.SessionStats{}
+	}
+	return e.tunnel.SessionStats()
+}
+
+// SetSessionLimits sets the limits of new sessions of the Engine.
+func (e *Engine) SetSessionLimits(l tunnel.SessionLimits) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	next := *e.key
+	next.MaxTCPSessions = l.TCP
+	next.MaxUDPSessions = l.UDP
+	next.MaxTCPSessionsPerSource = l.TCPPerSource
+	next.MaxUDPSessionsPerSource = l.UDPPerSource
+	e.key = &next
+	if e.running {
+		e.tunnel.SetSessionLimits(l)
+		log.Infof("[STACK] set session limits: %+v", l)
+	}
+	return nil
+}
+
+// formatRate formats the rate r of Key, which is empty if unlimited.
+func formatRate(r int64) string {
+	if r <= 0 {
+		return ""
+	}
+	return strconv.FormatInt(r, 10)
+}
+
+// addCleanup registers f to be run on stop, or on start failure.
+func (e *Engine) addCleanup(f func() error) {
+	e.cleanups = append(e.cleanups, f)
 }
 
-func general(k *Key) error {
+// cleanup runs the registered cleanup functions in reverse
+// order, and returns the errors of them joined.
+func (e *Engine) cleanup() error {
+	var errs []error
+	for _, f := range slices.Backward(e.cleanups) {
+		if err := f(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	e.cleanups = nil
+	return errors.Join(errs...)
+}
+
+// closeDevices closes the devices opened, which is safe to be
+// called more than once.
+func (e *Engine) closeDevices() {
+	for _, d := range e.devices {
+		d.Close()
+	}
+	e.devices = nil
+	e.captureEndpoints = nil
+}
+
+// setupTunnel sets up the Tunnel and the statistic manager, which are
+// the global ones for the default engine, or new ones otherwise.
+func (e *Engine) setupTunnel(*Key) error {
+	if e.global {
+		e.tunnel, e.manager = tunnel.T(), statistic.DefaultManager
+		// The global Tunnel may have been drained by the last stop.
+		e.tunnel.SetDraining(false)
+		return nil
+	}
+
+	e.manager = statistic.NewManager()
+	e.tunnel = tunnel.New(&reject.Reject{}, e.manager)
+	e.tunnel.ProcessAsync()
+	e.addCleanup(func() error {
+		e.tunnel.Close()
+		e.manager.Close()
+		e.tunnel, e.manager = nil, nil
+		return nil
+	})
+	return nil
+}
+
+func (e *Engine) general(k *Key) error {
 	level, err := log.ParseLevel(k.LogLevel)
 	if err != nil {
 		return err
 	}
 	log.SetLogger(log.Must(log.NewLeveled(level)))
 
-	// Reset default dialer before registering options.
-	dialer.Reset()
+	sockOpts, err := parseSockOpts(k)
+	if err != nil {
+		return err
+	}
+	setSockOpts(sockOpts)
 
 	if k.Interface != "" {
-		iface, err := net.InterfaceByName(k.Interface)
-		if err != nil {
-			return err
-		}
-		dialer.RegisterSockOpt(dialer.WithBindToInterface(iface))
 		log.Infof("[DIALER] bind to interface: %s", k.Interface)
 	}
-
 	if k.Mark != 0 {
-		dialer.RegisterSockOpt(dialer.WithRoutingMark(k.Mark))
 		log.Infof("[DIALER] set fwmark: %#x", k.Mark)
 	}
 
-	if k.UDPTimeout > 0 {
-		if k.UDPTimeout < time.Second {
-			return errors.New("invalid udp timeout value")
-		}
-		tunnel.T().SetUDPTimeout(k.UDPTimeout)
+	if err := checkUDPTimeout(k.UDPTimeout); err != nil {
+		return err
+	}
+	e.tunnel.SetUDPTimeout(k.UDPTimeout)
+
+	natType, err := parseUDPNAT(k.UDPNAT)
+	if err != nil {
+		return err
+	}
+	e.tunnel.SetUDPNATType(natType)
+	if k.UDPNAT != "" {
+		log.Infof("[UDP] NAT type: %s", natType)
+	}
+
+	forwarding, err := parseICMPMode(k.ICMPMode)
+	if err != nil {
+		return err
+	}
+	e.tunnel.SetICMPForwarding(forwarding)
+	if forwarding {
+		log.Infof("[ICMP] forward echo requests")
+	}
+
+	limits, err := parseRateLimits(k)
+	if err != nil {
+		return err
+	}
+	e.tunnel.Shaper().SetLimits(limits)
+	if limits != (shaper.Limits{}) {
+		log.Infof("[SHAPER] rate limits: %+v", limits)
+	}
+
+	sessionLimits, err := parseSessionLimits(k)
+	if err != nil {
+		return err
+	}
+	e.tunnel.SetSessionLimits(sessionLimits)
+	if sessionLimits != (tunnel.SessionLimits{}) {
+		log.Infof("[STACK] session limits: %+v", sessionLimits)
 	}
 	return nil
 }
 
-func restAPI(k *Key) error {
+func (e *Engine) restAPI(k *Key) error {
 	if k.RestAPI != "" {
 		u, err := parseRestAPI(k.RestAPI)
 		if err != nil {
@@ -156,50 +474,97 @@ func restAPI(k *Key) error {
 		}
 		host, token := u.Host, u.User.String()
 
-		restapi.SetStatsFunc(func() tcpip.Stats {
-			_engineMu.Lock()
-			defer _engineMu.Unlock()
+		s := restapi.NewServer(e.manager)
+		s.SetCaptureFunc(func(w io.Writer, opts *capture.Options) (*capture.Session, error) {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+
+			if len(e.captureEndpoints) == 0 {
+				return nil, errors.New("no device to capture")
+			}
+			return capture.Start(w, e.captureEndpoints, opts)
+		})
+
+		s.SetStatsFunc(func() tcpip.Stats {
+			e.mu.Lock()
+			defer e.mu.Unlock()
 
-			// default stack is not initialized.
-			if _defaultStack == nil {
+			// stack is not initialized.
+			if e.stack == nil {
 				return tcpip.Stats{}
 			}
-			return _defaultStack.Stats()
+			return e.stack.Stats()
 		})
 
+		s.SetProxyFuncs(e.Proxy, func(url string) (string, error) {
+			if err := e.SetProxy(url); err != nil {
+				return "", err
+			}
+			return e.Proxy(), nil
+		})
+
+		s.SetRateLimitFuncs(e.RateLimits, e.SetRateLimits)
+		s.SetQuotaFuncs(e.tunnel.Quota().Status, e.tunnel.Quota().Reset)
+		s.SetSessionFuncs(e.SessionStats, e.SetSessionLimits)
+
+		if loader := e.loader; loader != nil {
+			s.SetReloadFunc(func() ([]string, error) {
+				k, err := loader()
+				if err != nil {
+					return nil, err
+				}
+				return e.Reload(k)
+			})
+		}
+
+		listener, err := net.Listen("tcp", host)
+		if err != nil {
+			return fmt.Errorf("restapi: %w", err)
+		}
+		srv := &http.Server{Handler: s.Handler(token)}
 		go func() {
-			if err := restapi.Start(host, token); err != nil {
-				log.Errorf("[RESTAPI] failed to start: %v", err)
+			if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+				log.Errorf("[RESTAPI] failed to serve: %v", err)
 			}
 		}()
+		e.addCleanup(srv.Close)
 		log.Infof("[RESTAPI] serve at: %s", u)
 	}
 	return nil
 }
 
-func netstack(k *Key) (err error) {
+func (e *Engine) netstack(k *Key) (err error) {
 	if k.Proxy == "" {
 		return errors.New("empty proxy")
 	}
-	if k.Device == "" {
+	var devices []string
+	if k.Device != "" {
+		devices = append(devices, k.Device)
+	}
+	devices = append(devices, k.Devices...)
+	if len(devices) == 0 {
 		return errors.New("empty device")
 	}
 
-	if k.TUNPreUp != "" {
-		log.Infof("[TUN] pre-execute command: `%s`", k.TUNPreUp)
-		if preUpErr := execCommand(k.TUNPreUp); preUpErr != nil {
-			log.Errorf("[TUN] failed to pre-execute: %s: %v", k.TUNPreUp, preUpErr)
-		}
-	}
+	runHook(k, "pre-up", k.TUNPreUp)
+	// The post-down hook is registered before anything else
+	// of the stack, so that it runs after all of them.
+	e.addCleanup(func() error {
+		runHook(k, "post-down", k.TUNPostDown)
+		return nil
+	})
 
 	defer func() {
-		if k.TUNPostUp == "" || err != nil {
+		if err != nil {
 			return
 		}
-		log.Infof("[TUN] post-execute command: `%s`", k.TUNPostUp)
-		if postUpErr := execCommand(k.TUNPostUp); postUpErr != nil {
-			log.Errorf("[TUN] failed to post-execute: %s: %v", k.TUNPostUp, postUpErr)
-		}
+		runHook(k, "post-up", k.TUNPostUp)
+		// The pre-down hook is registered after everything
+		// else, so that it runs before all of them.
+		e.addCleanup(func() error {
+			runHook(k, "pre-down", k.TUNPreDown)
+			return nil
+		})
 	}()
 
 	multicastGroups, err := parseMulticastGroups(k.MulticastGroups)
@@ -207,46 +572,83 @@ func netstack(k *Key) (err error) {
 		return err
 	}
 
-	if _defaultProxy, err = parseProxy(k.Proxy); err != nil {
+	conf, err := parseNetConfig(k)
+	if err != nil {
 		return err
 	}
-	tunnel.T().SetProxy(_defaultProxy)
 
-	if _defaultDevice, err = parseDevice(k.Device, uint32(k.MTU)); err != nil {
+	if e.proxy, err = parseProxy(k.Proxy); err != nil {
 		return err
 	}
+	e.tunnel.SetProxy(e.proxy)
 
-	var opts []option.Option
-	if k.TCPModerateReceiveBuffer {
-		opts = append(opts, option.WithTCPModerateReceiveBuffer(true))
-	}
+	e.devices = make([]device.Device, 0, len(devices))
+	e.addCleanup(func() error {
+		e.closeDevices()
+		return nil
+	})
 
-	if k.TCPSendBufferSize != "" {
-		size, err := units.RAMInBytes(k.TCPSendBufferSize)
+	var endpoints []stack.LinkEndpoint
+	for _, s := range devices {
+		d, err := parseDevice(s, uint32(k.MTU))
 		if err != nil {
-			return err
+			return fmt.Errorf("device %s: %w", s, err)
 		}
-		opts = append(opts, option.WithTCPSendBufferSize(int(size)))
+		// Devices are wrapped for packet capture, which costs
+		// nothing but an atomic load if there are no captures.
+		ep := capture.Wrap(d)
+		e.devices = append(e.devices, d)
+		e.captureEndpoints = append(e.captureEndpoints, ep)
+		endpoints = append(endpoints, ep)
 	}
 
-	if k.TCPReceiveBufferSize != "" {
-		size, err := units.RAMInBytes(k.TCPReceiveBufferSize)
-		if err != nil {
-			return err
-		}
-		opts = append(opts, option.WithTCPReceiveBufferSize(int(size)))
+	opts, err := parseTCPOptions(k)
+	if err != nil {
+		return err
+	}
+
+	icmpHandler := e.icmpHandler
+	if icmpHandler == nil {
+		icmpHandler = e.tunnel
 	}
 
-	if _defaultStack, err = core.CreateStack(&core.Config{
-		LinkEndpoint:     _defaultDevice,
-		TransportHandler: tunnel.T(),
-		ICMPHandler:      _icmpHandler,
-		MulticastGroups:  multicastGroups,
-		Options:          opts,
+	if e.stack, err = core.CreateStack(&core.Config{
+		LinkEndpoints:      endpoints,
+		TransportHandler:   e.tunnel,
+		ICMPHandler:        icmpHandler,
+		MulticastGroups:    multicastGroups,
+		TCPMaxConnAttempts: k.TCPMaxConnAttempts,
+		Options:            opts,
 	}); err != nil {
 		return err
 	}
+	e.addCleanup(func() error {
+		// Devices are closed before the stack, which waits for the
+		// read loops of io-based devices to exit on close.
+		e.closeDevices()
+		e.stack.Close()
+		e.stack.Wait()
+		e.stack = nil
+		return nil
+	})
+
+	if conf != nil {
+		// The configuration applies to the first device, which is
+		// the TUN in most cases.
+		conf.name = e.devices[0].Name()
+		netCleanup, err := conf.apply()
+		if err != nil {
+			return fmt.Errorf("configure %s: %w", conf.name, err)
+		}
+		e.addCleanup(netCleanup)
+	}
+
+	if k.Capture != "" {
+		if err := e.startCapture(k); err != nil {
+			return fmt.Errorf("capture: %w", err)
+		}
+	}
 
-	log.Infof("[STACK] %s <-> %s", k.Device, k.Proxy)
+	log.Infof("[STACK] %s <-> %s", strings.Join(devices, ", "), k.Proxy)
 	return nil
 }
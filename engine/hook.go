@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/shlex"
+
+	"github.com/xjasonlyu/tun2socks/v2/log"
+)
+
+// _defaultHookTimeout is the default timeout of TUN hooks.
+const _defaultHookTimeout = 30 * time.Second
+
+// runHook executes the TUN hook cmd of stage, e.g. pre-up. Failures of
+// hooks are logged only, so they don't prevent the engine from starting
+// or stopping.
+func runHook(k *Key, stage, cmd string) {
+	if cmd == "" {
+		return
+	}
+
+	timeout := k.TUNHookTimeout
+	if timeout <= 0 {
+		timeout = _defaultHookTimeout
+	}
+
+	log.Infof("[TUN] execute %s command: `%s`", stage, cmd)
+	output, err := execCommand(cmd, hookEnv(k), timeout)
+	for line := range strings.Lines(string(output)) {
+		log.Infof("[TUN] %s: %s", stage, strings.TrimRight(line, "\r\n"))
+	}
+	if err != nil {
+		log.Errorf("[TUN] failed to execute %s command: %s: %v", stage, cmd, err)
+	}
+}
+
+// execCommand executes cmd with extra environment variables env, and
+// returns the combined output of it. The command is killed on timeout.
+func execCommand(cmd string, env []string, timeout time.Duration) ([]byte, error) {
+	parts, err := shlex.Split(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("empty command")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	c := exec.CommandContext(ctx, parts[0], parts[1:]...)
+	c.Env = append(os.Environ(), env...)
+	// Don't wait for the output of orphaned children forever.
+	c.WaitDelay = time.Second
+
+	var output bytes.Buffer
+	c.Stdout = &output
+	c.Stderr = &output
+	err = c.Run()
+	if ctx.Err() != nil {
+		err = fmt.Errorf("timed out after %v", timeout)
+	}
+	return output.Bytes(), err
+}
+
+// hookEnv returns the environment variables describing the TUN to hooks:
+//
+//	TUN_NAME    name of the TUN interface
+//	TUN_MTU     MTU of the TUN interface
+//	TUN_ADDRESS addresses of the TUN interface in CIDR, separated by commas
+//
+// The values are taken from the interface if it exists, or from k if not.
+func hookEnv(k *Key) []string {
+	name := deviceName(k)
+	mtu := k.MTU
+	addrs := k.TUNAddress
+
+	if iface, err := net.InterfaceByName(name); err == nil {
+		mtu = iface.MTU
+		if ifAddrs, err := iface.Addrs(); err == nil && len(ifAddrs) > 0 {
+			addrs = make([]string, 0, len(ifAddrs))
+			for _, addr := range ifAddrs {
+				addrs = append(addrs, addr.String())
+			}
+		}
+	}
+
+	return []string{
+		"TUN_NAME=" + name,
+		"TUN_MTU=" + strconv.Itoa(mtu),
+		"TUN_ADDRESS=" + strings.Join(addrs, ","),
+	}
+}
+
+// deviceName returns the name of the first device of k.
+func deviceName(k *Key) string {
+	s := k.Device
+	if s == "" && len(k.Devices) > 0 {
+		s = k.Devices[0]
+	}
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	if u, err := url.Parse(s); err == nil {
+		return u.Host
+	}
+	return s
+}
@@ -0,0 +1,72 @@
+//go:build unix
+
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeHook writes a shell script hook, which appends its first argument,
+// i.e. the stage, to the returned log file.
+func writeHook(t *testing.T) (script, logFile string) {
+	dir := t.TempDir()
+	script = filepath.Join(dir, "hook.sh")
+	logFile = filepath.Join(dir, "hook.log")
+	content := fmt.Sprintf("#!/bin/sh\necho \"$1\" >> %s\n", logFile)
+	require.NoError(t, os.WriteFile(script, []byte(content), 0o755))
+	return script, logFile
+}
+
+func TestHookEnv(t *testing.T) {
+	k := &Key{
+		Device:     "tun://t2shook0",
+		MTU:        1400,
+		TUNAddress: []string{"198.18.0.1/15", "fd00::1/64"},
+	}
+	// The values are taken from k, as the interface doesn't exist.
+	output, err := execCommand(`sh -c 'echo "$TUN_NAME $TUN_MTU $TUN_ADDRESS"'`, hookEnv(k), time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "t2shook0 1400 198.18.0.1/15,fd00::1/64\n", string(output))
+}
+
+func TestHookTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := execCommand("sleep 10", nil, 100*time.Millisecond)
+	assert.ErrorContains(t, err, "timed out")
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	_, err = execCommand("", nil, time.Second)
+	assert.Error(t, err)
+}
+
+func TestHookOrder(t *testing.T) {
+	keepLogLevel(t)
+	script, logFile := writeHook(t)
+	k := &Key{
+		LogLevel:    "silent",
+		Proxy:       "direct://",
+		Device:      "unix://" + filepath.Join(t.TempDir(), "hook.sock") + "?listen=true",
+		TUNPreUp:    script + " pre-up",
+		TUNPostUp:   script + " post-up",
+		TUNPreDown:  script + " pre-down",
+		TUNPostDown: script + " post-down",
+	}
+	e, err := New(Config{Key: k})
+	require.NoError(t, err)
+	require.NoError(t, e.Start())
+	require.NoError(t, e.Stop())
+
+	data, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	// The down hooks run in the reverse order of the up ones, around
+	// the rest of the cleanup.
+	assert.Equal(t, []string{"pre-up", "post-up", "pre-down", "post-down"}, strings.Fields(string(data)))
+}
@@ -3,18 +3,62 @@ package engine
 import "time"
 
 type Key struct {
-	MTU                      int           `yaml:"mtu"`
-	Mark                     int           `yaml:"fwmark"`
-	Proxy                    string        `yaml:"proxy"`
-	RestAPI                  string        `yaml:"restapi"`
-	Device                   string        `yaml:"device"`
-	LogLevel                 string        `yaml:"loglevel"`
-	Interface                string        `yaml:"interface"`
-	TCPModerateReceiveBuffer bool          `yaml:"tcp-moderate-receive-buffer"`
-	TCPSendBufferSize        string        `yaml:"tcp-send-buffer-size"`
-	TCPReceiveBufferSize     string        `yaml:"tcp-receive-buffer-size"`
-	MulticastGroups          []string      `yaml:"multicast-groups"`
-	TUNPreUp                 string        `yaml:"tun-pre-up"`
-	TUNPostUp                string        `yaml:"tun-post-up"`
-	UDPTimeout               time.Duration `yaml:"udp-timeout"`
+	MTU                       int           `yaml:"mtu"`
+	Mark                      int           `yaml:"fwmark"`
+	Proxy                     string        `yaml:"proxy"`
+	RestAPI                   string        `yaml:"restapi"`
+	Device                    string        `yaml:"device"`
+	Devices                   []string      `yaml:"devices"`
+	LogLevel                  string        `yaml:"loglevel"`
+	Interface                 string        `yaml:"interface"`
+	TCPModerateReceiveBuffer  bool          `yaml:"tcp-moderate-receive-buffer"`
+	TCPSendBufferSize         string        `yaml:"tcp-send-buffer-size"`
+	TCPReceiveBufferSize      string        `yaml:"tcp-receive-buffer-size"`
+	MulticastGroups           []string      `yaml:"multicast-groups"`
+	TUNPreUp                  string        `yaml:"tun-pre-up"`
+	TUNPostUp                 string        `yaml:"tun-post-up"`
+	TUNPreDown                string        `yaml:"tun-pre-down"`
+	TUNPostDown               string        `yaml:"tun-post-down"`
+	TUNHookTimeout            time.Duration `yaml:"tun-hook-timeout"`
+	TUNAddress                []string      `yaml:"tun-address"`
+	TUNAutoRoute              bool          `yaml:"tun-auto-route"`
+	TUNRouteTable             int           `yaml:"tun-route-table"`
+	TUNIncludedRoutes         []string      `yaml:"tun-included-routes"`
+	TUNExcludedRoutes         []string      `yaml:"tun-excluded-routes"`
+	UDPTimeout                time.Duration `yaml:"udp-timeout"`
+	UDPNAT                    string        `yaml:"udp-nat"`
+	ICMPMode                  string        `yaml:"icmp-mode"`
+	Capture                   string        `yaml:"capture"`
+	CaptureFilter             string        `yaml:"capture-filter"`
+	CaptureMaxSize            string        `yaml:"capture-max-size"`
+	CaptureDuration           time.Duration `yaml:"capture-duration"`
+	DrainTimeout              time.Duration `yaml:"drain-timeout"`
+	AccessLog                 string        `yaml:"access-log"`
+	AccessLogFormat           string        `yaml:"access-log-format"`
+	AccessLogMaxSize          string        `yaml:"access-log-max-size"`
+	AccessLogRotateInterval   time.Duration `yaml:"access-log-rotate-interval"`
+	AccessLogMaxBackups       int           `yaml:"access-log-max-backups"`
+	AccessLogMaxAge           time.Duration `yaml:"access-log-max-age"`
+	AccountingFile            string        `yaml:"accounting-file"`
+	AccountingInterval        time.Duration `yaml:"accounting-interval"`
+	RateLimitUpload           string        `yaml:"rate-limit-upload"`
+	RateLimitDownload         string        `yaml:"rate-limit-download"`
+	RateLimitSourceUpload     string        `yaml:"rate-limit-source-upload"`
+	RateLimitSourceDownload   string        `yaml:"rate-limit-source-download"`
+	RateLimitOutboundUpload   string        `yaml:"rate-limit-outbound-upload"`
+	RateLimitOutboundDownload string        `yaml:"rate-limit-outbound-download"`
+	RateLimitConnUpload       string        `yaml:"rate-limit-conn-upload"`
+	RateLimitConnDownload     string        `yaml:"rate-limit-conn-download"`
+	QuotaFile                 string        `yaml:"quota-file"`
+	QuotaPeriod               string        `yaml:"quota-period"`
+	QuotaSource               string        `yaml:"quota-source"`
+	QuotaOutbound             string        `yaml:"quota-outbound"`
+	QuotaAction               string        `yaml:"quota-action"`
+	QuotaFallbackProxy        string        `yaml:"quota-fallback-proxy"`
+	QuotaThrottle             string        `yaml:"quota-throttle"`
+	MaxTCPSessions            int           `yaml:"max-tcp-sessions"`
+	MaxUDPSessions            int           `yaml:"max-udp-sessions"`
+	MaxTCPSessionsPerSource   int           `yaml:"max-tcp-sessions-per-source"`
+	MaxUDPSessionsPerSource   int           `yaml:"max-udp-sessions-per-source"`
+	TCPMaxConnAttempts        int           `yaml:"tcp-max-conn-attempts"`
 }
@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// _defaultRouteTable is the routing table for the TUN, the same as
+// the one configured by docker/entrypoint.sh.
+const _defaultRouteTable = 0x22b
+
+// netConfig is the network configuration of the TUN, which is
+// applied by the engine on start and removed on stop.
+type netConfig struct {
+	// name is the name of the TUN interface.
+	name string
+
+	// addrs are the addresses assigned to the TUN.
+	addrs []netip.Prefix
+
+	// autoRoute enables policy routing of traffic into the TUN, with
+	// a default route in table. Traffic with mark is routed by the main
+	// table, so that connections of the proxy do not loop back.
+	autoRoute bool
+	table     int
+	mark      int
+
+	// included are the destinations routed into the TUN. All traffic
+	// is routed into the TUN if there are none.
+	included []netip.Prefix
+
+	// excluded are the destinations which bypass the TUN.
+	excluded []netip.Prefix
+}
+
+func parseNetConfig(k *Key) (*netConfig, error) {
+	if len(k.TUNAddress) == 0 && !k.TUNAutoRoute {
+		return nil, nil
+	}
+
+	c := &netConfig{
+		autoRoute: k.TUNAutoRoute,
+		table:     k.TUNRouteTable,
+		mark:      k.Mark,
+	}
+	if c.table == 0 {
+		c.table = _defaultRouteTable
+	}
+
+	var err error
+	if c.addrs, err = parsePrefixes(k.TUNAddress); err != nil {
+		return nil, fmt.Errorf("tun address: %w", err)
+	}
+	if c.included, err = parsePrefixes(k.TUNIncludedRoutes); err != nil {
+		return nil, fmt.Errorf("tun included routes: %w", err)
+	}
+	if c.excluded, err = parsePrefixes(k.TUNExcludedRoutes); err != nil {
+		return nil, fmt.Errorf("tun excluded routes: %w", err)
+	}
+
+	// Without a fwmark, connections of the proxy would be routed
+	// back into the TUN by the catch-all rule.
+	if c.autoRoute && len(c.included) == 0 && c.mark == 0 {
+		return nil, errors.New("tun auto route requires fwmark or included routes")
+	}
+	return c, nil
+}
+
+func parsePrefixes(ss []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(ss))
+	for _, s := range ss {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,281 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/xjasonlyu/tun2socks/v2/log"
+)
+
+// Priorities of the policy rules, which are evaluated before
+// the main table (32766) in ascending order.
+const (
+	_excludedRulePriority = 9000 + iota
+	_prohibitRulePriority
+	_markRulePriority
+	_includedRulePriority
+)
+
+// apply applies the configuration via netlink, and returns a function
+// removing everything applied. The configuration is rolled back if it
+// is not applied completely.
+func (c *netConfig) apply() (_ func() error, err error) {
+	link, err := netlink.LinkByName(c.name)
+	if err != nil {
+		return nil, fmt.Errorf("find link %s: %w", c.name, err)
+	}
+
+	var undo []func() error
+	cleanup := func() error {
+		var errs []error
+		for _, f := range slices.Backward(undo) {
+			if err := f(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			return fmt.Errorf("clean up %s: %w", c.name, err)
+		}
+		return nil
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if cleanupErr := cleanup(); cleanupErr != nil {
+			log.Warnf("[TUN] failed to roll back: %v", cleanupErr)
+		}
+	}()
+
+	for _, p := range c.addrs {
+		addr := &netlink.Addr{IPNet: toIPNet(p)}
+		existed, err := hasAddr(link, p)
+		if err != nil {
+			return nil, fmt.Errorf("list addresses: %w", err)
+		}
+		if err := netlink.AddrReplace(link, addr); err != nil {
+			return nil, fmt.Errorf("add address %s: %w", p, err)
+		}
+		// Addresses assigned before are left on cleanup.
+		if !existed {
+			undo = append(undo, func() error { return netlink.AddrDel(link, addr) })
+		}
+		log.Infof("[TUN] add address %s to %s", p, c.name)
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("set link up: %w", err)
+	}
+
+	if !c.autoRoute {
+		return cleanup, nil
+	}
+
+	// The families of the default routes added, to which the rules
+	// without destinations apply.
+	var families []int
+	for _, family := range c.families() {
+		route := &netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Dst:       defaultDst(family),
+			Table:     c.table,
+			Family:    family,
+		}
+		prev, err := defaultRoute(c.table, family)
+		if err == nil {
+			err = netlink.RouteReplace(route)
+		}
+		if err != nil && c.assumesFamilies() && unsupported(family, err) {
+			// IPv6 is skipped if it's disabled in the kernel, unless
+			// it's configured.
+			log.Warnf("[TUN] skip IPv6 routes: %v", err)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("add default route: %w", err)
+		}
+		// The default route replaced is restored on cleanup.
+		switch {
+		case prev == nil:
+			undo = append(undo, func() error { return netlink.RouteDel(route) })
+		case prev.LinkIndex != route.LinkIndex:
+			undo = append(undo, func() error { return netlink.RouteReplace(prev) })
+		}
+		families = append(families, family)
+	}
+
+	addRule := func(rule *netlink.Rule) error {
+		err := netlink.RuleAdd(rule)
+		if errors.Is(err, unix.EEXIST) {
+			// Rules added before are left on cleanup.
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("add rule %s: %w", rule, err)
+		}
+		undo = append(undo, func() error { return netlink.RuleDel(rule) })
+		return nil
+	}
+
+	for _, p := range c.excluded {
+		rule := netlink.NewRule()
+		rule.Priority = _excludedRulePriority
+		rule.Family = family(p.Addr())
+		rule.Dst = toIPNet(p)
+		rule.Table = unix.RT_TABLE_MAIN
+		if err := addRule(rule); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.mark != 0 {
+		// Marked traffic to the TUN subnets is prohibited, as
+		// it would be routed back into the TUN by the main table.
+		for _, p := range c.addrs {
+			rule := netlink.NewRule()
+			rule.Priority = _prohibitRulePriority
+			rule.Family = family(p.Addr())
+			rule.Dst = toIPNet(p.Masked())
+			rule.Mark = uint32(c.mark)
+			rule.Type = unix.RTN_PROHIBIT
+			if err := addRule(rule); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if c.mark != 0 && len(c.included) > 0 {
+		// Marked traffic is routed by the main table, even if the
+		// proxy server is in the included routes. An inverted rule
+		// with a destination would negate the destination as well.
+		for _, family := range families {
+			rule := netlink.NewRule()
+			rule.Priority = _markRulePriority
+			rule.Family = family
+			rule.Mark = uint32(c.mark)
+			rule.Table = unix.RT_TABLE_MAIN
+			if err := addRule(rule); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(c.included) == 0 {
+		for _, family := range families {
+			rule := netlink.NewRule()
+			rule.Priority = _includedRulePriority
+			rule.Family = family
+			rule.Mark = uint32(c.mark)
+			rule.Invert = true
+			rule.Table = c.table
+			if err := addRule(rule); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, p := range c.included {
+		rule := netlink.NewRule()
+		rule.Priority = _includedRulePriority
+		rule.Family = family(p.Addr())
+		rule.Dst = toIPNet(p)
+		rule.Table = c.table
+		if err := addRule(rule); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Infof("[TUN] route traffic into %s with table %#x", c.name, c.table)
+	return cleanup, nil
+}
+
+// hasAddr returns whether the address p is assigned to link.
+func hasAddr(link netlink.Link, p netip.Prefix) (bool, error) {
+	addrs, err := netlink.AddrList(link, family(p.Addr()))
+	if err != nil {
+		return false, err
+	}
+	for _, a := range addrs {
+		if a.IPNet.String() == toIPNet(p).String() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// defaultRoute returns the default route of family in table, nil if
+// there is none.
+func defaultRoute(table, family int) (*netlink.Route, error) {
+	routes, err := netlink.RouteListFiltered(family, &netlink.Route{Table: table}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range routes {
+		if r.Dst == nil || r.Dst.IP.IsUnspecified() && isZeroMask(r.Dst.Mask) {
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+func isZeroMask(mask net.IPMask) bool {
+	ones, _ := mask.Size()
+	return ones == 0
+}
+
+// families returns the address families routed into the TUN, which are
+// of the addresses, or of the included and excluded routes if there are
+// no addresses, or both IPv4 and IPv6 if there are neither.
+func (c *netConfig) families() []int {
+	if c.assumesFamilies() {
+		return []int{unix.AF_INET, unix.AF_INET6}
+	}
+	prefixes := c.addrs
+	if len(prefixes) == 0 {
+		prefixes = slices.Concat(c.included, c.excluded)
+	}
+	var families []int
+	for _, p := range prefixes {
+		if f := family(p.Addr()); !slices.Contains(families, f) {
+			families = append(families, f)
+		}
+	}
+	return families
+}
+
+// assumesFamilies returns whether neither addresses nor routes are
+// configured, of which the families are assumed.
+func (c *netConfig) assumesFamilies() bool {
+	return len(c.addrs) == 0 && len(c.included) == 0 && len(c.excluded) == 0
+}
+
+// unsupported returns whether err is of family not supported by the
+// kernel, which is tolerated of IPv6 only.
+func unsupported(family int, err error) bool {
+	return family == unix.AF_INET6 &&
+		(errors.Is(err, unix.EAFNOSUPPORT) || errors.Is(err, unix.EOPNOTSUPP))
+}
+
+func family(addr netip.Addr) int {
+	if addr.Is4() {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
+
+func defaultDst(family int) *net.IPNet {
+	if family == unix.AF_INET {
+		return &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}
+	}
+	return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+}
+
+func toIPNet(p netip.Prefix) *net.IPNet {
+	return &net.IPNet{
+		IP:   p.Addr().AsSlice(),
+		Mask: net.CIDRMask(p.Bits(), p.Addr().BitLen()),
+	}
+}
@@ -0,0 +1,36 @@
+package engine
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestNetConfigFamilies(t *testing.T) {
+	prefixes := func(s ...string) []netip.Prefix {
+		var ps []netip.Prefix
+		for _, v := range s {
+			ps = append(ps, netip.MustParsePrefix(v))
+		}
+		return ps
+	}
+
+	for _, tt := range []struct {
+		name string
+		c    netConfig
+		want []int
+	}{
+		{"none", netConfig{}, []int{unix.AF_INET, unix.AF_INET6}},
+		{"addrs", netConfig{addrs: prefixes("198.18.0.1/15"), included: prefixes("fd00::/8")}, []int{unix.AF_INET}},
+		{"included", netConfig{included: prefixes("10.0.0.0/8")}, []int{unix.AF_INET}},
+		{"excluded", netConfig{excluded: prefixes("fd00::/8", "10.0.0.0/8")}, []int{unix.AF_INET6, unix.AF_INET}},
+	} {
+		assert.Equal(t, tt.want, tt.c.families(), tt.name)
+	}
+
+	assert.True(t, unsupported(unix.AF_INET6, unix.EAFNOSUPPORT))
+	assert.False(t, unsupported(unix.AF_INET, unix.EAFNOSUPPORT))
+	assert.False(t, unsupported(unix.AF_INET6, unix.EPERM))
+}
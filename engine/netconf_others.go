@@ -0,0 +1,9 @@
+//go:build !linux
+
+package engine
+
+import "errors"
+
+func (c *netConfig) apply() (func() error, error) {
+	return nil, errors.ErrUnsupported
+}
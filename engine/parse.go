@@ -1,17 +1,30 @@
 package engine
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
 	"net/url"
 	"runtime"
 	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/gorilla/schema"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
 
 	"github.com/xjasonlyu/tun2socks/v2/core/device"
 	"github.com/xjasonlyu/tun2socks/v2/core/device/fdbased"
+	"github.com/xjasonlyu/tun2socks/v2/core/device/tap"
 	"github.com/xjasonlyu/tun2socks/v2/core/device/tun"
+	"github.com/xjasonlyu/tun2socks/v2/core/device/unixsock"
+	"github.com/xjasonlyu/tun2socks/v2/core/option"
+	"github.com/xjasonlyu/tun2socks/v2/dialer"
 	"github.com/xjasonlyu/tun2socks/v2/proxy"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/quota"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/shaper"
 )
 
 func parseRestAPI(s string) (*url.URL, error) {
@@ -58,6 +71,10 @@ func parseDevice(s string, mtu uint32) (device.Device, error) {
 		return parseFD(u, mtu)
 	case tun.Driver:
 		return parseTUN(u, mtu)
+	case tap.Driver:
+		return parseTAP(u, mtu)
+	case unixsock.Driver:
+		return parseUnix(u, mtu)
 	default:
 		return nil, fmt.Errorf("unsupported driver: %s", driver)
 	}
@@ -73,6 +90,54 @@ func parseFD(u *url.URL, mtu uint32) (device.Device, error) {
 	return fdbased.Open(u.Host, mtu, offset)
 }
 
+func parseTAP(u *url.URL, mtu uint32) (device.Device, error) {
+	opts := struct {
+		DHCP string   `schema:"dhcp"`
+		DNS  []string `schema:"dns"`
+	}{}
+	if err := schema.NewDecoder().Decode(&opts, u.Query()); err != nil {
+		return nil, err
+	}
+	if opts.DHCP == "" {
+		return tap.Open(u.Host, mtu, nil)
+	}
+
+	prefix, err := netip.ParsePrefix(opts.DHCP)
+	if err != nil {
+		return nil, err
+	}
+	dhcp := &tap.DHCPConfig{Prefix: prefix}
+	for _, s := range opts.DNS {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, err
+		}
+		dhcp.DNS = append(dhcp.DNS, addr)
+	}
+	return tap.Open(u.Host, mtu, dhcp)
+}
+
+func parseUnix(u *url.URL, mtu uint32) (device.Device, error) {
+	opts := struct {
+		Type   string `schema:"type"`
+		Listen bool   `schema:"listen"`
+		Local  string `schema:"local"`
+	}{}
+	if err := schema.NewDecoder().Decode(&opts, u.Query()); err != nil {
+		return nil, err
+	}
+	typ, err := unixsock.ParseSocketType(opts.Type)
+	if err != nil {
+		return nil, err
+	}
+	// Both unix:///abs/path and unix://rel/path are accepted.
+	return unixsock.Open(u.Host+u.Path, mtu, unixsock.Options{
+		Type:   typ,
+		Listen: opts.Listen,
+		Local:  opts.Local,
+	})
+}
+
 func parseProxy(s string) (proxy.Proxy, error) {
 	if !strings.Contains(s, "://") {
 		s = fmt.Sprintf("%s://%s", "socks5" /* default */, s)
@@ -82,7 +147,14 @@ func parseProxy(s string) (proxy.Proxy, error) {
 	if err != nil {
 		return nil, err
 	}
-	return proxy.Parse(u)
+	p, err := proxy.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	// Credentials may be anywhere in the userinfo, e.g. ss://BASE64@,
+	// or the query, e.g. ?passphrase=, so only the protocol and host
+	// are reported with the connections.
+	return proxy.Named(p, fmt.Sprintf("%s://%s", u.Scheme, u.Host)), nil
 }
 
 func parseMulticastGroups(v []string) ([]netip.Addr, error) {
@@ -102,3 +174,163 @@ func parseMulticastGroups(v []string) ([]netip.Addr, error) {
 	}
 	return groups, nil
 }
+
+func parseSockOpts(k *Key) ([]dialer.SocketOption, error) {
+	var opts []dialer.SocketOption
+	if k.Interface != "" {
+		iface, err := net.InterfaceByName(k.Interface)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, dialer.WithBindToInterface(iface))
+	}
+	if k.Mark != 0 {
+		opts = append(opts, dialer.WithRoutingMark(k.Mark))
+	}
+	return opts, nil
+}
+
+// setSockOpts replaces the socket options of the default dialer.
+func setSockOpts(opts []dialer.SocketOption) {
+	dialer.Reset()
+	for _, opt := range opts {
+		dialer.RegisterSockOpt(opt)
+	}
+}
+
+func checkUDPTimeout(timeout time.Duration) error {
+	if timeout != 0 && timeout < time.Second {
+		return errors.New("invalid udp timeout value")
+	}
+	return nil
+}
+
+func parseUDPNAT(s string) (tunnel.NATType, error) {
+	if s == "" {
+		return tunnel.SymmetricNAT, nil
+	}
+	return tunnel.ParseNATType(s)
+}
+
+// parseICMPMode returns whether ICMP echo requests are forwarded.
+func parseICMPMode(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "", "local":
+		return false, nil
+	case "forward":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid icmp mode: %s", s)
+	}
+}
+
+// parseTCPOptions returns the TCP options of the stack, which are the
+// defaults of those not set, so that they can be applied live.
+func parseTCPOptions(k *Key) ([]option.Option, error) {
+	sndSize, rcvSize := int64(tcp.DefaultSendBufferSize), int64(tcp.DefaultReceiveBufferSize)
+
+	var err error
+	if k.TCPSendBufferSize != "" {
+		if sndSize, err = units.RAMInBytes(k.TCPSendBufferSize); err != nil {
+			return nil, err
+		}
+	}
+	if k.TCPReceiveBufferSize != "" {
+		if rcvSize, err = units.RAMInBytes(k.TCPReceiveBufferSize); err != nil {
+			return nil, err
+		}
+	}
+
+	// The sizes are validated here rather than by the stack, so that
+	// none of the options fails to be applied live.
+	for _, size := range []int64{sndSize, rcvSize} {
+		if size < tcp.MinBufferSize || size > tcp.MaxBufferSize {
+			return nil, fmt.Errorf("invalid tcp buffer size: %d", size)
+		}
+	}
+
+	return []option.Option{
+		option.WithTCPModerateReceiveBuffer(k.TCPModerateReceiveBuffer),
+		option.WithTCPSendBufferSize(int(sndSize)),
+		option.WithTCPReceiveBufferSize(int(rcvSize)),
+	}, nil
+}
+
+// parseRateLimits returns the rate limits of k, in bytes per second.
+func parseRateLimits(k *Key) (shaper.Limits, error) {
+	var limits shaper.Limits
+	for _, v := range []struct {
+		s string
+		r *int64
+	}{
+		{k.RateLimitUpload, &limits.Global.Upload},
+		{k.RateLimitDownload, &limits.Global.Download},
+		{k.RateLimitSourceUpload, &limits.Source.Upload},
+		{k.RateLimitSourceDownload, &limits.Source.Download},
+		{k.RateLimitOutboundUpload, &limits.Outbound.Upload},
+		{k.RateLimitOutboundDownload, &limits.Outbound.Download},
+		{k.RateLimitConnUpload, &limits.Connection.Upload},
+		{k.RateLimitConnDownload, &limits.Connection.Download},
+	} {
+		if v.s == "" {
+			continue
+		}
+		r, err := units.RAMInBytes(v.s)
+		if err != nil {
+			return shaper.Limits{}, fmt.Errorf("invalid rate limit: %w", err)
+		}
+		if r < 0 {
+			return shaper.Limits{}, fmt.Errorf("invalid rate limit: %s", v.s)
+		}
+		*v.r = r
+	}
+	return limits, nil
+}
+
+// parseQuota returns the configuration of quotas of k, and the fallback
+// proxy of those over quota, which is nil if not set.
+func parseQuota(k *Key) (cfg quota.Config, fallback proxy.Proxy, err error) {
+	cfg.Period = strings.ToLower(k.QuotaPeriod)
+	cfg.Action = strings.ToLower(k.QuotaAction)
+	for _, v := range []struct {
+		s string
+		n *int64
+	}{
+		{k.QuotaSource, &cfg.Source},
+		{k.QuotaOutbound, &cfg.Outbound},
+		{k.QuotaThrottle, &cfg.Throttle},
+	} {
+		if v.s == "" {
+			continue
+		}
+		if *v.n, err = units.RAMInBytes(v.s); err != nil {
+			return quota.Config{}, nil, fmt.Errorf("invalid quota: %w", err)
+		}
+	}
+	if err = cfg.Validate(); err != nil {
+		return quota.Config{}, nil, err
+	}
+
+	if k.QuotaFallbackProxy != "" {
+		if fallback, err = parseProxy(k.QuotaFallbackProxy); err != nil {
+			return quota.Config{}, nil, err
+		}
+	} else if cfg.Action == quota.ActionFallback {
+		return quota.Config{}, nil, errors.New("empty quota fallback proxy")
+	}
+	return cfg, fallback, nil
+}
+
+// parseSessionLimits returns the limits of concurrent sessions of k.
+func parseSessionLimits(k *Key) (tunnel.SessionLimits, error) {
+	limits := tunnel.SessionLimits{
+		TCP:          k.MaxTCPSessions,
+		UDP:          k.MaxUDPSessions,
+		TCPPerSource: k.MaxTCPSessionsPerSource,
+		UDPPerSource: k.MaxUDPSessionsPerSource,
+	}
+	if err := limits.Validate(); err != nil {
+		return tunnel.SessionLimits{}, err
+	}
+	return limits, nil
+}
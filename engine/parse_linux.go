@@ -0,0 +1,35 @@
+package engine
+
+import (
+	"net/url"
+
+	"github.com/gorilla/schema"
+
+	"github.com/xjasonlyu/tun2socks/v2/core/device"
+	"github.com/xjasonlyu/tun2socks/v2/core/device/tun"
+	"github.com/xjasonlyu/tun2socks/v2/log"
+)
+
+func parseTUN(u *url.URL, mtu uint32) (device.Device, error) {
+	opts := struct {
+		Queues  int  `schema:"queues"`
+		Offload bool `schema:"offload"`
+	}{
+		Queues: 1,
+	}
+	if err := schema.NewDecoder().Decode(&opts, u.Query()); err != nil {
+		return nil, err
+	}
+
+	d, err := tun.OpenWithOptions(u.Host, mtu, tun.Options{
+		Queues:  opts.Queues,
+		Offload: opts.Offload,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opts.Offload && !d.(*tun.TUN).Offload() {
+		log.Warnf("[TUN] offload is not supported by %s, fall back to plain packets", u.Host)
+	}
+	return d, nil
+}
@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xjasonlyu/tun2socks/v2/log"
+)
+
+// persistEvery saves what snapshot returns to path as JSON every
+// interval, as well as on stop, so that it survives restarts.
+func (e *Engine) persistEvery(path string, interval time.Duration, snapshot func() any) {
+	save := func() error {
+		data, err := json.Marshal(snapshot())
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(path, data)
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := save(); err != nil {
+					log.Warnf("[ENGINE] save to %s: %v", path, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	e.addCleanup(func() error {
+		close(done)
+		<-stopped
+		return save()
+	})
+}
+
+// writeFileAtomic writes data to a temporary file, and renames it to
+// path, so that the file is never left half written.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
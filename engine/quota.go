@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/xjasonlyu/tun2socks/v2/log"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/quota"
+)
+
+// quotaSaveInterval is how often the traffic counted against quotas is
+// saved.
+const quotaSaveInterval = time.Minute
+
+// quotas sets the quotas of the Tunnel, and if k.QuotaFile is set,
+// restores the traffic counted in the period from it, and saves it
+// periodically, as well as on stop, so that it survives restarts.
+func (e *Engine) quotas(k *Key) error {
+	cfg, fallback, err := parseQuota(k)
+	if err != nil {
+		return err
+	}
+	q := e.tunnel.Quota()
+	if err := q.SetConfig(cfg); err != nil {
+		return err
+	}
+	e.tunnel.SetFallbackProxy(fallback)
+	if cfg.Source > 0 || cfg.Outbound > 0 {
+		status := q.Status()
+		log.Infof("[QUOTA] %s quota of source: %d, outbound: %d, action: %s",
+			status.Period, cfg.Source, cfg.Outbound, status.Action)
+	}
+
+	if k.QuotaFile == "" {
+		return nil
+	}
+	path := k.QuotaFile
+
+	if err := loadQuota(path, q); err != nil {
+		return err
+	}
+
+	e.persistEvery(path, quotaSaveInterval, func() any { return q.State() })
+	log.Infof("[QUOTA] save to %s every %s", path, quotaSaveInterval)
+	return nil
+}
+
+func loadQuota(path string, q *quota.Quota) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	state := &quota.State{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return err
+	}
+	q.Restore(state)
+	return nil
+}
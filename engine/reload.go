@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/xjasonlyu/tun2socks/v2/log"
+)
+
+// _liveSettings are the settings applied live on reload, by their
+// names in the configuration file. Changes of the others require
+// restart.
+var _liveSettings = map[string]bool{
+	"proxy":                        true,
+	"loglevel":                     true,
+	"interface":                    true,
+	"fwmark":                       true,
+	"udp-timeout":                  true,
+	"udp-nat":                      true,
+	"icmp-mode":                    true,
+	"tcp-moderate-receive-buffer":  true,
+	"tcp-send-buffer-size":         true,
+	"tcp-receive-buffer-size":      true,
+	"drain-timeout":                true,
+	"rate-limit-upload":            true,
+	"rate-limit-download":          true,
+	"rate-limit-source-upload":     true,
+	"rate-limit-source-download":   true,
+	"rate-limit-outbound-upload":   true,
+	"rate-limit-outbound-download": true,
+	"rate-limit-conn-upload":       true,
+	"rate-limit-conn-download":     true,
+	"quota-period":                 true,
+	"quota-source":                 true,
+	"quota-outbound":               true,
+	"quota-action":                 true,
+	"quota-fallback-proxy":         true,
+	"quota-throttle":               true,
+	"max-tcp-sessions":             true,
+	"max-udp-sessions":             true,
+	"max-tcp-sessions-per-source":  true,
+	"max-udp-sessions-per-source":  true,
+}
+
+// Reload reloads *Key to the default engine.
+func Reload(k *Key) ([]string, error) {
+	return _defaultEngine.Reload(k)
+}
+
+// SetLoader sets the function loading the *Key to reload the default
+// engine with, on the PUT /configs request of the REST API.
+func SetLoader(f func() (*Key, error)) {
+	_defaultEngine.mu.Lock()
+	_defaultEngine.loader = f
+	_defaultEngine.mu.Unlock()
+}
+
+// Reload applies the settings of k which can be changed live, i.e. the
+// proxy, the log level, the dialer options, the UDP and ICMP options, the
+// TCP buffer options, the drain timeout, the rate limits, the quotas and
+// the session limits, to the running Engine. Connections established keep
+// their settings, except the rate limits and the quotas. It returns the
+// names of the other settings changed, which require restart and are left
+// as they are. Nothing is applied if any of the settings is invalid. If
+// the Engine is not running, k is simply loaded for the next start.
+func (e *Engine) Reload(k *Key) (restart []string, err error) {
+	if k == nil {
+		return nil, errors.New("empty key")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.running {
+		e.key = k
+		return nil, nil
+	}
+
+	next := *e.key
+	var live []string
+	for _, f := range diffKey(e.key, k) {
+		if !e.isLive(f.name) {
+			restart = append(restart, f.name)
+			continue
+		}
+		live = append(live, f.name)
+		reflect.ValueOf(&next).Elem().Field(f.index).Set(reflect.ValueOf(k).Elem().Field(f.index))
+	}
+
+	if len(live) > 0 {
+		if err := e.applyLive(&next, e.key.Proxy != next.Proxy); err != nil {
+			return nil, err
+		}
+		e.key = &next
+		log.Infof("[ENGINE] reload %s", strings.Join(live, ", "))
+	}
+	if len(restart) > 0 {
+		log.Warnf("[ENGINE] restart to apply %s", strings.Join(restart, ", "))
+	}
+	return restart, nil
+}
+
+// isLive returns whether the setting of name can be changed live. The
+// fwmark can't with tun-auto-route, as the policy rules routing marked
+// traffic around the TUN were added with the mark on start.
+func (e *Engine) isLive(name string) bool {
+	if name == "fwmark" && e.key.TUNAutoRoute {
+		return false
+	}
+	return _liveSettings[name]
+}
+
+// applyLive applies the live settings of k, which are all validated
+// before any of them is applied.
+func (e *Engine) applyLive(k *Key, reloadProxy bool) error {
+	level, err := log.ParseLevel(k.LogLevel)
+	if err != nil {
+		return err
+	}
+	sockOpts, err := parseSockOpts(k)
+	if err != nil {
+		return err
+	}
+	if err := checkUDPTimeout(k.UDPTimeout); err != nil {
+		return err
+	}
+	natType, err := parseUDPNAT(k.UDPNAT)
+	if err != nil {
+		return err
+	}
+	forwarding, err := parseICMPMode(k.ICMPMode)
+	if err != nil {
+		return err
+	}
+	tcpOpts, err := parseTCPOptions(k)
+	if err != nil {
+		return err
+	}
+	limits, err := parseRateLimits(k)
+	if err != nil {
+		return err
+	}
+	quotaCfg, fallback, err := parseQuota(k)
+	if err != nil {
+		return err
+	}
+	sessionLimits, err := parseSessionLimits(k)
+	if err != nil {
+		return err
+	}
+	p := e.proxy
+	if reloadProxy {
+		if p, err = parseProxy(k.Proxy); err != nil {
+			return err
+		}
+	}
+
+	for _, opt := range tcpOpts {
+		_ = opt(e.stack) /* validated */
+	}
+	// The level may be changed at runtime, e.g. by the REST API, so
+	// it's left as it is unless the setting is changed.
+	if k.LogLevel != e.key.LogLevel {
+		log.SetLevel(level)
+	}
+	setSockOpts(sockOpts)
+	e.tunnel.SetUDPTimeout(k.UDPTimeout)
+	e.tunnel.SetUDPNATType(natType)
+	e.tunnel.SetICMPForwarding(forwarding)
+	e.tunnel.Shaper().SetLimits(limits)
+	_ = e.tunnel.Quota().SetConfig(quotaCfg) /* validated */
+	e.tunnel.SetFallbackProxy(fallback)
+	e.tunnel.SetSessionLimits(sessionLimits)
+	e.proxy = p
+	e.tunnel.SetProxy(p)
+	return nil
+}
+
+// keyField is a field of Key, named as in the configuration file.
+type keyField struct {
+	index int
+	name  string
+}
+
+// diffKey returns the fields of Key differing between a and b. Empty
+// and nil slices are the same.
+func diffKey(a, b *Key) []keyField {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+
+	var fields []keyField
+	for i := range va.NumField() {
+		fa, fb := va.Field(i), vb.Field(i)
+		if fa.Kind() == reflect.Slice && fa.Len() == 0 && fb.Len() == 0 {
+			continue
+		}
+		if reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+			continue
+		}
+		name, _, _ := strings.Cut(va.Type().Field(i).Tag.Get("yaml"), ",")
+		fields = append(fields, keyField{index: i, name: name})
+	}
+	return fields
+}
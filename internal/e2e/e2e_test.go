@@ -0,0 +1,603 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+
+	"github.com/xjasonlyu/tun2socks/v2/dialer"
+	"github.com/xjasonlyu/tun2socks/v2/proxy"
+	"github.com/xjasonlyu/tun2socks/v2/proxy/http"
+	"github.com/xjasonlyu/tun2socks/v2/proxy/reject"
+	"github.com/xjasonlyu/tun2socks/v2/proxy/shadowsocks"
+	"github.com/xjasonlyu/tun2socks/v2/proxy/socks5"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/quota"
+)
+
+const _timeout = 5 * time.Second
+
+var (
+	_dstIPv4 = netip.MustParseAddrPort("203.0.113.1:443")
+	_dstIPv6 = netip.MustParseAddrPort("[2001:db8::1]:443")
+)
+
+type standIn interface {
+	Addr() string
+	Targets() []Target
+}
+
+func newSOCKS5(t *testing.T) (proxy.Proxy, standIn) {
+	s := NewSOCKS5Server(t)
+	p, err := socks5.New(s.Addr(), "", "")
+	require.NoError(t, err)
+	return p, s
+}
+
+func newHTTP(t *testing.T) (proxy.Proxy, standIn) {
+	s := NewHTTPServer(t)
+	p, err := http.New(s.Addr(), "", "")
+	require.NoError(t, err)
+	return p, s
+}
+
+func newShadowsocks(t *testing.T) (proxy.Proxy, standIn) {
+	s := NewShadowsocksServer(t, "aes-128-gcm", "password")
+	p, err := shadowsocks.New(s.Addr(), "aes-128-gcm", "password", "", "")
+	require.NoError(t, err)
+	return p, s
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+	return b
+}
+
+func TestTCP(t *testing.T) {
+	for name, newProxy := range map[string]func(*testing.T) (proxy.Proxy, standIn){
+		"socks5":      newSOCKS5,
+		"http":        newHTTP,
+		"shadowsocks": newShadowsocks,
+	} {
+		t.Run(name, func(t *testing.T) {
+			p, s := newProxy(t)
+			h := New(t, p)
+
+			for _, dst := range []netip.AddrPort{_dstIPv4, _dstIPv6} {
+				ctx, cancel := context.WithTimeout(context.Background(), _timeout)
+				defer cancel()
+
+				c, err := h.DialTCP(ctx, dst)
+				require.NoError(t, err)
+				defer c.Close()
+				c.SetDeadline(time.Now().Add(_timeout))
+
+				// Larger than the windows, so that the payload is
+				// segmented and flow controlled.
+				payload := randomBytes(t, 1<<20)
+				go c.Write(payload)
+
+				got := make([]byte, len(payload))
+				_, err = io.ReadFull(c, got)
+				require.NoError(t, err)
+				assert.True(t, bytes.Equal(payload, got), "payload mismatch")
+			}
+
+			var dsts []string
+			for _, target := range s.Targets() {
+				assert.Equal(t, "tcp", target.Network)
+				dsts = append(dsts, target.Address)
+			}
+			assert.Equal(t, []string{_dstIPv4.String(), _dstIPv6.String()}, dsts)
+		})
+	}
+}
+
+func TestUDP(t *testing.T) {
+	for name, newProxy := range map[string]func(*testing.T) (proxy.Proxy, standIn){
+		"socks5":      newSOCKS5,
+		"shadowsocks": newShadowsocks,
+	} {
+		t.Run(name, func(t *testing.T) {
+			p, s := newProxy(t)
+			h := New(t, p)
+
+			for _, dst := range []netip.AddrPort{_dstIPv4, _dstIPv6} {
+				c, err := h.DialUDP(dst)
+				require.NoError(t, err)
+				defer c.Close()
+				c.SetDeadline(time.Now().Add(_timeout))
+
+				payload := randomBytes(t, 1024)
+				_, err = c.Write(payload)
+				require.NoError(t, err)
+
+				got := make([]byte, 2048)
+				n, err := c.Read(got)
+				require.NoError(t, err)
+				assert.Equal(t, payload, got[:n])
+			}
+
+			var dsts []string
+			for _, target := range s.Targets() {
+				assert.Equal(t, "udp", target.Network)
+				dsts = append(dsts, target.Address)
+			}
+			assert.Equal(t, []string{_dstIPv4.String(), _dstIPv6.String()}, dsts)
+		})
+	}
+}
+
+func TestUDPNATType(t *testing.T) {
+	dsts := []netip.AddrPort{
+		netip.MustParseAddrPort("203.0.113.1:53"),
+		netip.MustParseAddrPort("203.0.113.2:53"),
+	}
+
+	for _, tt := range []struct {
+		natType tunnel.NATType
+		// shared is whether datagrams from the same source share
+		// the upstream session, i.e. the source at the server.
+		shared bool
+	}{
+		{tunnel.SymmetricNAT, false},
+		{tunnel.PortRestrictedNAT, true},
+		{tunnel.FullConeNAT, true},
+	} {
+		t.Run(tt.natType.String(), func(t *testing.T) {
+			p, s := newSOCKS5(t)
+			h := New(t, p)
+			h.Tunnel.SetUDPNATType(tt.natType)
+
+			pc, err := h.ListenUDP(ClientIPv4.Addr())
+			require.NoError(t, err)
+			defer pc.Close()
+			pc.SetDeadline(time.Now().Add(_timeout))
+
+			for _, dst := range dsts {
+				payload := []byte(dst.String())
+				_, err = pc.WriteTo(payload, net.UDPAddrFromAddrPort(dst))
+				require.NoError(t, err)
+
+				got := make([]byte, 2048)
+				n, from, err := pc.ReadFrom(got)
+				require.NoError(t, err)
+				assert.Equal(t, payload, got[:n])
+				assert.Equal(t, dst.String(), from.String())
+			}
+
+			targets := s.Targets()
+			require.Len(t, targets, len(dsts))
+			assert.Equal(t, tt.shared, targets[0].Source == targets[1].Source)
+		})
+	}
+}
+
+func TestDevices(t *testing.T) {
+	p, s := newSOCKS5(t)
+	h := NewWithDevices(t, p, "dev0", "dev1")
+
+	// The clients share the same addresses, told apart by the devices.
+	for _, c := range h.Clients {
+		ctx, cancel := context.WithTimeout(context.Background(), _timeout)
+		defer cancel()
+
+		tc, err := c.DialTCP(ctx, _dstIPv4)
+		require.NoError(t, err)
+		defer tc.Close()
+		uc, err := c.DialUDP(_dstIPv4)
+		require.NoError(t, err)
+		defer uc.Close()
+
+		for _, conn := range []net.Conn{tc, uc} {
+			conn.SetDeadline(time.Now().Add(_timeout))
+			payload := []byte(c.Device.Name())
+			_, err = conn.Write(payload)
+			require.NoError(t, err)
+			got := make([]byte, len(payload))
+			_, err = io.ReadFull(conn, got)
+			require.NoError(t, err)
+			assert.Equal(t, payload, got)
+		}
+	}
+
+	devices := map[string][]string{}
+	for _, c := range h.Manager.Snapshot().Connections {
+		md := c.Metadata()
+		devices[md.Network.String()] = append(devices[md.Network.String()], md.Device)
+	}
+	for _, network := range []string{"tcp", "udp"} {
+		assert.ElementsMatch(t, []string{"dev0", "dev1"}, devices[network], network)
+	}
+	assert.Len(t, s.Targets(), 4)
+}
+
+func TestUDPNATFiltering(t *testing.T) {
+	dst := netip.MustParseAddrPort("203.0.113.1:53")
+	// The peer has the address of the destination, but another port,
+	// which tells the port-restricted cone NAT from the full cone one.
+	peer := netip.MustParseAddrPort("203.0.113.1:5353")
+
+	for _, tt := range []struct {
+		natType tunnel.NATType
+		// accepted is whether datagrams from peers never sent to
+		// are accepted.
+		accepted bool
+	}{
+		{tunnel.PortRestrictedNAT, false},
+		{tunnel.FullConeNAT, true},
+	} {
+		t.Run(tt.natType.String(), func(t *testing.T) {
+			s := NewSOCKS5Server(t)
+			p, err := socks5.New(s.Addr(), "", "")
+			require.NoError(t, err)
+			h := New(t, p)
+			h.Tunnel.SetUDPNATType(tt.natType)
+
+			pc, err := h.ListenUDP(ClientIPv4.Addr())
+			require.NoError(t, err)
+			defer pc.Close()
+			pc.SetDeadline(time.Now().Add(_timeout))
+
+			_, err = pc.WriteTo([]byte("hello"), net.UDPAddrFromAddrPort(dst))
+			require.NoError(t, err)
+			got := make([]byte, 2048)
+			_, _, err = pc.ReadFrom(got)
+			require.NoError(t, err)
+
+			targets := s.Targets()
+			require.Len(t, targets, 1)
+			require.NoError(t, s.SendFrom(targets[0].Source, peer, []byte("unsolicited")))
+
+			if !tt.accepted {
+				pc.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+				_, _, err = pc.ReadFrom(got)
+				var ne net.Error
+				require.ErrorAs(t, err, &ne)
+				assert.True(t, ne.Timeout())
+				return
+			}
+			// The datagram is spoofed to come from the peer.
+			n, from, err := pc.ReadFrom(got)
+			require.NoError(t, err)
+			assert.Equal(t, "unsolicited", string(got[:n]))
+			assert.Equal(t, peer.String(), from.String())
+		})
+	}
+}
+
+func TestUDPUnreachable(t *testing.T) {
+	rejecting, err := reject.New()
+	require.NoError(t, err)
+	// Nothing listens on the port, so that dials fail.
+	refused, err := socks5.New(fmt.Sprintf("127.0.0.1:%d", closedPort(t)), "", "")
+	require.NoError(t, err)
+	working, _ := newSOCKS5(t)
+
+	for _, tt := range []struct {
+		name     string
+		proxy    proxy.Proxy
+		draining bool
+		// Codes of ICMPv4 and ICMPv6 destination unreachable, and
+		// whether it's port unreachable, which fails the socket.
+		code4   header.ICMPv4Code
+		code6   header.ICMPv6Code
+		refused bool
+	}{
+		{"reject", rejecting, false, header.ICMPv4AdminProhibited, header.ICMPv6Prohibited, false},
+		{"dial error", refused, false, header.ICMPv4PortUnreachable, header.ICMPv6PortUnreachable, true},
+		{"drain", working, true, header.ICMPv4PortUnreachable, header.ICMPv6PortUnreachable, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(t, tt.proxy)
+			h.Tunnel.SetDraining(tt.draining)
+
+			for _, dst := range []netip.AddrPort{_dstIPv4, _dstIPv6} {
+				packets, stop := h.Capture()
+				defer stop()
+
+				c, err := h.DialUDP(dst)
+				require.NoError(t, err)
+				defer c.Close()
+				c.SetDeadline(time.Now().Add(_timeout))
+				_, err = c.Write(randomBytes(t, 64))
+				require.NoError(t, err)
+
+				code, quoted := readUnreachable(t, packets)
+				want := uint8(tt.code4)
+				if dst.Addr().Is6() {
+					want = uint8(tt.code6)
+				}
+				assert.Equal(t, want, code, dst)
+				// The error quotes the datagram sent.
+				assert.Equal(t, dst, quoted)
+
+				if tt.refused {
+					_, err = c.Read(make([]byte, 64))
+					assert.Error(t, err)
+				}
+			}
+		})
+	}
+}
+
+// readUnreachable returns the code of the first ICMP destination
+// unreachable error of packets, and the destination of the datagram
+// quoted by it.
+func readUnreachable(t *testing.T, packets <-chan []byte) (uint8, netip.AddrPort) {
+	t.Helper()
+
+	timeout := time.After(_timeout)
+	for {
+		var b []byte
+		select {
+		case b = <-packets:
+		case <-timeout:
+			t.Fatal("no icmp destination unreachable")
+		}
+
+		switch header.IPVersion(b) {
+		case header.IPv4Version:
+			ip := header.IPv4(b)
+			if ip.TransportProtocol() != header.ICMPv4ProtocolNumber {
+				continue
+			}
+			icmp := header.ICMPv4(ip.Payload())
+			if icmp.Type() != header.ICMPv4DstUnreachable {
+				continue
+			}
+			quoted := header.IPv4(icmp.Payload())
+			udp := header.UDP(quoted.Payload())
+			addr := netip.AddrFrom4(quoted.DestinationAddress().As4())
+			return uint8(icmp.Code()), netip.AddrPortFrom(addr, udp.DestinationPort())
+		case header.IPv6Version:
+			ip := header.IPv6(b)
+			if ip.TransportProtocol() != header.ICMPv6ProtocolNumber {
+				continue
+			}
+			icmp := header.ICMPv6(ip.Payload())
+			if icmp.Type() != header.ICMPv6DstUnreachable {
+				continue
+			}
+			quoted := header.IPv6(icmp.Payload())
+			udp := header.UDP(quoted.Payload())
+			addr := netip.AddrFrom16(quoted.DestinationAddress().As16())
+			return uint8(icmp.Code()), netip.AddrPortFrom(addr, udp.DestinationPort())
+		}
+	}
+}
+
+// closedPort returns a local TCP port which nothing listens on.
+func closedPort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestICMP(t *testing.T) {
+	// ICMP echo requests are replied by the stack itself, unless
+	// forwarding is enabled.
+	p, _ := newSOCKS5(t)
+	h := New(t, p)
+
+	for _, dst := range []netip.AddrPort{_dstIPv4, _dstIPv6} {
+		ctx, cancel := context.WithTimeout(context.Background(), _timeout)
+		defer cancel()
+
+		payload := randomBytes(t, 56)
+		got, err := h.Ping(ctx, dst.Addr(), payload)
+		require.NoError(t, err)
+		assert.Equal(t, payload, got)
+	}
+}
+
+func TestICMPForwarding(t *testing.T) {
+	// Echo requests are forwarded to the real host with ping sockets,
+	// which may not be permitted.
+	pc, err := dialer.ListenICMP("ip4")
+	if err != nil {
+		t.Skipf("ping sockets not permitted: %v", err)
+	}
+	pc.Close()
+
+	// The stack drops packets to loopback addresses, ping one of the
+	// local host instead.
+	dst := localIPv4(t)
+
+	p, _ := newSOCKS5(t)
+	h := New(t, p)
+	h.Tunnel.SetICMPForwarding(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), _timeout)
+	defer cancel()
+
+	payload := randomBytes(t, 56)
+	got, err := h.Ping(ctx, dst, payload)
+	require.NoError(t, err)
+	assert.Equal(t, payload, got)
+}
+
+// localIPv4 returns a non-loopback IPv4 address of the local host.
+func localIPv4(t *testing.T) netip.Addr {
+	addrs, err := net.InterfaceAddrs()
+	require.NoError(t, err)
+	for _, a := range addrs {
+		if prefix, err := netip.ParsePrefix(a.String()); err == nil {
+			if addr := prefix.Addr(); addr.Is4() && !addr.IsLoopback() {
+				return addr
+			}
+		}
+	}
+	t.Skip("no non-loopback IPv4 address")
+	return netip.Addr{}
+}
+
+func TestDrain(t *testing.T) {
+	p, _ := newSOCKS5(t)
+	h := New(t, p)
+
+	ctx, cancel := context.WithTimeout(context.Background(), _timeout)
+	defer cancel()
+
+	c, err := h.DialTCP(ctx, _dstIPv4)
+	require.NoError(t, err)
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(_timeout))
+
+	echo := func() {
+		payload := randomBytes(t, 1024)
+		_, err := c.Write(payload)
+		require.NoError(t, err)
+		got := make([]byte, len(payload))
+		_, err = io.ReadFull(c, got)
+		require.NoError(t, err)
+		assert.Equal(t, payload, got)
+	}
+	echo()
+
+	h.Tunnel.SetDraining(true)
+	assert.Equal(t, int64(1), h.Tunnel.Active())
+
+	// New connections are reset, while the existing one goes on.
+	_, err = h.DialTCP(ctx, _dstIPv6)
+	assert.Error(t, err)
+	echo()
+
+	c.Close()
+	assert.Eventually(t, func() bool {
+		return h.Tunnel.Active() == 0
+	}, _timeout, 10*time.Millisecond)
+}
+
+func TestQuota(t *testing.T) {
+	p, _ := newSOCKS5(t)
+	h := New(t, p)
+	require.NoError(t, h.Tunnel.Quota().SetConfig(quota.Config{Source: 2048}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), _timeout)
+	defer cancel()
+
+	echo := func(c net.Conn) error {
+		payload := randomBytes(t, 1024)
+		if _, err := c.Write(payload); err != nil {
+			return err
+		}
+		got := make([]byte, len(payload))
+		if _, err := io.ReadFull(c, got); err != nil {
+			return err
+		}
+		assert.Equal(t, payload, got)
+		return nil
+	}
+	dial := func() net.Conn {
+		c, err := h.DialTCP(ctx, _dstIPv4)
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		c.SetDeadline(time.Now().Add(_timeout))
+		return c
+	}
+
+	// Both upload and download count.
+	require.NoError(t, echo(dial()))
+
+	// New connections of the source over quota are rejected.
+	assert.Error(t, echo(dial()))
+
+	// Or go through the fallback proxy.
+	fallback, _ := newHTTP(t)
+	h.Tunnel.SetFallbackProxy(fallback)
+	require.NoError(t, h.Tunnel.Quota().SetConfig(quota.Config{Source: 2048, Action: quota.ActionFallback}))
+	require.NoError(t, echo(dial()))
+}
+
+func TestSessionLimits(t *testing.T) {
+	p, _ := newSOCKS5(t)
+	h := New(t, p)
+	h.Tunnel.SetSessionLimits(tunnel.SessionLimits{TCPPerSource: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), _timeout)
+	defer cancel()
+
+	c, err := h.DialTCP(ctx, _dstIPv4)
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, 1, h.Tunnel.SessionStats().TCP)
+
+	// SYNs over the limit are reset.
+	_, err = h.DialTCP(ctx, _dstIPv4)
+	assert.Error(t, err)
+	assert.Equal(t, uint64(1), h.Tunnel.SessionStats().RefusedTCP)
+
+	// The session is released once it's handled.
+	c.Close()
+	assert.Eventually(t, func() bool {
+		return h.Tunnel.SessionStats().TCP == 0
+	}, _timeout, 10*time.Millisecond)
+	c, err = h.DialTCP(ctx, _dstIPv4)
+	require.NoError(t, err)
+	c.Close()
+}
+
+func TestSessionLimitsDevices(t *testing.T) {
+	p, _ := newSOCKS5(t)
+	h := NewWithDevices(t, p, "dev0", "dev1")
+	h.Tunnel.SetSessionLimits(tunnel.SessionLimits{UDPPerSource: 2})
+	h.Tunnel.SetUDPTimeout(200 * time.Millisecond)
+
+	// The clients share the same addresses, so the handlers of both
+	// devices may admit sessions of the same tuple at once, of which
+	// one fails to be set up and is released, as the stack takes only
+	// one endpoint of a tuple.
+	id := stack.TransportEndpointID{
+		LocalPort:     _dstIPv4.Port(),
+		LocalAddress:  tcpip.AddrFrom4(_dstIPv4.Addr().As4()),
+		RemotePort:    40000,
+		RemoteAddress: tcpip.AddrFrom4(ClientIPv4.Addr().As4()),
+	}
+	var nics []tcpip.NICID
+	for nic := range h.Stack.NICInfo() {
+		nics = append(nics, nic)
+	}
+	require.Len(t, nics, 2)
+	for _, nic := range nics {
+		require.True(t, h.Tunnel.Admit(nic, header.UDPProtocolNumber, id))
+	}
+	assert.False(t, h.Tunnel.Admit(nics[0], header.UDPProtocolNumber, id))
+
+	h.Tunnel.Release(nics[1], header.UDPProtocolNumber, id)
+	assert.Equal(t, 1, h.Tunnel.SessionStats().UDP)
+	h.Tunnel.Release(nics[1], header.UDPProtocolNumber, id)
+	assert.Equal(t, 1, h.Tunnel.SessionStats().UDP)
+	h.Tunnel.Release(nics[0], header.UDPProtocolNumber, id)
+	assert.Zero(t, h.Tunnel.SessionStats().UDP)
+
+	// The sessions of both devices are released once they're handled.
+	for _, c := range h.Clients {
+		uc, err := c.DialUDP(_dstIPv4)
+		require.NoError(t, err)
+		uc.SetDeadline(time.Now().Add(_timeout))
+		_, err = uc.Write([]byte(c.Device.Name()))
+		require.NoError(t, err)
+		_, err = uc.Read(make([]byte, 16))
+		require.NoError(t, err)
+		uc.Close()
+	}
+	assert.Eventually(t, func() bool {
+		return h.Tunnel.SessionStats().UDP == 0
+	}, _timeout, 10*time.Millisecond)
+}
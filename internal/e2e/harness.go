@@ -0,0 +1,329 @@
+// Package e2e provides an end-to-end test harness of tun2socks, which
+// runs the stack and the Tunnel on an in-memory device, and stand-in
+// proxy servers on the loopback, without root.
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+
+	"github.com/xjasonlyu/tun2socks/v2/core"
+	"github.com/xjasonlyu/tun2socks/v2/core/device/channel"
+	"github.com/xjasonlyu/tun2socks/v2/proxy"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/statistic"
+)
+
+const _clientNICID tcpip.NICID = 1
+
+// Addresses of the client, which sends packets into the device.
+var (
+	ClientIPv4 = netip.MustParsePrefix("10.0.0.2/24")
+	ClientIPv6 = netip.MustParsePrefix("fd00::2/64")
+)
+
+// Harness is a stack under test, whose devices are each wired to a
+// client stack. The clients generate real TCP, UDP and ICMP packets,
+// which are injected into the devices, and the packets written to the
+// devices are delivered back to the clients.
+type Harness struct {
+	// Client is the client of the first device, Device.
+	*Client
+
+	// Device is the first device of the stack under test.
+	Device *channel.Endpoint
+
+	// Clients are the clients of all the devices, in order.
+	Clients []*Client
+
+	// Stack is the stack under test.
+	Stack *stack.Stack
+
+	// Tunnel handles the connections of Stack.
+	Tunnel *tunnel.Tunnel
+
+	// Manager tracks the connections of Tunnel.
+	Manager *statistic.Manager
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Client is a client stack wired to a device of the stack under test.
+type Client struct {
+	// Device is the device of the stack under test the client sends
+	// packets into.
+	Device *channel.Endpoint
+
+	stack    *stack.Stack
+	endpoint *channel.Endpoint
+
+	captureMu sync.Mutex
+	captures  map[chan []byte]struct{}
+}
+
+// New returns a Harness of which the Tunnel dials via p, with a single
+// device. It's closed when the test finishes.
+func New(t testing.TB, p proxy.Proxy) *Harness {
+	t.Helper()
+	return NewWithDevices(t, p, "e2e")
+}
+
+// NewWithDevices returns a Harness of which the Tunnel dials via p, with
+// a device of each of names, which are attached to the stack in order.
+// It's closed when the test finishes.
+func NewWithDevices(t testing.TB, p proxy.Proxy, names ...string) *Harness {
+	t.Helper()
+
+	h := &Harness{Manager: statistic.NewManager()}
+	h.Tunnel = tunnel.New(p, h.Manager)
+	h.Tunnel.ProcessAsync()
+
+	var endpoints []stack.LinkEndpoint
+	for _, name := range names {
+		c := &Client{
+			Device:   channel.New(name, 0),
+			endpoint: channel.New("client", 0),
+			captures: make(map[chan []byte]struct{}),
+		}
+		h.Clients = append(h.Clients, c)
+		endpoints = append(endpoints, c.Device)
+	}
+	h.Client, h.Device = h.Clients[0], h.Clients[0].Device
+
+	var err error
+	if h.Stack, err = core.CreateStack(&core.Config{
+		LinkEndpoints:    endpoints,
+		TransportHandler: h.Tunnel,
+		ICMPHandler:      h.Tunnel,
+	}); err != nil {
+		h.Tunnel.Close()
+		h.Manager.Close()
+		t.Fatalf("create stack: %v", err)
+	}
+
+	for i, c := range h.Clients {
+		if c.stack, err = newClientStack(c.endpoint); err != nil {
+			for _, c := range h.Clients[:i] {
+				c.stack.Close()
+			}
+			h.Stack.Close()
+			h.Tunnel.Close()
+			h.Manager.Close()
+			t.Fatalf("create client stack: %v", err)
+		}
+	}
+
+	var ctx context.Context
+	ctx, h.cancel = context.WithCancel(context.Background())
+	for _, c := range h.Clients {
+		h.wg.Add(2)
+		go func() {
+			forward(ctx, c.Device, c.endpoint, c.capture)
+			h.wg.Done()
+		}()
+		go func() {
+			forward(ctx, c.endpoint, c.Device, nil)
+			h.wg.Done()
+		}()
+	}
+
+	t.Cleanup(h.Close)
+	return h
+}
+
+func newClientStack(ep stack.LinkEndpoint) (*stack.Stack, error) {
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{
+			ipv4.NewProtocol,
+			ipv6.NewProtocol,
+		},
+		TransportProtocols: []stack.TransportProtocolFactory{
+			tcp.NewProtocol,
+			udp.NewProtocol,
+			icmp.NewProtocol4,
+			icmp.NewProtocol6,
+		},
+	})
+
+	if err := s.CreateNIC(_clientNICID, ep); err != nil {
+		return nil, errors.New(err.String())
+	}
+	for _, p := range []netip.Prefix{ClientIPv4, ClientIPv6} {
+		protocol := ipv4.ProtocolNumber
+		if p.Addr().Is6() {
+			protocol = ipv6.ProtocolNumber
+		}
+		if err := s.AddProtocolAddress(_clientNICID, tcpip.ProtocolAddress{
+			Protocol: protocol,
+			AddressWithPrefix: tcpip.AddressWithPrefix{
+				Address:   tcpip.AddrFromSlice(p.Addr().AsSlice()),
+				PrefixLen: p.Bits(),
+			},
+		}, stack.AddressProperties{}); err != nil {
+			return nil, errors.New(err.String())
+		}
+	}
+	s.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: _clientNICID},
+		{Destination: header.IPv6EmptySubnet, NIC: _clientNICID},
+	})
+	return s, nil
+}
+
+// forward forwards packets written to src into dst, until ctx is done.
+// Each packet is passed to tap as well, if it's not nil.
+func forward(ctx context.Context, src, dst *channel.Endpoint, tap func([]byte)) {
+	for {
+		b, err := src.ReadPacket(ctx)
+		if err != nil {
+			return
+		}
+		if tap != nil {
+			tap(b)
+		}
+		_ = dst.Inject(b)
+	}
+}
+
+// Close closes the stacks, the Tunnel and the Manager.
+func (h *Harness) Close() {
+	h.cancel()
+	h.wg.Wait()
+
+	for _, c := range h.Clients {
+		c.stack.Close()
+		c.stack.Wait()
+		c.Device.Close()
+	}
+	h.Stack.Close()
+	h.Stack.Wait()
+	h.Tunnel.Close()
+	h.Manager.Close()
+}
+
+// Capture returns the packets written to the device of the client from
+// now on, until stop is called. Packets are dropped if they are not
+// received in time.
+func (c *Client) Capture() (packets <-chan []byte, stop func()) {
+	ch := make(chan []byte, 64)
+	c.captureMu.Lock()
+	c.captures[ch] = struct{}{}
+	c.captureMu.Unlock()
+
+	return ch, func() {
+		c.captureMu.Lock()
+		delete(c.captures, ch)
+		c.captureMu.Unlock()
+	}
+}
+
+func (c *Client) capture(b []byte) {
+	c.captureMu.Lock()
+	defer c.captureMu.Unlock()
+
+	for ch := range c.captures {
+		select {
+		case ch <- append([]byte(nil), b...):
+		default:
+		}
+	}
+}
+
+// DialTCP connects to addr from the client.
+func (c *Client) DialTCP(ctx context.Context, addr netip.AddrPort) (net.Conn, error) {
+	return gonet.DialContextTCP(ctx, c.stack, fullAddress(addr), networkProtocol(addr.Addr()))
+}
+
+// DialUDP returns a UDP socket of the client connected to addr.
+func (c *Client) DialUDP(addr netip.AddrPort) (net.Conn, error) {
+	raddr := fullAddress(addr)
+	return gonet.DialUDP(c.stack, nil, &raddr, networkProtocol(addr.Addr()))
+}
+
+// ListenUDP returns an unconnected UDP socket of the client bound to
+// addr, e.g. ClientIPv4, which sends to several destinations from the
+// same source address.
+func (c *Client) ListenUDP(addr netip.Addr) (net.PacketConn, error) {
+	laddr := fullAddress(netip.AddrPortFrom(addr, 0))
+	return gonet.DialUDP(c.stack, &laddr, nil, networkProtocol(addr))
+}
+
+// Ping sends an ICMP echo request with payload to dst from the client,
+// and returns the payload of the echo reply.
+func (c *Client) Ping(ctx context.Context, dst netip.Addr, payload []byte) ([]byte, error) {
+	transport, protocol := icmp.ProtocolNumber4, ipv4.ProtocolNumber
+	msg := make([]byte, header.ICMPv4MinimumSize+len(payload))
+	header.ICMPv4(msg).SetType(header.ICMPv4Echo)
+	if dst.Is6() {
+		transport, protocol = icmp.ProtocolNumber6, ipv6.ProtocolNumber
+		msg = make([]byte, header.ICMPv6EchoMinimumSize+len(payload))
+		header.ICMPv6(msg).SetType(header.ICMPv6EchoRequest)
+	}
+	// The echo headers of ICMPv4 and ICMPv6 are of the same size.
+	copy(msg[header.ICMPv4MinimumSize:], payload)
+
+	var wq waiter.Queue
+	ep, tcpipErr := c.stack.NewEndpoint(transport, protocol, &wq)
+	if tcpipErr != nil {
+		return nil, errors.New(tcpipErr.String())
+	}
+	defer ep.Close()
+
+	we, ch := waiter.NewChannelEntry(waiter.ReadableEvents)
+	wq.EventRegister(&we)
+	defer wq.EventUnregister(&we)
+
+	to := fullAddress(netip.AddrPortFrom(dst, 0))
+	if _, tcpipErr = ep.Write(bytes.NewReader(msg), tcpip.WriteOptions{To: &to}); tcpipErr != nil {
+		return nil, errors.New(tcpipErr.String())
+	}
+
+	for {
+		var buf bytes.Buffer
+		_, tcpipErr = ep.Read(&buf, tcpip.ReadOptions{})
+		switch tcpipErr.(type) {
+		case nil:
+			return buf.Bytes()[header.ICMPv4MinimumSize:], nil
+		case *tcpip.ErrWouldBlock:
+			select {
+			case <-ch:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		default:
+			return nil, errors.New(tcpipErr.String())
+		}
+	}
+}
+
+func fullAddress(addr netip.AddrPort) tcpip.FullAddress {
+	return tcpip.FullAddress{
+		NIC:  _clientNICID,
+		Addr: tcpip.AddrFromSlice(addr.Addr().AsSlice()),
+		Port: addr.Port(),
+	}
+}
+
+func networkProtocol(addr netip.Addr) tcpip.NetworkProtocolNumber {
+	if addr.Is4() {
+		return ipv4.ProtocolNumber
+	}
+	return ipv6.ProtocolNumber
+}
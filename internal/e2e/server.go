@@ -0,0 +1,317 @@
+package e2e
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/xjasonlyu/tun2socks/v2/transport/shadowsocks/core"
+	"github.com/xjasonlyu/tun2socks/v2/transport/socks5"
+)
+
+// Target is a destination requested to a stand-in server.
+type Target struct {
+	// Network is either tcp or udp.
+	Network string
+
+	// Address is the destination address.
+	Address string
+
+	// Source is the address the request came from, which tells the
+	// upstream UDP sessions apart.
+	Source string
+}
+
+// server is the common part of stand-in servers, which listen on the
+// loopback, and echo everything back instead of connecting to the
+// destinations requested, recording them for assertions.
+type server struct {
+	ln net.Listener
+	pc net.PacketConn
+
+	mu      sync.Mutex
+	targets []Target
+	conns   map[net.Conn]struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func newServer(t testing.TB, udp bool) *server {
+	t.Helper()
+
+	s := &server{conns: make(map[net.Conn]struct{})}
+	var err error
+	if s.ln, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatalf("listen tcp: %v", err)
+	}
+	if udp {
+		// The UDP relay shares the port of the TCP listener.
+		if s.pc, err = net.ListenPacket("udp", s.ln.Addr().String()); err != nil {
+			s.ln.Close()
+			t.Fatalf("listen udp: %v", err)
+		}
+	}
+	t.Cleanup(s.close)
+	return s
+}
+
+// Addr returns the address the server listens on.
+func (s *server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Targets returns the destinations requested so far.
+func (s *server) Targets() []Target {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.targets)
+}
+
+func (s *server) record(network, address string, source net.Addr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targets = append(s.targets, Target{Network: network, Address: address, Source: source.String()})
+}
+
+func (s *server) serve(handle func(net.Conn) error) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			c, err := s.ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			if s.closed {
+				s.mu.Unlock()
+				c.Close()
+				return
+			}
+			s.conns[c] = struct{}{}
+			s.mu.Unlock()
+
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				_ = handle(c)
+				c.Close()
+
+				s.mu.Lock()
+				delete(s.conns, c)
+				s.mu.Unlock()
+			}()
+		}
+	}()
+}
+
+func (s *server) servePacket(pc net.PacketConn, handle func(b []byte, from net.Addr) ([]byte, error)) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		buf := make([]byte, 64<<10)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			reply, err := handle(buf[:n], from)
+			if err != nil {
+				continue
+			}
+			_, _ = pc.WriteTo(reply, from)
+		}
+	}()
+}
+
+func (s *server) close() {
+	s.ln.Close()
+	if s.pc != nil {
+		s.pc.Close()
+	}
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// echo copies everything read from c back to it, until EOF.
+func echo(c io.ReadWriter) error {
+	_, err := io.Copy(c, c)
+	return err
+}
+
+// SOCKS5Server is a stand-in SOCKS5 server without authentication,
+// supporting both CONNECT and UDP ASSOCIATE.
+type SOCKS5Server struct {
+	*server
+}
+
+// NewSOCKS5Server starts a SOCKS5Server, which is closed when the
+// test finishes.
+func NewSOCKS5Server(t testing.TB) *SOCKS5Server {
+	s := &SOCKS5Server{newServer(t, true)}
+	s.serve(s.handle)
+	s.servePacket(s.pc, s.handlePacket)
+	return s
+}
+
+func (s *SOCKS5Server) handle(c net.Conn) error {
+	buf := make([]byte, socks5.MaxAddrLen)
+
+	// VER, NMETHODS, METHODS
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(c, buf[:buf[1]]); err != nil {
+		return err
+	}
+	if _, err := c.Write([]byte{socks5.Version, socks5.MethodNoAuth}); err != nil {
+		return err
+	}
+
+	// VER, CMD, RSV, DST.ADDR, DST.PORT
+	if _, err := io.ReadFull(c, buf[:3]); err != nil {
+		return err
+	}
+	cmd := socks5.Command(buf[1])
+	addr, err := socks5.ReadAddr(c, buf)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case socks5.CmdConnect:
+		s.record("tcp", addr.String(), c.RemoteAddr())
+		if err := writeSocksReply(c, s.ln.Addr()); err != nil {
+			return err
+		}
+		return echo(c)
+	case socks5.CmdUDPAssociate:
+		if err := writeSocksReply(c, s.pc.LocalAddr()); err != nil {
+			return err
+		}
+		// The association lasts as long as the connection.
+		_, err = io.Copy(io.Discard, c)
+		return err
+	default:
+		_, _ = c.Write([]byte{socks5.Version, 0x07 /* command not supported */, 0x00, socks5.AtypIPv4, 0, 0, 0, 0, 0, 0})
+		return errors.New("unsupported command")
+	}
+}
+
+func writeSocksReply(w io.Writer, bind net.Addr) error {
+	_, err := w.Write(append([]byte{socks5.Version, 0x00 /* succeeded */, 0x00}, socks5.ParseAddr(bind)...))
+	return err
+}
+
+func (s *SOCKS5Server) handlePacket(b []byte, from net.Addr) ([]byte, error) {
+	addr, _, err := socks5.DecodeUDPPacket(b)
+	if err != nil {
+		return nil, err
+	}
+	s.record("udp", addr.String(), from)
+	// The reply comes from the destination, i.e. the same header.
+	return b, nil
+}
+
+// SendFrom sends payload to the upstream UDP session of source, i.e. the
+// Source of its Target, as if it came from the peer, which the session
+// may have never sent to.
+func (s *SOCKS5Server) SendFrom(source string, peer netip.AddrPort, payload []byte) error {
+	to, err := net.ResolveUDPAddr("udp", source)
+	if err != nil {
+		return err
+	}
+	b, err := socks5.EncodeUDPPacket(socks5.ParseAddr(net.UDPAddrFromAddrPort(peer)), payload)
+	if err != nil {
+		return err
+	}
+	_, err = s.pc.WriteTo(b, to)
+	return err
+}
+
+// HTTPServer is a stand-in HTTP proxy server supporting CONNECT.
+type HTTPServer struct {
+	*server
+}
+
+// NewHTTPServer starts an HTTPServer, which is closed when the test
+// finishes.
+func NewHTTPServer(t testing.TB) *HTTPServer {
+	s := &HTTPServer{newServer(t, false)}
+	s.serve(s.handle)
+	return s
+}
+
+func (s *HTTPServer) handle(c net.Conn) error {
+	br := bufio.NewReader(c)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return err
+	}
+	if req.Method != http.MethodConnect {
+		_, _ = io.WriteString(c, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+		return errors.New("unsupported method")
+	}
+
+	s.record("tcp", req.Host, c.RemoteAddr())
+	if _, err := io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return err
+	}
+	return echo(struct {
+		io.Reader
+		io.Writer
+	}{br, c})
+}
+
+// ShadowsocksServer is a stand-in Shadowsocks server for both TCP
+// and UDP.
+type ShadowsocksServer struct {
+	*server
+
+	cipher core.Cipher
+}
+
+// NewShadowsocksServer starts a ShadowsocksServer with the cipher
+// method and password, which is closed when the test finishes.
+func NewShadowsocksServer(t testing.TB, method, password string) *ShadowsocksServer {
+	t.Helper()
+
+	cipher, err := core.PickCipher(method, nil, password)
+	if err != nil {
+		t.Fatalf("pick cipher: %v", err)
+	}
+	s := &ShadowsocksServer{server: newServer(t, true), cipher: cipher}
+	s.serve(s.handle)
+	s.servePacket(cipher.PacketConn(s.pc), s.handlePacket)
+	return s
+}
+
+func (s *ShadowsocksServer) handle(c net.Conn) error {
+	sc := s.cipher.StreamConn(c)
+	addr, err := socks5.ReadAddr(sc, make([]byte, socks5.MaxAddrLen))
+	if err != nil {
+		return err
+	}
+	s.record("tcp", addr.String(), c.RemoteAddr())
+	return echo(sc)
+}
+
+func (s *ShadowsocksServer) handlePacket(b []byte, from net.Addr) ([]byte, error) {
+	addr := socks5.SplitAddr(b)
+	if addr == nil {
+		return nil, errors.New("invalid address")
+	}
+	s.record("udp", addr.String(), from)
+	return b, nil
+}
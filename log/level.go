@@ -29,3 +29,11 @@ func ParseLevel(text string) (Level, error) {
 		return zapcore.ParseLevel(text)
 	}
 }
+
+// FormatLevel returns the text of l, which ParseLevel parses back.
+func FormatLevel(l Level) string {
+	if l == SilentLevel {
+		return "silent"
+	}
+	return l.String()
+}
@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 // global Logger and SugaredLogger.
@@ -14,32 +15,58 @@ var (
 	_globalS  *SugaredLogger
 )
 
+// _globalLevel is the level shared by the loggers of NewLeveled,
+// which can be changed at runtime.
+var _globalLevel = zap.NewAtomicLevel()
+
 func init() {
 	SetLogger(zap.Must(zap.NewProduction()))
 }
 
+// NewLeveled returns a Logger of level l, which sets the level shared
+// by all the loggers it returns, so that SetLevel changes it later.
 func NewLeveled(l Level, options ...Option) (*Logger, error) {
+	var cfg zap.Config
 	switch l {
-	case SilentLevel:
-		return zap.NewNop(), nil
 	case DebugLevel:
-		return zap.NewDevelopment(options...)
-	case InfoLevel, WarnLevel, ErrorLevel, DPanicLevel, PanicLevel, FatalLevel:
-		cfg := zap.NewProductionConfig()
-		cfg.Level.SetLevel(l)
-		return cfg.Build(options...)
+		cfg = zap.NewDevelopmentConfig()
+	case InfoLevel, WarnLevel, ErrorLevel, DPanicLevel, PanicLevel, FatalLevel, SilentLevel:
+		cfg = zap.NewProductionConfig()
 	default:
 		return nil, fmt.Errorf("invalid level: %s", l)
 	}
+	_globalLevel.SetLevel(l)
+	cfg.Level = _globalLevel
+	return cfg.Build(options...)
+}
+
+// SetLevel changes the level of the loggers of NewLeveled at runtime,
+// including the global one if it's of NewLeveled.
+func SetLevel(l Level) {
+	_globalLevel.SetLevel(l)
+
+	_globalMu.RLock()
+	defer _globalMu.RUnlock()
+	_globalE.setLogger(_globalS)
+}
+
+// GetLevel returns the level of the global logger.
+func GetLevel() Level {
+	_globalMu.RLock()
+	defer _globalMu.RUnlock()
+	return _globalL.Level()
 }
 
-// SetLogger sets the global Logger and SugaredLogger.
+// SetLogger sets the global Logger and SugaredLogger, whose entries
+// are streamed to subscribers as well.
 func SetLogger(logger *Logger) {
 	_globalMu.Lock()
 	defer _globalMu.Unlock()
 	// apply pkgCallerSkip to global loggers.
 	_globalL = logger.WithOptions(pkgCallerSkip)
-	_globalS = _globalL.Sugar()
+	_globalS = _globalL.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, _globalStream)
+	})).Sugar()
 	_globalE.setLogger(_globalS)
 }
 
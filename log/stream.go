@@ -0,0 +1,129 @@
+package log
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+	"go.uber.org/zap/zapcore"
+)
+
+// streamBufferSize is the number of entries buffered for each
+// subscriber, over which entries are dropped.
+const streamBufferSize = 256
+
+var _globalStream = newStreamCore()
+
+// Entry is a log entry streamed to subscribers.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+	Caller  string    `json:"caller,omitempty"`
+}
+
+// Subscribe returns a channel of the entries of the global logger at
+// level or above, regardless of the level of the logger, and a function
+// to unsubscribe, which closes the channel. Entries are dropped if the
+// channel is full.
+func Subscribe(level Level) (<-chan Entry, func()) {
+	return _globalStream.subscribe(level)
+}
+
+type subscriber struct {
+	level Level
+	ch    chan Entry
+}
+
+// streamCore is a zapcore.Core, which is teed with the core of the global
+// logger, to stream entries to subscribers.
+type streamCore struct {
+	mu   sync.RWMutex
+	subs map[*subscriber]struct{}
+
+	// level is the lowest level of subscribers.
+	level *atomic.Int32
+}
+
+func newStreamCore() *streamCore {
+	return &streamCore{
+		subs:  make(map[*subscriber]struct{}),
+		level: atomic.NewInt32(int32(SilentLevel)),
+	}
+}
+
+func (c *streamCore) subscribe(level Level) (<-chan Entry, func()) {
+	s := &subscriber{level: level, ch: make(chan Entry, streamBufferSize)}
+
+	c.mu.Lock()
+	c.subs[s] = struct{}{}
+	c.updateLevel()
+	c.mu.Unlock()
+
+	var once sync.Once
+	return s.ch, func() {
+		once.Do(func() {
+			c.mu.Lock()
+			delete(c.subs, s)
+			c.updateLevel()
+			c.mu.Unlock()
+			close(s.ch)
+		})
+	}
+}
+
+func (c *streamCore) updateLevel() {
+	level := SilentLevel
+	for s := range c.subs {
+		level = min(level, s.level)
+	}
+	c.level.Store(int32(level))
+}
+
+func (c *streamCore) Level() Level {
+	return Level(c.level.Load())
+}
+
+func (c *streamCore) Enabled(level Level) bool {
+	return level >= c.Level()
+}
+
+// With returns c itself, as fields are not streamed.
+func (c *streamCore) With([]zapcore.Field) zapcore.Core {
+	return c
+}
+
+func (c *streamCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *streamCore) Write(ent zapcore.Entry, _ []zapcore.Field) error {
+	e := Entry{
+		Time:    ent.Time,
+		Level:   ent.Level.String(),
+		Message: ent.Message,
+	}
+	if ent.Caller.Defined {
+		e.Caller = ent.Caller.TrimmedPath()
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for s := range c.subs {
+		if ent.Level < s.level {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default: /* drop */
+		}
+	}
+	return nil
+}
+
+func (c *streamCore) Sync() error {
+	return nil
+}
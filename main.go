@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
@@ -26,8 +27,11 @@ func init() {
 	flag.IntVar(&key.Mark, "fwmark", 0, "Set firewall MARK (Linux/BSD)")
 	flag.IntVar(&key.MTU, "mtu", 0, "Set device maximum transmission unit (MTU)")
 	flag.DurationVar(&key.UDPTimeout, "udp-timeout", 0, "Set timeout for each UDP session")
+	flag.StringVar(&key.ICMPMode, "icmp-mode", "", "Set ICMP echo mode [local|forward]")
+	flag.StringVar(&key.UDPNAT, "udp-nat", "", "Set UDP NAT type [symmetric|port-restricted|full-cone]")
 	flag.StringVarP(&configFile, "config", "c", "", "YAML format configuration file")
 	flag.StringVarP(&key.Device, "device", "d", "", "Use this device [driver://]name")
+	flag.StringArrayVar(&key.Devices, "devices", nil, "Attach more devices [driver://]name, can be repeated")
 	flag.StringVarP(&key.Proxy, "proxy", "p", "", "Use this proxy [protocol://]host[:port]")
 	flag.StringVar(&key.Interface, "interface", "", "Use network INTERFACE (Linux/MacOS/Windows)")
 	flag.StringVar(&key.LogLevel, "loglevel", "info", "Log level [debug|info|warn|error|silent]")
@@ -38,6 +42,47 @@ func init() {
 	flag.StringSliceVar(&key.MulticastGroups, "multicast-groups", nil, "Set multicast groups, separated by commas")
 	flag.StringVar(&key.TUNPreUp, "tun-pre-up", "", "Execute a command before TUN device setup")
 	flag.StringVar(&key.TUNPostUp, "tun-post-up", "", "Execute a command after TUN device setup")
+	flag.StringVar(&key.TUNPreDown, "tun-pre-down", "", "Execute a command before TUN device teardown")
+	flag.StringVar(&key.TUNPostDown, "tun-post-down", "", "Execute a command after TUN device teardown")
+	flag.DurationVar(&key.TUNHookTimeout, "tun-hook-timeout", 0, "Set timeout for each TUN hook command")
+	flag.StringSliceVar(&key.TUNAddress, "tun-address", nil, "Assign addresses in CIDR to TUN device, separated by commas (Linux)")
+	flag.BoolVar(&key.TUNAutoRoute, "tun-auto-route", false, "Route traffic into TUN device by policy rules (Linux)")
+	flag.IntVar(&key.TUNRouteTable, "tun-route-table", 0, "Set routing table for TUN device (Linux)")
+	flag.StringSliceVar(&key.TUNIncludedRoutes, "tun-included-routes", nil, "Route only these CIDRs into TUN device, separated by commas (Linux)")
+	flag.StringSliceVar(&key.TUNExcludedRoutes, "tun-excluded-routes", nil, "Bypass TUN device for these CIDRs, separated by commas (Linux)")
+	flag.StringVar(&key.Capture, "capture", "", "Capture packets to a pcap or pcapng FILE")
+	flag.StringVar(&key.CaptureFilter, "capture-filter", "", "Set filter of packet capture, e.g. \"tcp and port 443\"")
+	flag.StringVar(&key.CaptureMaxSize, "capture-max-size", "", "Stop packet capture at this file size")
+	flag.DurationVar(&key.CaptureDuration, "capture-duration", 0, "Stop packet capture after this duration")
+	flag.DurationVar(&key.DrainTimeout, "drain-timeout", 0, "Wait for active connections to finish on shutdown")
+	flag.StringVar(&key.AccessLog, "access-log", "", "Write access log of connections to FILE")
+	flag.StringVar(&key.AccessLogFormat, "access-log-format", "", "Set access log format [json] or a Go template, e.g. \"{{.Source}} -> {{.Destination}}\"")
+	flag.StringVar(&key.AccessLogMaxSize, "access-log-max-size", "", "Rotate access log at this file size")
+	flag.DurationVar(&key.AccessLogRotateInterval, "access-log-rotate-interval", 0, "Rotate access log at this interval")
+	flag.IntVar(&key.AccessLogMaxBackups, "access-log-max-backups", 0, "Keep at most this number of rotated access logs")
+	flag.DurationVar(&key.AccessLogMaxAge, "access-log-max-age", 0, "Remove rotated access logs older than this duration")
+	flag.StringVar(&key.AccountingFile, "accounting-file", "", "Persist traffic accounting by source and destination to FILE")
+	flag.DurationVar(&key.AccountingInterval, "accounting-interval", 0, "Set interval of saving traffic accounting (default 1m)")
+	flag.StringVar(&key.RateLimitUpload, "rate-limit-upload", "", "Limit upload rate of all connections in bytes per second")
+	flag.StringVar(&key.RateLimitDownload, "rate-limit-download", "", "Limit download rate of all connections in bytes per second")
+	flag.StringVar(&key.RateLimitSourceUpload, "rate-limit-source-upload", "", "Limit upload rate of each source IP in bytes per second")
+	flag.StringVar(&key.RateLimitSourceDownload, "rate-limit-source-download", "", "Limit download rate of each source IP in bytes per second")
+	flag.StringVar(&key.RateLimitOutboundUpload, "rate-limit-outbound-upload", "", "Limit upload rate of each outbound proxy in bytes per second")
+	flag.StringVar(&key.RateLimitOutboundDownload, "rate-limit-outbound-download", "", "Limit download rate of each outbound proxy in bytes per second")
+	flag.StringVar(&key.RateLimitConnUpload, "rate-limit-conn-upload", "", "Limit upload rate of each connection in bytes per second")
+	flag.StringVar(&key.RateLimitConnDownload, "rate-limit-conn-download", "", "Limit download rate of each connection in bytes per second")
+	flag.StringVar(&key.QuotaFile, "quota-file", "", "Persist traffic counted against quotas to FILE")
+	flag.StringVar(&key.QuotaPeriod, "quota-period", "", "Set period of traffic quotas [daily|monthly]")
+	flag.StringVar(&key.QuotaSource, "quota-source", "", "Set traffic quota of each source IP in a period")
+	flag.StringVar(&key.QuotaOutbound, "quota-outbound", "", "Set traffic quota of each proxy in a period")
+	flag.StringVar(&key.QuotaAction, "quota-action", "", "Set action over traffic quota [reject|fallback|throttle]")
+	flag.StringVar(&key.QuotaFallbackProxy, "quota-fallback-proxy", "", "Use this proxy over traffic quota with fallback action")
+	flag.StringVar(&key.QuotaThrottle, "quota-throttle", "", "Limit rate over traffic quota with throttle action in bytes per second")
+	flag.IntVar(&key.MaxTCPSessions, "max-tcp-sessions", 0, "Limit concurrent TCP connections, beyond which SYNs are reset")
+	flag.IntVar(&key.MaxUDPSessions, "max-udp-sessions", 0, "Limit concurrent UDP sessions, beyond which datagrams are dropped")
+	flag.IntVar(&key.MaxTCPSessionsPerSource, "max-tcp-sessions-per-source", 0, "Limit concurrent TCP connections of each source IP")
+	flag.IntVar(&key.MaxUDPSessionsPerSource, "max-udp-sessions-per-source", 0, "Limit concurrent UDP sessions of each source IP")
+	flag.IntVar(&key.TCPMaxConnAttempts, "tcp-max-conn-attempts", 0, "Limit in-flight TCP handshakes, beyond which SYNs are dropped (default 2048)")
 	flag.BoolVarP(&versionFlag, "version", "v", false, "Show version and then quit")
 	flag.Parse()
 }
@@ -49,22 +94,65 @@ func main() {
 		os.Exit(0)
 	}
 
+	k, err := loadKey()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	engine.Insert(k)
+	engine.SetLoader(loadKey)
+
+	engine.Start()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigCh {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reload()
+	}
+
+	// A second signal stops without waiting for the connections
+	// to drain.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for sig := range sigCh {
+			if sig != syscall.SIGHUP {
+				log.Warnf("[ENGINE] forced to stop")
+				cancel()
+				return
+			}
+		}
+	}()
+	engine.Shutdown(ctx)
+}
+
+// loadKey returns the settings of the command line flags, overridden
+// by the configuration file if any.
+func loadKey() (*engine.Key, error) {
+	// The flags are copied, so that they are the base of every reload.
+	k := *key
 	if configFile != "" {
 		data, err := os.ReadFile(configFile)
 		if err != nil {
-			log.Fatalf("Failed to read config file '%s': %v", configFile, err)
+			return nil, fmt.Errorf("Failed to read config file '%s': %w", configFile, err)
 		}
-		if err = yaml.Unmarshal(data, key); err != nil {
-			log.Fatalf("Failed to unmarshal config file '%s': %v", configFile, err)
+		if err = yaml.Unmarshal(data, &k); err != nil {
+			return nil, fmt.Errorf("Failed to unmarshal config file '%s': %w", configFile, err)
 		}
 	}
+	return &k, nil
+}
 
-	engine.Insert(key)
-
-	engine.Start()
-	defer engine.Stop()
-
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
+// reload reloads the configuration on SIGHUP.
+func reload() {
+	k, err := loadKey()
+	if err != nil {
+		log.Errorf("[ENGINE] failed to reload: %v", err)
+		return
+	}
+	if _, err = engine.Reload(k); err != nil {
+		log.Errorf("[ENGINE] failed to reload: %v", err)
+	}
 }
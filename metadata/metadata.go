@@ -7,6 +7,7 @@ import (
 
 // Metadata contains metadata of transport protocol sessions.
 type Metadata struct {
+	Device  string     `json:"device,omitempty"`
 	Network Network    `json:"network"`
 	SrcIP   netip.Addr `json:"sourceIP"`
 	MidIP   netip.Addr `json:"dialerIP"`
@@ -14,6 +15,10 @@ type Metadata struct {
 	SrcPort uint16     `json:"sourcePort"`
 	MidPort uint16     `json:"dialerPort"`
 	DstPort uint16     `json:"destinationPort"`
+	Proxy   string     `json:"proxy,omitempty"`
+
+	// Host is the domain name of the destination, if it's known.
+	Host string `json:"host,omitempty"`
 }
 
 func (m *Metadata) DestinationAddrPort() netip.AddrPort {
@@ -54,3 +54,13 @@ func Parse(proxyURL *url.URL) (Proxy, error) {
 	}
 	return p.parse(proxyURL)
 }
+
+// Protocols returns the names of the registered proxy protocols.
+func Protocols() []string {
+	protocols, _ := atomicProtocols.Load().([]protocol)
+	names := make([]string, 0, len(protocols))
+	for _, p := range protocols {
+		names = append(names, p.name)
+	}
+	return names
+}
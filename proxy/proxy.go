@@ -3,12 +3,31 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"net"
 
 	M "github.com/xjasonlyu/tun2socks/v2/metadata"
 )
 
+// ErrRejected indicates that a connection is rejected by policy.
+var ErrRejected = errors.New("proxy: rejected")
+
 type Proxy interface {
 	DialContext(context.Context, *M.Metadata) (net.Conn, error)
 	DialUDP(*M.Metadata) (net.PacketConn, error)
 }
+
+// Named returns p with its name, e.g. the URL it's parsed from, which
+// is reported as the proxy of the connections through it.
+func Named(p Proxy, name string) Proxy {
+	return &namedProxy{Proxy: p, name: name}
+}
+
+type namedProxy struct {
+	Proxy
+	name string
+}
+
+func (p *namedProxy) String() string {
+	return p.name
+}
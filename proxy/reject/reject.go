@@ -22,7 +22,7 @@ func (r *Reject) DialContext(context.Context, *M.Metadata) (net.Conn, error) {
 }
 
 func (r *Reject) DialUDP(*M.Metadata) (net.PacketConn, error) {
-	return &nopPacketConn{}, nil
+	return nil, proxy.ErrRejected
 }
 
 type nopConn struct{}
@@ -36,16 +36,6 @@ func (rw *nopConn) SetDeadline(time.Time) error      { return nil }
 func (rw *nopConn) SetReadDeadline(time.Time) error  { return nil }
 func (rw *nopConn) SetWriteDeadline(time.Time) error { return nil }
 
-type nopPacketConn struct{}
-
-func (npc *nopPacketConn) WriteTo(b []byte, _ net.Addr) (n int, err error) { return len(b), nil }
-func (npc *nopPacketConn) ReadFrom([]byte) (int, net.Addr, error)          { return 0, nil, io.EOF }
-func (npc *nopPacketConn) Close() error                                    { return nil }
-func (npc *nopPacketConn) LocalAddr() net.Addr                             { return &net.UDPAddr{IP: net.IPv4zero, Port: 0} }
-func (npc *nopPacketConn) SetDeadline(time.Time) error                     { return nil }
-func (npc *nopPacketConn) SetReadDeadline(time.Time) error                 { return nil }
-func (npc *nopPacketConn) SetWriteDeadline(time.Time) error                { return nil }
-
 func Parse(*url.URL) (proxy.Proxy, error) { return New() }
 
 func init() {
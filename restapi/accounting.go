@@ -0,0 +1,60 @@
+package restapi
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/statistic"
+)
+
+// defaultTop is the number of accounts returned by default.
+const defaultTop = 10
+
+func init() {
+	registerEndpoint("/accounting", (*Server).accountingRouter)
+}
+
+func (s *Server) accountingRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/sources", s.accountsHandler(s.manager.TopSources))
+	r.Get("/destinations", s.accountsHandler(s.manager.TopDestinations))
+	r.Delete("/", s.resetAccounts)
+	return r
+}
+
+// accountsHandler returns the handler of the top accounts of f. Query
+// parameters:
+//
+//	top  number of accounts, 10 by default, or 0 for all
+//	sort total (default), upload, download or connections
+func (s *Server) accountsHandler(f func(int, string) ([]statistic.Account, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		n := defaultTop
+		if v := query.Get("top"); v != "" {
+			var err error
+			if n, err = strconv.Atoi(v); err != nil {
+				render.Status(r, http.StatusBadRequest)
+				render.JSON(w, r, ErrBadRequest)
+				return
+			}
+		}
+
+		accounts, err := f(n, query.Get("sort"))
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, newError(err.Error()))
+			return
+		}
+		render.JSON(w, r, render.M{"accounts": accounts})
+	}
+}
+
+func (s *Server) resetAccounts(w http.ResponseWriter, r *http.Request) {
+	s.manager.RestoreAccounts(nil)
+	render.NoContent(w, r)
+}
@@ -0,0 +1,108 @@
+package restapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/go-chi/render"
+
+	"github.com/xjasonlyu/tun2socks/v2/core/capture"
+)
+
+func init() {
+	registerEndpoint("/capture", func(s *Server) http.Handler {
+		return http.HandlerFunc(s.getCapture)
+	})
+}
+
+// getCapture streams the packet capture of devices, until the client
+// disconnects or the limits are reached. Query parameters:
+//
+//	format   pcapng (default) or pcap
+//	filter   filter of packets, e.g. "tcp and port 443"
+//	snaplen  maximum bytes captured per packet
+//	size     maximum bytes of the capture, e.g. 10MB
+//	duration maximum duration of the capture, e.g. 30s
+func (s *Server) getCapture(w http.ResponseWriter, r *http.Request) {
+	if s.captureFunc == nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, ErrUninitialized)
+		return
+	}
+
+	opts, err := parseCaptureOptions(r)
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", opts.Format.ContentType())
+	w.Header().Set("Content-Disposition", "attachment; filename=capture."+opts.Format.String())
+
+	session, err := s.captureFunc(&flushWriter{w}, opts)
+	if err != nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+
+	select {
+	case <-session.Done():
+	case <-r.Context().Done():
+		session.Stop()
+	}
+}
+
+func parseCaptureOptions(r *http.Request) (*capture.Options, error) {
+	query := r.URL.Query()
+	opts := &capture.Options{}
+
+	var err error
+	if opts.Format, err = capture.ParseFormat(query.Get("format")); err != nil {
+		return nil, err
+	}
+
+	if s := query.Get("filter"); s != "" {
+		if opts.Filter, err = capture.ParseFilter(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s := query.Get("snaplen"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		opts.SnapLen = uint32(n)
+	}
+
+	if s := query.Get("size"); s != "" {
+		if opts.MaxSize, err = units.RAMInBytes(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s := query.Get("duration"); s != "" {
+		if opts.Duration, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// flushWriter flushes each write to the client, so that
+// packets are streamed as soon as they are captured.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (fw *flushWriter) Write(b []byte) (int, error) {
+	n, err := fw.w.Write(b)
+	if err == nil {
+		err = http.NewResponseController(fw.w).Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,37 @@
+package restapi
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+func init() {
+	registerEndpoint("/configs", func(s *Server) http.Handler {
+		r := chi.NewRouter()
+		r.Put("/", s.reloadConfigs)
+		return r
+	})
+}
+
+// reloadConfigs reloads the configuration, and responds with the
+// settings changed which require restart to take effect.
+func (s *Server) reloadConfigs(w http.ResponseWriter, r *http.Request) {
+	if s.reloadFunc == nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, ErrUninitialized)
+		return
+	}
+
+	restart, err := s.reloadFunc()
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+	if restart == nil {
+		restart = []string{}
+	}
+	render.JSON(w, r, render.M{"restart": restart})
+}
@@ -17,20 +17,21 @@ import (
 const defaultInterval = 1000
 
 func init() {
-	registerEndpoint("/connections", connectionRouter())
+	registerEndpoint("/connections", (*Server).connectionRouter)
 }
 
-func connectionRouter() http.Handler {
+func (s *Server) connectionRouter() http.Handler {
 	r := chi.NewRouter()
-	r.Get("/", getConnections)
-	r.Delete("/", closeAllConnections)
-	r.Delete("/{id}", closeConnection)
+	r.Get("/", s.getConnections)
+	r.Get("/closed", s.getClosedConnections)
+	r.Delete("/", s.closeAllConnections)
+	r.Delete("/{id}", s.closeConnection)
 	return r
 }
 
-func getConnections(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getConnections(w http.ResponseWriter, r *http.Request) {
 	if !websocket.IsWebSocketUpgrade(r) {
-		render.JSON(w, r, statistic.DefaultManager.Snapshot())
+		render.JSON(w, r, s.manager.Snapshot())
 		return
 	}
 
@@ -52,10 +53,19 @@ func getConnections(w http.ResponseWriter, r *http.Request) {
 		interval = t
 	}
 
+	// Each message carries the connections closed since the
+	// previous one as well, starting with those recently closed.
+	var seq uint64
 	buf := &bytes.Buffer{}
 	sendSnapshot := func() error {
+		var closed []statistic.ClosedConnection
+		closed, seq = s.manager.ClosedSince(seq)
+
 		buf.Reset()
-		if err := json.NewEncoder(buf).Encode(statistic.DefaultManager.Snapshot()); err != nil {
+		if err := json.NewEncoder(buf).Encode(struct {
+			*statistic.Snapshot
+			Closed []statistic.ClosedConnection `json:"closed"`
+		}{s.manager.Snapshot(), closed}); err != nil {
 			return err
 		}
 
@@ -75,22 +85,28 @@ func getConnections(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func closeConnection(w http.ResponseWriter, r *http.Request) {
+// getClosedConnections returns the recently closed connections, from
+// the oldest, with the reasons why they are closed.
+func (s *Server) getClosedConnections(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, render.M{"closed": s.manager.Closed()})
+}
+
+func (s *Server) closeConnection(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	snapshot := statistic.DefaultManager.Snapshot()
+	snapshot := s.manager.Snapshot()
 	for _, c := range snapshot.Connections {
 		if id == c.ID() {
-			_ = c.Close()
+			_ = c.Kill()
 			break
 		}
 	}
 	render.NoContent(w, r)
 }
 
-func closeAllConnections(w http.ResponseWriter, r *http.Request) {
-	snapshot := statistic.DefaultManager.Snapshot()
+func (s *Server) closeAllConnections(w http.ResponseWriter, r *http.Request) {
+	snapshot := s.manager.Snapshot()
 	for _, c := range snapshot.Connections {
-		_ = c.Close()
+		_ = c.Kill()
 	}
 	render.NoContent(w, r)
 }
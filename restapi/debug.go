@@ -10,7 +10,9 @@ import (
 )
 
 func init() {
-	registerEndpoint("/debug/pprof/", pprofRouter())
+	registerEndpoint("/debug/pprof/", func(*Server) http.Handler {
+		return pprofRouter()
+	})
 }
 
 func pprofRouter() http.Handler {
@@ -0,0 +1,53 @@
+package restapi
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+func init() {
+	registerEndpoint("/limits", (*Server).limitRouter)
+}
+
+func (s *Server) limitRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", s.getRateLimits)
+	r.Put("/", s.setRateLimits)
+	return r
+}
+
+func (s *Server) getRateLimits(w http.ResponseWriter, r *http.Request) {
+	if s.rateLimitFunc == nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, ErrUninitialized)
+		return
+	}
+	render.JSON(w, r, s.rateLimitFunc())
+}
+
+// setRateLimits sets the rate limits in bytes per second, of which those
+// left out of the body are kept, e.g.
+// {"global": {"download": 10485760}, "outbound": {"upload": 1048576}}.
+// A rate of zero is unlimited.
+func (s *Server) setRateLimits(w http.ResponseWriter, r *http.Request) {
+	if s.rateLimitFunc == nil || s.setRateLimitFunc == nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, ErrUninitialized)
+		return
+	}
+
+	limits := s.rateLimitFunc()
+	if err := render.DecodeJSON(r.Body, &limits); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+	if err := s.setRateLimitFunc(limits); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+	render.JSON(w, r, limits)
+}
@@ -0,0 +1,133 @@
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/gorilla/websocket"
+
+	"github.com/xjasonlyu/tun2socks/v2/log"
+)
+
+func init() {
+	registerEndpoint("/logs", func(*Server) http.Handler {
+		r := chi.NewRouter()
+		r.Get("/", getLogs)
+		r.Get("/level", getLogLevel)
+		r.Put("/level", setLogLevel)
+		return r
+	})
+}
+
+// getLogs streams the log entries at the level of the query parameter
+// or above, info by default, over WebSocket if it's upgraded, or as
+// server-sent events otherwise. The level is independent of the level
+// of the logger, so that debug logs are streamed without changing it.
+func getLogs(w http.ResponseWriter, r *http.Request) {
+	level := log.InfoLevel
+	if v := r.URL.Query().Get("level"); v != "" {
+		var err error
+		if level, err = log.ParseLevel(v); err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, newError(err.Error()))
+			return
+		}
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		conn, err := _upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Messages from the client are discarded, and reading them
+		// detects the connection closed.
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		streamLogs(ctx, level, func(e log.Entry) error {
+			return conn.WriteJSON(e)
+		})
+		return
+	}
+
+	f, ok := w.(http.Flusher)
+	if !ok {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, newError("Streaming unsupported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	streamLogs(r.Context(), level, func(e log.Entry) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return err
+		}
+		f.Flush()
+		return nil
+	})
+}
+
+// streamLogs sends the log entries at level or above, until ctx is done
+// or send fails.
+func streamLogs(ctx context.Context, level log.Level, send func(log.Entry) error) {
+	ch, unsubscribe := log.Subscribe(level)
+	defer unsubscribe()
+
+	for {
+		select {
+		case e := <-ch:
+			if err := send(e); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func getLogLevel(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, render.M{"level": log.FormatLevel(log.GetLevel())})
+}
+
+// setLogLevel changes the level of the logger, e.g. {"level": "debug"}.
+func setLogLevel(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Level string `json:"level"`
+	}{}
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+
+	level, err := log.ParseLevel(req.Level)
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+	log.SetLevel(level)
+	render.JSON(w, r, render.M{"level": log.FormatLevel(level)})
+}
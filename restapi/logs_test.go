@@ -0,0 +1,84 @@
+package restapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xjasonlyu/tun2socks/v2/log"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/statistic"
+)
+
+// setLogger sets the global logger of level until the test finishes,
+// after which the level before is restored.
+func setLogger(t *testing.T, level log.Level) {
+	prev := log.GetLevel()
+	t.Cleanup(func() { log.SetLogger(log.Must(log.NewLeveled(prev))) })
+	log.SetLogger(log.Must(log.NewLeveled(level)))
+}
+
+func TestLogLevel(t *testing.T) {
+	setLogger(t, log.InfoLevel)
+	h := NewServer(statistic.DefaultManager).Handler("")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/logs/level", strings.NewReader(`{"level": "silent"}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, log.SilentLevel, log.GetLevel())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/logs/level", nil))
+	assert.JSONEq(t, `{"level": "silent"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/logs/level", strings.NewReader(`{"level": "invalid"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestStreamLogs(t *testing.T) {
+	// The logger is silent, while debug logs are still streamed.
+	setLogger(t, log.SilentLevel)
+
+	srv := httptest.NewServer(NewServer(statistic.DefaultManager).Handler(""))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/logs?level=debug", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	log.Debugf("[TEST] streamed")
+
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var e log.Entry
+		require.NoError(t, json.Unmarshal([]byte(data), &e))
+		assert.Equal(t, "debug", e.Level)
+		assert.Equal(t, "[TEST] streamed", e.Message)
+		return
+	}
+	t.Fatalf("no entry streamed: %v", sc.Err())
+}
+
+func TestStreamLogsUnsupported(t *testing.T) {
+	// The writer hides http.Flusher of the recorder.
+	w := httptest.NewRecorder()
+	getLogs(struct{ http.ResponseWriter }{w}, httptest.NewRequest(http.MethodGet, "/logs", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
@@ -0,0 +1,185 @@
+package restapi
+
+import (
+	"bytes"
+	"cmp"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/statistic"
+)
+
+const (
+	metricsNamespace   = "tun2socks"
+	metricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+func init() {
+	registerEndpoint("/metrics", func(s *Server) http.Handler {
+		return http.HandlerFunc(s.getMetrics)
+	})
+}
+
+// getMetrics exports the statistics in the OpenMetrics text format, for
+// Prometheus and the like to scrape.
+func (s *Server) getMetrics(w http.ResponseWriter, r *http.Request) {
+	m := &metricsWriter{}
+	s.writeTrafficMetrics(m)
+	s.writeConnectionMetrics(m)
+	s.writeDialMetrics(m)
+	s.writeSessionMetrics(m)
+	if s.statsFunc != nil {
+		stats := s.statsFunc()
+		writeNetstackMetrics(m, reflect.ValueOf(&stats).Elem(), "netstack")
+	}
+	m.b.WriteString("# EOF\n")
+
+	w.Header().Set("Content-Type", metricsContentType)
+	w.Write(m.b.Bytes())
+}
+
+func (s *Server) writeTrafficMetrics(m *metricsWriter) {
+	up, down := s.manager.Total()
+	m.family("upload_bytes", "counter", "Bytes uploaded through the proxy.")
+	m.sample("upload_bytes_total", nil, strconv.FormatInt(up, 10))
+	m.family("download_bytes", "counter", "Bytes downloaded through the proxy.")
+	m.sample("download_bytes_total", nil, strconv.FormatInt(down, 10))
+}
+
+func (s *Server) writeConnectionMetrics(m *metricsWriter) {
+	type key struct{ network, proxy string }
+	counts := make(map[key]int)
+	for _, c := range s.manager.Snapshot().Connections {
+		md := c.Metadata()
+		counts[key{md.Network.String(), md.Proxy}]++
+	}
+	keys := slices.SortedFunc(maps.Keys(counts), func(a, b key) int {
+		return cmp.Or(strings.Compare(a.network, b.network), strings.Compare(a.proxy, b.proxy))
+	})
+
+	m.family("connections", "gauge", "Active connections by network and proxy.")
+	for _, k := range keys {
+		m.sample("connections", []string{"network", k.network, "proxy", k.proxy}, strconv.Itoa(counts[k]))
+	}
+}
+
+func (s *Server) writeDialMetrics(m *metricsWriter) {
+	stats := s.manager.DialStats()
+
+	m.family("dial_duration_seconds", "histogram", "Latencies of successful dials through the proxy.")
+	for _, ds := range stats {
+		for i, le := range statistic.DialBuckets {
+			m.sample("dial_duration_seconds_bucket", []string{"network", ds.Network, "le", formatFloat(le.Seconds())},
+				strconv.FormatUint(ds.Buckets[i], 10))
+		}
+		m.sample("dial_duration_seconds_bucket", []string{"network", ds.Network, "le", "+Inf"}, strconv.FormatUint(ds.Count, 10))
+		m.sample("dial_duration_seconds_count", []string{"network", ds.Network}, strconv.FormatUint(ds.Count, 10))
+		m.sample("dial_duration_seconds_sum", []string{"network", ds.Network}, formatFloat(ds.Sum.Seconds()))
+	}
+
+	m.family("dial_errors", "counter", "Failed dials through the proxy.")
+	for _, ds := range stats {
+		m.sample("dial_errors_total", []string{"network", ds.Network}, strconv.FormatUint(ds.Errors, 10))
+	}
+}
+
+func (s *Server) writeSessionMetrics(m *metricsWriter) {
+	if s.sessionFunc == nil {
+		return
+	}
+	stats := s.sessionFunc()
+
+	m.family("sessions", "gauge", "Sessions admitted within the limits by network.")
+	m.sample("sessions", []string{"network", "tcp"}, strconv.Itoa(stats.TCP))
+	m.sample("sessions", []string{"network", "udp"}, strconv.Itoa(stats.UDP))
+
+	m.family("sessions_refused", "counter", "SYNs and datagrams of new sessions refused over the limits.")
+	m.sample("sessions_refused_total", []string{"network", "tcp"}, strconv.FormatUint(stats.RefusedTCP, 10))
+	m.sample("sessions_refused_total", []string{"network", "udp"}, strconv.FormatUint(stats.RefusedUDP, 10))
+}
+
+// writeNetstackMetrics writes the counters of the network stack, walking
+// the stats struct as encodeToJSON does. Each counter is named after its
+// path, e.g. netstack_tcp_active_connection_openings.
+func writeNetstackMetrics(m *metricsWriter, value reflect.Value, prefix string) {
+	for i := range value.NumField() {
+		name := prefix + "_" + snakeCase(value.Type().Field(i).Name)
+
+		switch v := value.Field(i).Addr().Interface().(type) {
+		case **tcpip.StatCounter:
+			m.family(name, "counter", "")
+			m.sample(name+"_total", nil, strconv.FormatUint((*v).Value(), 10))
+		case **tcpip.IntegralStatCounterMap:
+			m.family(name, "counter", "")
+			for _, k := range (*v).Keys() {
+				if counter, ok := (*v).Get(k); ok {
+					m.sample(name+"_total", []string{"key", strconv.FormatUint(k, 10)}, strconv.FormatUint(counter.Value(), 10))
+				}
+			}
+		default:
+			writeNetstackMetrics(m, value.Field(i), name)
+		}
+	}
+}
+
+// metricsWriter writes metric families in the OpenMetrics text format.
+type metricsWriter struct {
+	b bytes.Buffer
+}
+
+func (m *metricsWriter) family(name, typ, help string) {
+	m.b.WriteString("# TYPE " + metricsNamespace + "_" + name + " " + typ + "\n")
+	if help != "" {
+		m.b.WriteString("# HELP " + metricsNamespace + "_" + name + " " + help + "\n")
+	}
+}
+
+// sample writes a sample of name, with labels given as name and value
+// pairs.
+func (m *metricsWriter) sample(name string, labels []string, value string) {
+	m.b.WriteString(metricsNamespace + "_" + name)
+	if len(labels) > 0 {
+		m.b.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				m.b.WriteByte(',')
+			}
+			m.b.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		m.b.WriteByte('}')
+	}
+	m.b.WriteString(" " + value + "\n")
+}
+
+var _labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return _labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// snakeCase converts the Go name s to snake case, keeping acronyms
+// together, e.g. ActiveConnectionOpenings to active_connection_openings,
+// and IPv4 to ipv4.
+func snakeCase(s string) string {
+	var b strings.Builder
+	var prev rune
+	for i, r := range s {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+		prev = r
+	}
+	return b.String()
+}
@@ -0,0 +1,68 @@
+package restapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gvisor.dev/gvisor/pkg/tcpip"
+
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/statistic"
+)
+
+func TestMetrics(t *testing.T) {
+	manager := statistic.NewManager()
+	defer manager.Close()
+	manager.PushUploaded(100)
+	metadata := &M.Metadata{Network: M.TCP}
+	manager.ObserveDial(metadata, 20*time.Millisecond, nil)
+	manager.ObserveDial(metadata, 0, errors.New("refused"))
+
+	s := NewServer(manager)
+	s.SetStatsFunc(func() tcpip.Stats {
+		var stats tcpip.Stats
+		return stats.FillIn()
+	})
+	s.SetSessionFuncs(func() tunnel.SessionStats {
+		return tunnel.SessionStats{TCP: 2, RefusedUDP: 3}
+	}, nil)
+
+	w := httptest.NewRecorder()
+	s.Handler("").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, metricsContentType, w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	for _, line := range []string{
+		"tun2socks_upload_bytes_total 100",
+		`tun2socks_dial_duration_seconds_bucket{network="tcp",le="0.01"} 0`,
+		`tun2socks_dial_duration_seconds_bucket{network="tcp",le="0.025"} 1`,
+		`tun2socks_dial_duration_seconds_bucket{network="tcp",le="+Inf"} 1`,
+		`tun2socks_dial_duration_seconds_sum{network="tcp"} 0.02`,
+		`tun2socks_dial_errors_total{network="tcp"} 1`,
+		`tun2socks_sessions{network="tcp"} 2`,
+		`tun2socks_sessions_refused_total{network="udp"} 3`,
+		"# TYPE tun2socks_netstack_tcp_active_connection_openings counter",
+		"tun2socks_netstack_tcp_active_connection_openings_total 0",
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+	assert.True(t, strings.HasSuffix(body, "# EOF\n"))
+}
+
+func TestSnakeCase(t *testing.T) {
+	for s, want := range map[string]string{
+		"ActiveConnectionOpenings": "active_connection_openings",
+		"IPv4":                     "ipv4",
+		"TCP":                      "tcp",
+		"MalformedL4RcvdPackets":   "malformed_l4_rcvd_packets",
+	} {
+		assert.Equal(t, want, snakeCase(s))
+	}
+}
@@ -12,18 +12,14 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip"
 )
 
-var _stackStatsFunc func() tcpip.Stats
-
-func SetStatsFunc(s func() tcpip.Stats) {
-	_stackStatsFunc = s
-}
-
 func init() {
-	registerEndpoint("/netstats", http.HandlerFunc(getNetStats))
+	registerEndpoint("/netstats", func(s *Server) http.Handler {
+		return http.HandlerFunc(s.getNetStats)
+	})
 }
 
-func getNetStats(w http.ResponseWriter, r *http.Request) {
-	if _stackStatsFunc == nil {
+func (s *Server) getNetStats(w http.ResponseWriter, r *http.Request) {
+	if s.statsFunc == nil {
 		render.Status(r, http.StatusInternalServerError)
 		render.JSON(w, r, ErrUninitialized)
 		return
@@ -31,9 +27,9 @@ func getNetStats(w http.ResponseWriter, r *http.Request) {
 
 	b := &bytes.Buffer{}
 	snapshot := func() []byte {
-		s := _stackStatsFunc()
+		stats := s.statsFunc()
 		b.Reset() /* reset buffer */
-		encodeToJSON(reflect.ValueOf(&s).Elem(), b)
+		encodeToJSON(reflect.ValueOf(&stats).Elem(), b)
 		return b.Bytes()
 	}
 
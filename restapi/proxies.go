@@ -0,0 +1,75 @@
+package restapi
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+
+	"github.com/xjasonlyu/tun2socks/v2/proxy"
+)
+
+func init() {
+	registerEndpoint("/proxies", (*Server).proxyRouter)
+}
+
+func (s *Server) proxyRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", s.getProxies)
+	r.Put("/current", s.setCurrentProxy)
+	return r
+}
+
+func (s *Server) getProxies(w http.ResponseWriter, r *http.Request) {
+	if s.proxyFunc == nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, ErrUninitialized)
+		return
+	}
+
+	render.JSON(w, r, render.M{
+		"current":   s.proxyFunc(),
+		"protocols": proxy.Protocols(),
+	})
+}
+
+// setCurrentProxy switches to the proxy of the URL in the body, e.g.
+// {"url": "socks5://127.0.0.1:1080"}. The connections established
+// through the previous proxy are closed if close is true.
+func (s *Server) setCurrentProxy(w http.ResponseWriter, r *http.Request) {
+	if s.proxyFunc == nil || s.setProxyFunc == nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, ErrUninitialized)
+		return
+	}
+
+	req := struct {
+		URL   string `json:"url"`
+		Close bool   `json:"close"`
+	}{}
+	if err := render.DecodeJSON(r.Body, &req); err != nil || req.URL == "" {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+
+	// The connections are taken before switching, so that only
+	// those through the previous proxy are closed, not the ones
+	// through the fallback proxy, or of the new one.
+	previous := s.proxyFunc()
+	snapshot := s.manager.Snapshot()
+	current, err := s.setProxyFunc(req.URL)
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+	if req.Close {
+		for _, c := range snapshot.Connections {
+			if c.Metadata().Proxy == previous {
+				_ = c.Kill()
+			}
+		}
+	}
+	render.JSON(w, r, render.M{"current": current})
+}
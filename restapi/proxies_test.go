@@ -0,0 +1,93 @@
+package restapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/statistic"
+)
+
+func TestProxies(t *testing.T) {
+	manager := statistic.NewManager()
+	defer manager.Close()
+
+	current := "socks5://127.0.0.1:1080"
+	s := NewServer(manager)
+	s.SetProxyFuncs(func() string { return current }, func(url string) (string, error) {
+		if !strings.Contains(url, "://") {
+			return "", errors.New("invalid proxy")
+		}
+		current = url
+		return current, nil
+	})
+	h := s.Handler("")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/proxies", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var proxies struct {
+		Current   string   `json:"current"`
+		Protocols []string `json:"protocols"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&proxies))
+	assert.Equal(t, current, proxies.Current)
+	assert.NotEmpty(t, proxies.Protocols)
+
+	put := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/proxies/current", strings.NewReader(body)))
+		return w
+	}
+
+	track := func(proxy string) net.Conn {
+		local, remote := net.Pipe()
+		t.Cleanup(func() { remote.Close() })
+		statistic.NewTCPTracker(local, &M.Metadata{Network: M.TCP, Proxy: proxy}, manager)
+		return remote
+	}
+	track("socks5://127.0.0.1:1080")
+	other := track("direct://")
+
+	// The connections are kept unless close is true.
+	w = put(`{"url": "http://127.0.0.1:8080"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"current": "http://127.0.0.1:8080"}`, w.Body.String())
+	assert.Len(t, manager.Snapshot().Connections, 2)
+
+	// Only those through the previous proxy are closed.
+	previous := track("http://127.0.0.1:8080")
+	w = put(`{"url": "socks5://127.0.0.1:1080", "close": true}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, manager.Snapshot().Connections, 2)
+	_, err := previous.Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	for _, c := range manager.Snapshot().Connections {
+		assert.NotEqual(t, "http://127.0.0.1:8080", c.Metadata().Proxy)
+	}
+	other.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err = other.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	for _, body := range []string{`{"url": "invalid"}`, `{"url": ""}`, `{`} {
+		w = put(body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	assert.Equal(t, "socks5://127.0.0.1:1080", current)
+
+	// Not available without the proxy functions.
+	w = httptest.NewRecorder()
+	NewServer(manager).Handler("").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/proxies", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
@@ -0,0 +1,42 @@
+package restapi
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+func init() {
+	registerEndpoint("/quotas", (*Server).quotaRouter)
+}
+
+func (s *Server) quotaRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", s.getQuotas)
+	r.Delete("/", s.resetQuotas)
+	return r
+}
+
+// getQuotas returns the traffic of the sources and outbounds in the
+// period, against their quotas.
+func (s *Server) getQuotas(w http.ResponseWriter, r *http.Request) {
+	if s.quotaFunc == nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, ErrUninitialized)
+		return
+	}
+	render.JSON(w, r, s.quotaFunc())
+}
+
+// resetQuotas counts the traffic of the period from zero, which lifts
+// the actions on those over quota.
+func (s *Server) resetQuotas(w http.ResponseWriter, r *http.Request) {
+	if s.resetQuotaFunc == nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, ErrUninitialized)
+		return
+	}
+	s.resetQuotaFunc()
+	render.NoContent(w, r)
+}
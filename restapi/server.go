@@ -3,6 +3,7 @@ package restapi
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"net"
 	"net/http"
 	"strings"
@@ -13,7 +14,13 @@ import (
 	"github.com/go-chi/render"
 	"github.com/gorilla/websocket"
 
+	"gvisor.dev/gvisor/pkg/tcpip"
+
+	"github.com/xjasonlyu/tun2socks/v2/core/capture"
 	V "github.com/xjasonlyu/tun2socks/v2/internal/version"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/quota"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/shaper"
 	"github.com/xjasonlyu/tun2socks/v2/tunnel/statistic"
 )
 
@@ -24,14 +31,126 @@ var (
 		},
 	}
 
-	_endpoints = make(map[string]http.Handler)
+	_endpoints = make(map[string]func(*Server) http.Handler)
+
+	// _defaultServer is the Server of the package-level functions.
+	_defaultServer = NewServer(statistic.DefaultManager)
 )
 
-func registerEndpoint(pattern string, handler http.Handler) {
+// registerEndpoint registers the handler of pattern, which is
+// built for each Server.
+func registerEndpoint(pattern string, handler func(*Server) http.Handler) {
 	_endpoints[pattern] = handler
 }
 
+// Server serves the REST API of an engine, whose connections and
+// traffic are tracked by its statistic manager.
+type Server struct {
+	manager     *statistic.Manager
+	statsFunc   func() tcpip.Stats
+	captureFunc func(io.Writer, *capture.Options) (*capture.Session, error)
+	reloadFunc  func() ([]string, error)
+
+	proxyFunc    func() string
+	setProxyFunc func(string) (string, error)
+
+	rateLimitFunc    func() shaper.Limits
+	setRateLimitFunc func(shaper.Limits) error
+
+	quotaFunc      func() quota.Status
+	resetQuotaFunc func()
+
+	sessionFunc         func() tunnel.SessionStats
+	setSessionLimitFunc func(tunnel.SessionLimits) error
+}
+
+// NewServer returns a Server of manager. The functions of the
+// engine should be set before it serves.
+func NewServer(manager *statistic.Manager) *Server {
+	return &Server{manager: manager}
+}
+
+// SetStatsFunc sets the function returning the stack statistics.
+func (s *Server) SetStatsFunc(f func() tcpip.Stats) {
+	s.statsFunc = f
+}
+
+// SetCaptureFunc sets the function starting packet captures.
+func (s *Server) SetCaptureFunc(f func(io.Writer, *capture.Options) (*capture.Session, error)) {
+	s.captureFunc = f
+}
+
+// SetReloadFunc sets the function reloading the configuration, which
+// returns the settings changed requiring restart.
+func (s *Server) SetReloadFunc(f func() ([]string, error)) {
+	s.reloadFunc = f
+}
+
+// SetProxyFuncs sets the functions returning the name of the current
+// proxy, and switching to the proxy of a URL.
+func (s *Server) SetProxyFuncs(get func() string, set func(string) (string, error)) {
+	s.proxyFunc, s.setProxyFunc = get, set
+}
+
+// SetRateLimitFuncs sets the functions returning and setting the rate
+// limits of the connections.
+func (s *Server) SetRateLimitFuncs(get func() shaper.Limits, set func(shaper.Limits) error) {
+	s.rateLimitFunc, s.setRateLimitFunc = get, set
+}
+
+// SetQuotaFuncs sets the functions returning the status of quotas, and
+// resetting the traffic counted against them.
+func (s *Server) SetQuotaFuncs(status func() quota.Status, reset func()) {
+	s.quotaFunc, s.resetQuotaFunc = status, reset
+}
+
+// SetSessionFuncs sets the functions returning the statistics of the
+// sessions, and setting the limits of them.
+func (s *Server) SetSessionFuncs(stats func() tunnel.SessionStats, set func(tunnel.SessionLimits) error) {
+	s.sessionFunc, s.setSessionLimitFunc = stats, set
+}
+
+func SetStatsFunc(f func() tcpip.Stats) {
+	_defaultServer.SetStatsFunc(f)
+}
+
+func SetCaptureFunc(f func(io.Writer, *capture.Options) (*capture.Session, error)) {
+	_defaultServer.SetCaptureFunc(f)
+}
+
+func SetReloadFunc(f func() ([]string, error)) {
+	_defaultServer.SetReloadFunc(f)
+}
+
+func SetProxyFuncs(get func() string, set func(string) (string, error)) {
+	_defaultServer.SetProxyFuncs(get, set)
+}
+
+func SetRateLimitFuncs(get func() shaper.Limits, set func(shaper.Limits) error) {
+	_defaultServer.SetRateLimitFuncs(get, set)
+}
+
+func SetQuotaFuncs(status func() quota.Status, reset func()) {
+	_defaultServer.SetQuotaFuncs(status, reset)
+}
+
+func SetSessionFuncs(stats func() tunnel.SessionStats, set func(tunnel.SessionLimits) error) {
+	_defaultServer.SetSessionFuncs(stats, set)
+}
+
+// Start serves the default Server at addr.
 func Start(addr, token string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return http.Serve(listener, _defaultServer.Handler(token))
+}
+
+// Handler returns the HTTP handler of s, which requires token
+// for authentication if it's not empty.
+func (s *Server) Handler(token string) http.Handler {
 	r := chi.NewRouter()
 
 	c := cors.New(cors.Options{
@@ -45,20 +164,14 @@ func Start(addr, token string) error {
 	r.Group(func(r chi.Router) {
 		r.Use(authenticator(token))
 		r.Get("/", hello)
-		r.Get("/traffic", traffic)
+		r.Get("/traffic", s.traffic)
 		r.Get("/version", version)
 		// attach HTTP handlers
 		for pattern, handler := range _endpoints {
-			r.Mount(pattern, handler)
+			r.Mount(pattern, handler(s))
 		}
 	})
-
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		return err
-	}
-
-	return http.Serve(listener, r)
+	return r
 }
 
 func hello(w http.ResponseWriter, r *http.Request) {
@@ -101,7 +214,7 @@ func authenticator(token string) func(http.Handler) http.Handler {
 	}
 }
 
-func traffic(w http.ResponseWriter, r *http.Request) {
+func (s *Server) traffic(w http.ResponseWriter, r *http.Request) {
 	var (
 		err    error
 		wsConn *websocket.Conn
@@ -125,7 +238,7 @@ func traffic(w http.ResponseWriter, r *http.Request) {
 	for range tick.C {
 		buf.Reset()
 
-		up, down := statistic.DefaultManager.Now()
+		up, down := s.manager.Now()
 		if err = json.NewEncoder(buf).Encode(struct {
 			Up   int64 `json:"up"`
 			Down int64 `json:"down"`
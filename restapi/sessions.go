@@ -0,0 +1,54 @@
+package restapi
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+func init() {
+	registerEndpoint("/sessions", (*Server).sessionRouter)
+}
+
+func (s *Server) sessionRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", s.getSessions)
+	r.Put("/limits", s.setSessionLimits)
+	return r
+}
+
+// getSessions returns the sessions admitted and refused, and the limits
+// of them.
+func (s *Server) getSessions(w http.ResponseWriter, r *http.Request) {
+	if s.sessionFunc == nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, ErrUninitialized)
+		return
+	}
+	render.JSON(w, r, s.sessionFunc())
+}
+
+// setSessionLimits sets the limits of new sessions, of which those left
+// out of the body are kept, e.g. {"tcp": 4096, "tcpPerSource": 256}. A
+// limit of zero is unlimited.
+func (s *Server) setSessionLimits(w http.ResponseWriter, r *http.Request) {
+	if s.sessionFunc == nil || s.setSessionLimitFunc == nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, ErrUninitialized)
+		return
+	}
+
+	limits := s.sessionFunc().Limits
+	if err := render.DecodeJSON(r.Body, &limits); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+	if err := s.setSessionLimitFunc(limits); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+	render.JSON(w, r, limits)
+}
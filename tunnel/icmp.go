@@ -0,0 +1,135 @@
+package tunnel
+
+import (
+	"net"
+	"net/netip"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+
+	"github.com/xjasonlyu/tun2socks/v2/buffer"
+	"github.com/xjasonlyu/tun2socks/v2/core/adapter"
+	"github.com/xjasonlyu/tun2socks/v2/dialer"
+	"github.com/xjasonlyu/tun2socks/v2/log"
+)
+
+// icmpEchoTimeout is the default timeout waiting for an ICMP echo reply.
+const icmpEchoTimeout = 10 * time.Second
+
+// maxICMPEchoes is the maximum number of ICMP echo requests forwarded at
+// the same time, beyond which they are dropped.
+const maxICMPEchoes = 256
+
+var _ adapter.NetworkHandler = (*Tunnel)(nil)
+
+// HandlePacket forwards ICMP echo requests to the real destination when
+// ICMP forwarding is enabled, or leaves them to the stack otherwise.
+func (t *Tunnel) HandlePacket(p adapter.Packet) bool {
+	if !t.icmpForwarding.Load() {
+		return false
+	}
+
+	pkt := p.Buffer()
+	defer pkt.DecRef()
+
+	var network string
+	switch pkt.NetworkProtocolNumber {
+	case header.IPv4ProtocolNumber:
+		if header.ICMPv4(pkt.TransportHeader().Slice()).Type() != header.ICMPv4Echo {
+			return false
+		}
+		network = "ip4"
+	case header.IPv6ProtocolNumber:
+		if header.ICMPv6(pkt.TransportHeader().Slice()).Type() != header.ICMPv6EchoRequest {
+			return false
+		}
+		network = "ip6"
+	default:
+		return false
+	}
+
+	netHdr := pkt.Network()
+	src := parseTCPIPAddress(netHdr.SourceAddress())
+	dst := parseTCPIPAddress(netHdr.DestinationAddress())
+
+	data := stack.PayloadSince(pkt.TransportHeader())
+	msg := append([]byte(nil), data.AsSlice()...)
+	data.Release()
+
+	select {
+	case t.icmpEchoes <- struct{}{}:
+	default:
+		log.Debugf("[ICMP] %s -> %s: too many echoes in flight, dropped", src, dst)
+		return true
+	}
+
+	go func() {
+		defer func() { <-t.icmpEchoes }()
+		t.handleICMPEcho(p, network, src, dst, msg)
+	}()
+	return true
+}
+
+func (t *Tunnel) handleICMPEcho(p adapter.Packet, network string, src, dst netip.Addr, msg []byte) {
+	pc, err := dialer.ListenICMP(network)
+	if err != nil {
+		log.Warnf("[ICMP] listen %s: %v", network, err)
+		return
+	}
+	defer pc.Close()
+
+	// Both ICMPv4 and ICMPv6 echo messages share the same layout
+	// of identifier and sequence number.
+	ident, seq := header.ICMPv4(msg).Ident(), header.ICMPv4(msg).Sequence()
+
+	start := time.Now()
+	if _, err = pc.WriteTo(msg, &net.UDPAddr{IP: dst.AsSlice()}); err != nil {
+		log.Debugf("[ICMP] %s -> %s: %v", src, dst, err)
+		return
+	}
+
+	buf := buffer.Get(buffer.MaxSegmentSize)
+	defer buffer.Put(buf)
+
+	pc.SetReadDeadline(start.Add(icmpEchoTimeout))
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			log.Debugf("[ICMP] %s -> %s: echo seq=%d: %v", src, dst, seq, err)
+			return
+		}
+		reply := buf[:n]
+
+		// Darwin delivers ICMPv4 with the IP header included, which
+		// never looks like a valid ICMP type, so it's safe to strip.
+		if network == "ip4" && n >= header.IPv4MinimumSize && header.IPVersion(reply) == header.IPv4Version {
+			reply = reply[header.IPv4(reply).HeaderLength():]
+		}
+
+		if !isICMPEchoReply(network, reply) || header.ICMPv4(reply).Sequence() != seq {
+			continue
+		}
+
+		// The kernel takes over the identifier of ping sockets,
+		// restore it to what the source expects.
+		header.ICMPv4(reply).SetIdent(ident)
+		if err = p.WriteBack(reply); err != nil {
+			log.Debugf("[ICMP] %s <- %s: %v", src, dst, err)
+			return
+		}
+		log.Debugf("[ICMP] %s <-> %s: echo seq=%d time=%s", src, dst, seq, time.Since(start))
+		return
+	}
+}
+
+func isICMPEchoReply(network string, b []byte) bool {
+	switch network {
+	case "ip4":
+		return len(b) >= header.ICMPv4MinimumSize && header.ICMPv4(b).Type() == header.ICMPv4EchoReply
+	case "ip6":
+		return len(b) >= header.ICMPv6MinimumSize && header.ICMPv6(b).Type() == header.ICMPv6EchoReply
+	default:
+		return false
+	}
+}
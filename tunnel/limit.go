@@ -0,0 +1,156 @@
+package tunnel
+
+import (
+	"errors"
+	"net/netip"
+	"sync"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// SessionLimits are the limits of concurrent TCP connections and UDP
+// sessions, in total and of each source address, each of which is
+// unlimited if zero.
+type SessionLimits struct {
+	TCP          int `json:"tcp"`
+	UDP          int `json:"udp"`
+	TCPPerSource int `json:"tcpPerSource"`
+	UDPPerSource int `json:"udpPerSource"`
+}
+
+// Validate returns an error if l is invalid.
+func (l SessionLimits) Validate() error {
+	if l.TCP < 0 || l.UDP < 0 || l.TCPPerSource < 0 || l.UDPPerSource < 0 {
+		return errors.New("invalid session limits")
+	}
+	return nil
+}
+
+// SessionStats are the sessions admitted, and the SYNs and datagrams of
+// new sessions refused over the limits.
+type SessionStats struct {
+	Limits     SessionLimits `json:"limits"`
+	TCP        int           `json:"tcp"`
+	UDP        int           `json:"udp"`
+	RefusedTCP uint64        `json:"refusedTcp"`
+	RefusedUDP uint64        `json:"refusedUdp"`
+}
+
+// sessionKey is the key of a session, which is told apart by the NIC
+// it came in on, as the sessions of different devices may share the
+// same transport endpoint ID, e.g. of clients of the same addresses.
+type sessionKey struct {
+	nic   tcpip.NICID
+	proto tcpip.TransportProtocolNumber
+	id    stack.TransportEndpointID
+}
+
+type sessionCount struct {
+	tcp, udp int
+}
+
+func (c *sessionCount) of(proto tcpip.TransportProtocolNumber) *int {
+	if proto == header.TCPProtocolNumber {
+		return &c.tcp
+	}
+	return &c.udp
+}
+
+// sessionLimiter counts the sessions admitted, in total and by source.
+type sessionLimiter struct {
+	mu       sync.Mutex
+	limits   SessionLimits
+	admitted map[sessionKey]netip.Addr
+	total    sessionCount
+	sources  map[netip.Addr]*sessionCount
+
+	refusedTCP, refusedUDP uint64
+}
+
+func newSessionLimiter() *sessionLimiter {
+	return &sessionLimiter{
+		admitted: make(map[sessionKey]netip.Addr),
+		sources:  make(map[netip.Addr]*sessionCount),
+	}
+}
+
+// Admit implements adapter.Limiter, which counts the session of id on
+// nic if it's within the limits.
+func (t *Tunnel) Admit(nic tcpip.NICID, proto tcpip.TransportProtocolNumber, id stack.TransportEndpointID) bool {
+	l := t.sessions
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit, perSource := l.limits.TCP, l.limits.TCPPerSource
+	if proto != header.TCPProtocolNumber {
+		limit, perSource = l.limits.UDP, l.limits.UDPPerSource
+	}
+
+	src := parseTCPIPAddress(id.RemoteAddress).Unmap()
+	c := l.sources[src]
+	if limit > 0 && *l.total.of(proto) >= limit ||
+		perSource > 0 && c != nil && *c.of(proto) >= perSource {
+		if proto == header.TCPProtocolNumber {
+			l.refusedTCP++
+		} else {
+			l.refusedUDP++
+		}
+		return false
+	}
+
+	if c == nil {
+		c = &sessionCount{}
+		l.sources[src] = c
+	}
+	*c.of(proto)++
+	*l.total.of(proto)++
+	l.admitted[sessionKey{nic, proto, id}] = src
+	return true
+}
+
+// Release implements adapter.Limiter. It's called by the Tunnel itself
+// once a session is handled, and does nothing if it's not admitted.
+func (t *Tunnel) Release(nic tcpip.NICID, proto tcpip.TransportProtocolNumber, id stack.TransportEndpointID) {
+	l := t.sessions
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := sessionKey{nic, proto, id}
+	src, ok := l.admitted[key]
+	if !ok {
+		return
+	}
+	delete(l.admitted, key)
+
+	*l.total.of(proto)--
+	if c := l.sources[src]; c != nil {
+		if *c.of(proto)--; *c == (sessionCount{}) {
+			delete(l.sources, src)
+		}
+	}
+}
+
+// SetSessionLimits sets the limits of new sessions. The sessions
+// established are kept even if they are over the limits.
+func (t *Tunnel) SetSessionLimits(limits SessionLimits) {
+	t.sessions.mu.Lock()
+	t.sessions.limits = limits
+	t.sessions.mu.Unlock()
+}
+
+// SessionStats returns the statistics of the sessions.
+func (t *Tunnel) SessionStats() SessionStats {
+	l := t.sessions
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return SessionStats{
+		Limits:     l.limits,
+		TCP:        l.total.tcp,
+		UDP:        l.total.udp,
+		RefusedTCP: l.refusedTCP,
+		RefusedUDP: l.refusedUDP,
+	}
+}
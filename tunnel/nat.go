@@ -0,0 +1,50 @@
+package tunnel
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NATType is the UDP NAT behavior of the Tunnel.
+type NATType uint32
+
+const (
+	// SymmetricNAT maps every (source, destination) pair to its own
+	// upstream session and only accepts replies from the destination.
+	SymmetricNAT NATType = iota
+
+	// PortRestrictedNAT maps every source to a single upstream session,
+	// and only accepts replies from addresses the source has sent to.
+	PortRestrictedNAT
+
+	// FullConeNAT maps every source to a single upstream session, and
+	// accepts replies from any remote address.
+	FullConeNAT
+)
+
+func (n NATType) String() string {
+	switch n {
+	case SymmetricNAT:
+		return "symmetric"
+	case PortRestrictedNAT:
+		return "port-restricted"
+	case FullConeNAT:
+		return "full-cone"
+	default:
+		return fmt.Sprintf("nat(%d)", n)
+	}
+}
+
+// ParseNATType parses a NATType from its name.
+func ParseNATType(s string) (NATType, error) {
+	switch strings.ToLower(s) {
+	case "symmetric":
+		return SymmetricNAT, nil
+	case "port-restricted":
+		return PortRestrictedNAT, nil
+	case "full-cone":
+		return FullConeNAT, nil
+	default:
+		return 0, fmt.Errorf("invalid NAT type: %s", s)
+	}
+}
@@ -0,0 +1,96 @@
+package quota
+
+import (
+	"context"
+	"net"
+	"net/netip"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/ratelimit"
+)
+
+// Conn returns c counted as a session of the source src through the
+// outbound, which is throttled while over quota with ActionThrottle.
+func (q *Quota) Conn(c net.Conn, src netip.Addr, outbound string) net.Conn {
+	return ratelimit.Conn(c, q.newCounter(src, outbound))
+}
+
+// PacketConn returns pc counted as a session of the source src through
+// the outbound, which is throttled while over quota with ActionThrottle.
+func (q *Quota) PacketConn(pc net.PacketConn, src netip.Addr, outbound string) net.PacketConn {
+	return ratelimit.PacketConn(pc, q.newCounter(src, outbound))
+}
+
+// counter implements ratelimit.Limiter, which counts the traffic of a
+// session, and waits for the throttles of it.
+type counter struct {
+	quota    *Quota
+	src      netip.Addr
+	outbound string
+	entries  atomic.Pointer[entries]
+}
+
+// entries are the entries of a session in a period.
+type entries struct {
+	gen              uint64
+	source, outbound *entry
+}
+
+func (q *Quota) newCounter(src netip.Addr, outbound string) *counter {
+	return &counter{quota: q, src: src.Unmap(), outbound: outbound}
+}
+
+// load returns the entries of the session in the current period, which
+// are only looked up again once the period is over.
+func (c *counter) load() *entries {
+	q := c.quota
+	if !q.now().Before(q.deadline.Load()) {
+		q.mu.Lock()
+		q.roll()
+		q.mu.Unlock()
+	}
+	if e := c.entries.Load(); e != nil && e.gen == q.gen.Load() {
+		return e
+	}
+
+	q.mu.Lock()
+	e := &entries{
+		gen:      q.gen.Load(),
+		source:   q.source(c.src),
+		outbound: q.outbound(c.outbound),
+	}
+	q.mu.Unlock()
+	c.entries.Store(e)
+	return e
+}
+
+func (c *counter) Wait(ctx context.Context, n int, _ bool) error {
+	q := c.quota
+	if !q.throttling.Load() {
+		return nil
+	}
+	e := c.load()
+	if quota := q.sourceQuota.Load(); quota > 0 && e.source.used.Load() >= quota {
+		if err := e.source.throttle.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	if quota := q.outboundQuota.Load(); quota > 0 && e.outbound.used.Load() >= quota {
+		if err := e.outbound.throttle.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *counter) Done(n int, _ bool) {
+	if n <= 0 {
+		return
+	}
+	e := c.load()
+	e.source.used.Add(int64(n))
+	e.outbound.used.Add(int64(n))
+}
+
+func (*counter) Close() {}
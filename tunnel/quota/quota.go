@@ -0,0 +1,341 @@
+// Package quota counts the traffic of each source address and of each
+// outbound, i.e. proxy, over a daily or monthly period, and tells which
+// of them are over their quotas.
+package quota
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
+
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/ratelimit"
+)
+
+// Periods of quotas, after which the traffic is counted from zero.
+const (
+	PeriodDaily   = "daily"
+	PeriodMonthly = "monthly"
+)
+
+// Actions on the sessions over quotas.
+const (
+	// ActionReject rejects new sessions.
+	ActionReject = "reject"
+	// ActionFallback switches new sessions to the fallback proxy.
+	ActionFallback = "fallback"
+	// ActionThrottle limits the rates of the sessions.
+	ActionThrottle = "throttle"
+)
+
+// Config is the configuration of quotas.
+type Config struct {
+	// Source and Outbound are the bytes of upload and download allowed
+	// to each source address and each outbound in a period, each of
+	// which is unlimited if zero.
+	Source   int64
+	Outbound int64
+
+	// Period is PeriodDaily, the default, or PeriodMonthly. Periods
+	// start at midnight in local time.
+	Period string
+
+	// Action is ActionReject, the default, ActionFallback or
+	// ActionThrottle.
+	Action string
+
+	// Throttle is the rate in bytes per second of upload and download
+	// each, of every source and outbound over quota with ActionThrottle.
+	Throttle int64
+}
+
+// Validate returns an error if cfg is invalid.
+func (cfg Config) Validate() error {
+	switch cfg.Period {
+	case "", PeriodDaily, PeriodMonthly:
+	default:
+		return fmt.Errorf("invalid quota period: %s", cfg.Period)
+	}
+	switch cfg.Action {
+	case "", ActionReject, ActionFallback:
+	case ActionThrottle:
+		if cfg.Throttle <= 0 {
+			return fmt.Errorf("invalid quota throttle: %d", cfg.Throttle)
+		}
+	default:
+		return fmt.Errorf("invalid quota action: %s", cfg.Action)
+	}
+	if cfg.Source < 0 || cfg.Outbound < 0 {
+		return fmt.Errorf("invalid quota: %d, %d", cfg.Source, cfg.Outbound)
+	}
+	return nil
+}
+
+func (cfg Config) period() string {
+	if cfg.Period == "" {
+		return PeriodDaily
+	}
+	return cfg.Period
+}
+
+func (cfg Config) action() string {
+	if cfg.Action == "" {
+		return ActionReject
+	}
+	return cfg.Action
+}
+
+// Usage is the traffic of a source or an outbound in the period.
+type Usage struct {
+	Used     int64 `json:"used"`
+	Quota    int64 `json:"quota"`
+	Exceeded bool  `json:"exceeded"`
+}
+
+// Status is the usages of the sources and outbounds in the period.
+type Status struct {
+	Period    string           `json:"period"`
+	Start     time.Time        `json:"start"`
+	End       time.Time        `json:"end"`
+	Action    string           `json:"action"`
+	Sources   map[string]Usage `json:"sources"`
+	Outbounds map[string]Usage `json:"outbounds"`
+}
+
+// State is the traffic counted in the period, which is saved to restore
+// the counting after restart.
+type State struct {
+	Period    string           `json:"period"`
+	Start     time.Time        `json:"start"`
+	Sources   map[string]int64 `json:"sources"`
+	Outbounds map[string]int64 `json:"outbounds"`
+}
+
+// Quota counts the traffic against the quotas of its Config.
+type Quota struct {
+	mu         sync.Mutex
+	cfg        Config
+	start, end time.Time
+	sources    map[netip.Addr]*entry
+	outbounds  map[string]*entry
+
+	// These mirror cfg and the period, which are read by the sessions
+	// without locking. gen is bumped with each period, of which the
+	// sessions look up their entries again.
+	enabled       atomic.Bool
+	throttling    atomic.Bool
+	sourceQuota   atomic.Int64
+	outboundQuota atomic.Int64
+	deadline      atomic.Time
+	gen           atomic.Uint64
+
+	now func() time.Time
+}
+
+// entry is the traffic of a source or an outbound in the period, and
+// the throttle of it while over quota with ActionThrottle.
+type entry struct {
+	used     atomic.Int64
+	throttle *rate.Limiter
+}
+
+// New returns a Quota without limits.
+func New() *Quota {
+	q := &Quota{now: time.Now}
+	q.reset(q.now())
+	return q
+}
+
+// Config returns the configuration of q.
+func (q *Quota) Config() Config {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.cfg
+}
+
+// Enabled returns whether there is any quota, without which the traffic
+// needn't be counted.
+func (q *Quota) Enabled() bool {
+	return q.enabled.Load()
+}
+
+// SetConfig sets the configuration of q, which applies to the sessions
+// established as well, though those established while it's disabled
+// aren't counted. The traffic is counted from zero if the period is
+// changed.
+func (q *Quota) SetConfig(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	periodChanged := cfg.period() != q.cfg.period()
+	q.cfg = cfg
+	q.enabled.Store(cfg.Source > 0 || cfg.Outbound > 0)
+	q.throttling.Store(cfg.action() == ActionThrottle)
+	q.sourceQuota.Store(cfg.Source)
+	q.outboundQuota.Store(cfg.Outbound)
+	if periodChanged {
+		q.reset(q.now())
+	}
+	for _, e := range q.sources {
+		ratelimit.SetBucket(e.throttle, cfg.Throttle)
+	}
+	for _, e := range q.outbounds {
+		ratelimit.SetBucket(e.throttle, cfg.Throttle)
+	}
+	return nil
+}
+
+// Exceeded returns whether either the source src or the outbound is
+// over its quota.
+func (q *Quota) Exceeded(src netip.Addr, outbound string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.roll()
+	return q.sourceExceeded(src.Unmap()) || q.outboundExceeded(outbound)
+}
+
+// OutboundExceeded returns whether the outbound is over its quota.
+func (q *Quota) OutboundExceeded(outbound string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.roll()
+	return q.outboundExceeded(outbound)
+}
+
+func (q *Quota) sourceExceeded(src netip.Addr) bool {
+	e := q.sources[src]
+	return q.cfg.Source > 0 && e != nil && e.used.Load() >= q.cfg.Source
+}
+
+func (q *Quota) outboundExceeded(outbound string) bool {
+	e := q.outbounds[outbound]
+	return q.cfg.Outbound > 0 && e != nil && e.used.Load() >= q.cfg.Outbound
+}
+
+// source returns the entry of the source src, which is created if
+// there is none.
+func (q *Quota) source(src netip.Addr) *entry {
+	e := q.sources[src]
+	if e == nil {
+		e = &entry{throttle: ratelimit.NewBucket(q.cfg.Throttle)}
+		q.sources[src] = e
+	}
+	return e
+}
+
+// outbound returns the entry of the outbound, which is created if
+// there is none.
+func (q *Quota) outbound(outbound string) *entry {
+	e := q.outbounds[outbound]
+	if e == nil {
+		e = &entry{throttle: ratelimit.NewBucket(q.cfg.Throttle)}
+		q.outbounds[outbound] = e
+	}
+	return e
+}
+
+// Status returns the usages of the sources and outbounds in the period.
+func (q *Quota) Status() Status {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.roll()
+	s := Status{
+		Period:    q.cfg.period(),
+		Start:     q.start,
+		End:       q.end,
+		Action:    q.cfg.action(),
+		Sources:   make(map[string]Usage, len(q.sources)),
+		Outbounds: make(map[string]Usage, len(q.outbounds)),
+	}
+	for src, e := range q.sources {
+		s.Sources[src.String()] = Usage{Used: e.used.Load(), Quota: q.cfg.Source, Exceeded: q.sourceExceeded(src)}
+	}
+	for outbound, e := range q.outbounds {
+		s.Outbounds[outbound] = Usage{Used: e.used.Load(), Quota: q.cfg.Outbound, Exceeded: q.outboundExceeded(outbound)}
+	}
+	return s
+}
+
+// State returns the traffic counted in the period.
+func (q *Quota) State() *State {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.roll()
+	s := &State{
+		Period:    q.cfg.period(),
+		Start:     q.start,
+		Sources:   make(map[string]int64, len(q.sources)),
+		Outbounds: make(map[string]int64, len(q.outbounds)),
+	}
+	for src, e := range q.sources {
+		s.Sources[src.String()] = e.used.Load()
+	}
+	for outbound, e := range q.outbounds {
+		s.Outbounds[outbound] = e.used.Load()
+	}
+	return s
+}
+
+// Restore restores the traffic counted in s, which is ignored if it's
+// not of the current period.
+func (q *Quota) Restore(s *State) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.roll()
+	if s.Period != q.cfg.period() || !s.Start.Equal(q.start) {
+		return
+	}
+	for k, used := range s.Sources {
+		if src, err := netip.ParseAddr(k); err == nil {
+			q.source(src).used.Store(used)
+		}
+	}
+	for outbound, used := range s.Outbounds {
+		q.outbound(outbound).used.Store(used)
+	}
+}
+
+// Reset counts the traffic of the period from zero.
+func (q *Quota) Reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reset(q.now())
+}
+
+// roll starts the next period if the current one is over.
+func (q *Quota) roll() {
+	if now := q.now(); !now.Before(q.end) {
+		q.reset(now)
+	}
+}
+
+func (q *Quota) reset(now time.Time) {
+	q.start, q.end = periodOf(q.cfg.period(), now)
+	q.sources = make(map[netip.Addr]*entry)
+	q.outbounds = make(map[string]*entry)
+	q.deadline.Store(q.end)
+	q.gen.Inc()
+}
+
+// periodOf returns the start and end of the period including t.
+func periodOf(period string, t time.Time) (start, end time.Time) {
+	y, m, d := t.Date()
+	if period == PeriodMonthly {
+		start = time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 1, 0)
+	}
+	start = time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	return start, start.AddDate(0, 0, 1)
+}
@@ -0,0 +1,100 @@
+package quota
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/ratelimit"
+)
+
+// upload counts n bytes uploaded by c, waiting for its throttles in
+// chunks as the connections do.
+func upload(t *testing.T, c *counter, n int) {
+	for n > 0 {
+		chunk := min(n, ratelimit.MinBurst)
+		require.NoError(t, c.Wait(context.Background(), chunk, true))
+		c.Done(chunk, true)
+		n -= chunk
+	}
+}
+
+func TestQuotaExceeded(t *testing.T) {
+	now := time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC)
+	q := New()
+	q.now = func() time.Time { return now }
+	assert.False(t, q.Enabled())
+	require.NoError(t, q.SetConfig(Config{Source: 1000, Outbound: 3000, Period: PeriodMonthly}))
+	assert.True(t, q.Enabled())
+
+	src1, src2 := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")
+	c := q.newCounter(src1, "socks5://a")
+	upload(t, c, 999)
+	assert.False(t, q.Exceeded(src1, "socks5://a"))
+
+	upload(t, c, 1)
+	assert.True(t, q.Exceeded(src1, "socks5://a"))
+	assert.False(t, q.Exceeded(src2, "socks5://a"))
+
+	// The outbound counts all of its sources.
+	upload(t, q.newCounter(src2, "socks5://a"), 2000)
+	assert.True(t, q.OutboundExceeded("socks5://a"))
+	assert.False(t, q.OutboundExceeded("socks5://b"))
+
+	status := q.Status()
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), status.Start)
+	assert.Equal(t, time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), status.End)
+	assert.Equal(t, ActionReject, status.Action)
+	assert.Equal(t, Usage{Used: 1000, Quota: 1000, Exceeded: true}, status.Sources["10.0.0.1"])
+	assert.Equal(t, Usage{Used: 3000, Quota: 3000, Exceeded: true}, status.Outbounds["socks5://a"])
+
+	// The state is restored within the period only.
+	state := q.State()
+	restored := New()
+	restored.now = q.now
+	require.NoError(t, restored.SetConfig(q.Config()))
+	restored.Restore(state)
+	assert.Equal(t, status, restored.Status())
+
+	// The traffic is counted from zero in the next period.
+	now = now.Add(time.Hour)
+	assert.False(t, q.Exceeded(src1, "socks5://a"))
+	assert.Empty(t, q.Status().Sources)
+
+	restored.Restore(state)
+	assert.Empty(t, restored.Status().Sources)
+}
+
+func TestQuotaThrottle(t *testing.T) {
+	q := New()
+	require.NoError(t, q.SetConfig(Config{Source: 1 << 20, Action: ActionThrottle, Throttle: 64 << 10}))
+
+	c := q.newCounter(netip.MustParseAddr("10.0.0.1"), "")
+
+	// Not throttled until it's over quota.
+	start := time.Now()
+	upload(t, c, 1<<20)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	start = time.Now()
+	upload(t, c, 96<<10)
+	assert.InDelta(t, 500*time.Millisecond, time.Since(start), float64(200*time.Millisecond))
+
+	// Resetting lifts the throttle.
+	q.Reset()
+	start = time.Now()
+	upload(t, c, 1<<20)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestConfigValidate(t *testing.T) {
+	assert.NoError(t, Config{}.Validate())
+	assert.Error(t, Config{Period: "weekly"}.Validate())
+	assert.Error(t, Config{Action: "drop"}.Validate())
+	assert.Error(t, Config{Action: ActionThrottle}.Validate())
+	assert.Error(t, Config{Source: -1}.Validate())
+}
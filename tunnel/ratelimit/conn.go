@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// Conn returns c limited by l.
+func Conn(c net.Conn, l Limiter) net.Conn {
+	return &conn{Conn: c, waiter: newWaiter(l)}
+}
+
+// PacketConn returns pc limited by l.
+func PacketConn(pc net.PacketConn, l Limiter) net.PacketConn {
+	return &packetConn{PacketConn: pc, waiter: newWaiter(l)}
+}
+
+// waiter waits for the Limiter of a connection until it's closed.
+type waiter struct {
+	limiter Limiter
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+}
+
+func newWaiter(l Limiter) *waiter {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &waiter{limiter: l, ctx: ctx, cancel: cancel}
+}
+
+// wait waits until n bytes can be uploaded, or downloaded, in chunks
+// no larger than MinBurst.
+func (w *waiter) wait(n int, upload bool) error {
+	for n > 0 {
+		chunk := min(n, MinBurst)
+		if err := w.limiter.Wait(w.ctx, chunk, upload); err != nil {
+			return net.ErrClosed
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// read counts and waits for n bytes downloaded, of which the error is
+// returned unless err is already set.
+func (w *waiter) read(n int, err error) error {
+	if n > 0 {
+		w.limiter.Done(n, false)
+		if werr := w.wait(n, false); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+func (w *waiter) close() {
+	w.closeOnce.Do(func() {
+		w.cancel()
+		w.limiter.Close()
+	})
+}
+
+type conn struct {
+	net.Conn
+	*waiter
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	return n, c.read(n, err)
+}
+
+func (c *conn) Write(b []byte) (n int, err error) {
+	for len(b) > 0 {
+		chunk := min(len(b), MinBurst)
+		if err = c.wait(chunk, true); err != nil {
+			return n, err
+		}
+		var nw int
+		nw, err = c.Conn.Write(b[:chunk])
+		c.limiter.Done(nw, true)
+		n += nw
+		if err != nil {
+			return n, err
+		}
+		b = b[chunk:]
+	}
+	return n, nil
+}
+
+func (c *conn) Close() error {
+	c.close()
+	return c.Conn.Close()
+}
+
+func (c *conn) CloseRead() error {
+	if cr, ok := c.Conn.(interface{ CloseRead() error }); ok {
+		return cr.CloseRead()
+	}
+	return errors.New("CloseRead is not implemented")
+}
+
+func (c *conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("CloseWrite is not implemented")
+}
+
+type packetConn struct {
+	net.PacketConn
+	*waiter
+}
+
+func (pc *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := pc.PacketConn.ReadFrom(b)
+	return n, addr, pc.read(n, err)
+}
+
+func (pc *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if err := pc.wait(len(b), true); err != nil {
+		return 0, err
+	}
+	n, err := pc.PacketConn.WriteTo(b, addr)
+	pc.limiter.Done(n, true)
+	return n, err
+}
+
+func (pc *packetConn) Close() error {
+	pc.close()
+	return pc.PacketConn.Close()
+}
@@ -0,0 +1,54 @@
+// Package ratelimit wraps connections of which the traffic is waited
+// for and counted by a Limiter, in chunks no larger than MinBurst.
+package ratelimit
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// MinBurst is the smallest burst of the buckets, which is also the
+// largest chunk of data waited for at once.
+const MinBurst = 16 << 10
+
+// Limiter waits for, and counts, the traffic of a connection, of
+// which writes are upload and reads are download.
+type Limiter interface {
+	// Wait waits until n bytes, no more than MinBurst, can be
+	// uploaded, or downloaded, or ctx is done.
+	Wait(ctx context.Context, n int, upload bool) error
+
+	// Done counts n bytes uploaded, or downloaded.
+	Done(n int, upload bool)
+
+	// Close releases the limiter of the connection closed.
+	Close()
+}
+
+// NewBucket returns a token bucket of r bytes per second, which is
+// unlimited if r isn't positive.
+func NewBucket(r int64) *rate.Limiter {
+	return rate.NewLimiter(limitOf(r), burstOf(r))
+}
+
+// SetBucket sets the rate of the bucket b to r bytes per second, which
+// is unlimited if r isn't positive.
+func SetBucket(b *rate.Limiter, r int64) {
+	b.SetLimit(limitOf(r))
+	b.SetBurst(burstOf(r))
+}
+
+// limitOf returns the limit of r bytes per second.
+func limitOf(r int64) rate.Limit {
+	if r <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(r)
+}
+
+// burstOf returns the burst of r bytes per second, which is a second of
+// it, but no less than MinBurst.
+func burstOf(r int64) int {
+	return int(max(r, MinBurst))
+}
@@ -0,0 +1,35 @@
+package shaper
+
+import (
+	"context"
+	"net/netip"
+)
+
+// limiter implements ratelimit.Limiter with the buckets of a
+// connection, i.e. its own, its source's, its outbound's and the
+// global one.
+type limiter struct {
+	shaper   *Shaper
+	src      netip.Addr
+	outbound string
+	buckets  [4]*bucket
+}
+
+func (l *limiter) Wait(ctx context.Context, n int, upload bool) error {
+	for _, b := range l.buckets {
+		r := b.down
+		if upload {
+			r = b.up
+		}
+		if err := r.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (*limiter) Done(int, bool) {}
+
+func (l *limiter) Close() {
+	l.shaper.release(l.src, l.outbound, l.buckets[0])
+}
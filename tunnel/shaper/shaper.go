@@ -0,0 +1,165 @@
+// Package shaper limits the bandwidth of connections with token buckets,
+// of all of them in total, of those by each source, of those through each
+// outbound and of each one, with separate rates of upload and download.
+package shaper
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/ratelimit"
+)
+
+// Limit is the rates of upload and download in bytes per second, each
+// of which is unlimited if zero.
+type Limit struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+}
+
+// Limits are the Limit of all the connections in total, of those of
+// each source address, of those through each outbound proxy, and of
+// each connection.
+type Limits struct {
+	Global     Limit `json:"global"`
+	Source     Limit `json:"source"`
+	Outbound   Limit `json:"outbound"`
+	Connection Limit `json:"connection"`
+}
+
+// bucket is the token buckets of upload and download.
+type bucket struct {
+	up, down *rate.Limiter
+}
+
+func newBucket(l Limit) *bucket {
+	return &bucket{
+		up:   ratelimit.NewBucket(l.Upload),
+		down: ratelimit.NewBucket(l.Download),
+	}
+}
+
+func (b *bucket) set(l Limit) {
+	ratelimit.SetBucket(b.up, l.Upload)
+	ratelimit.SetBucket(b.down, l.Download)
+}
+
+// sharedBucket is the bucket of a source, or an outbound, which is
+// shared by its connections.
+type sharedBucket struct {
+	*bucket
+	refs int
+}
+
+// acquire returns the bucket of k in m, which is created with l if
+// there is none.
+func acquire[K comparable](m map[K]*sharedBucket, k K, l Limit) *bucket {
+	sb := m[k]
+	if sb == nil {
+		sb = &sharedBucket{bucket: newBucket(l)}
+		m[k] = sb
+	}
+	sb.refs++
+	return sb.bucket
+}
+
+// release releases the bucket of k in m, which goes with its last
+// connection.
+func release[K comparable](m map[K]*sharedBucket, k K) {
+	if sb := m[k]; sb != nil {
+		if sb.refs--; sb.refs <= 0 {
+			delete(m, k)
+		}
+	}
+}
+
+// Shaper shapes the traffic of the connections wrapped by it. The
+// limits can be changed at any time, which applies to the connections
+// established as well.
+type Shaper struct {
+	mu        sync.Mutex
+	limits    Limits
+	global    *bucket
+	sources   map[netip.Addr]*sharedBucket
+	outbounds map[string]*sharedBucket
+	conns     map[*bucket]struct{}
+}
+
+// New returns a Shaper without limits.
+func New() *Shaper {
+	return &Shaper{
+		global:    newBucket(Limit{}),
+		sources:   make(map[netip.Addr]*sharedBucket),
+		outbounds: make(map[string]*sharedBucket),
+		conns:     make(map[*bucket]struct{}),
+	}
+}
+
+// Limits returns the limits of s.
+func (s *Shaper) Limits() Limits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limits
+}
+
+// SetLimits sets the limits of s.
+func (s *Shaper) SetLimits(l Limits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limits = l
+	s.global.set(l.Global)
+	for _, b := range s.sources {
+		b.set(l.Source)
+	}
+	for _, b := range s.outbounds {
+		b.set(l.Outbound)
+	}
+	for b := range s.conns {
+		b.set(l.Connection)
+	}
+}
+
+// Conn returns c shaped as a connection of src through outbound, in
+// which writes are upload and reads are download.
+func (s *Shaper) Conn(c net.Conn, src netip.Addr, outbound string) net.Conn {
+	return ratelimit.Conn(c, s.newLimiter(src, outbound))
+}
+
+// PacketConn returns pc shaped as a connection of src through outbound,
+// in which writes are upload and reads are download.
+func (s *Shaper) PacketConn(pc net.PacketConn, src netip.Addr, outbound string) net.PacketConn {
+	return ratelimit.PacketConn(pc, s.newLimiter(src, outbound))
+}
+
+func (s *Shaper) newLimiter(src netip.Addr, outbound string) *limiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src = src.Unmap()
+	sb := acquire(s.sources, src, s.limits.Source)
+	ob := acquire(s.outbounds, outbound, s.limits.Outbound)
+
+	cb := newBucket(s.limits.Connection)
+	s.conns[cb] = struct{}{}
+
+	return &limiter{
+		shaper:   s,
+		src:      src,
+		outbound: outbound,
+		buckets:  [...]*bucket{cb, sb, ob, s.global},
+	}
+}
+
+// release releases the buckets of a connection closed.
+func (s *Shaper) release(src netip.Addr, outbound string, cb *bucket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, cb)
+	release(s.sources, src)
+	release(s.outbounds, outbound)
+}
@@ -0,0 +1,124 @@
+package shaper
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/ratelimit"
+)
+
+// discardConn is a net.Conn writing to nowhere.
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(b []byte) (int, error) { return len(b), nil }
+func (discardConn) Close() error                { return nil }
+
+func TestShaperUpload(t *testing.T) {
+	s := New()
+	s.SetLimits(Limits{Connection: Limit{Upload: 64 << 10}})
+
+	c := s.Conn(discardConn{}, netip.MustParseAddr("10.0.0.1"), "direct")
+	defer c.Close()
+
+	// The burst of a second goes at once, and the rest at the rate.
+	start := time.Now()
+	n, err := c.Write(make([]byte, 96<<10))
+	require.NoError(t, err)
+	assert.Equal(t, 96<<10, n)
+	assert.InDelta(t, 500*time.Millisecond, time.Since(start), float64(200*time.Millisecond))
+}
+
+func TestShaperDownload(t *testing.T) {
+	s := New()
+	s.SetLimits(Limits{Global: Limit{Download: 1 << 30}, Source: Limit{Download: 32 << 10}})
+
+	// The connections of a source share its bucket.
+	src := netip.MustParseAddr("10.0.0.1")
+	var conns []net.Conn
+	for range 2 {
+		local, remote := net.Pipe()
+		conns = append(conns, s.Conn(local, src, "direct"))
+		go func() {
+			remote.Write(make([]byte, 24<<10))
+			remote.Close()
+		}()
+	}
+	start := time.Now()
+	for _, c := range conns {
+		_, err := io.Copy(io.Discard, c)
+		require.NoError(t, err)
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+	assert.InDelta(t, 500*time.Millisecond, time.Since(start), float64(200*time.Millisecond))
+
+	// The bucket of a source goes with its last connection.
+	assert.Empty(t, s.sources)
+	assert.Empty(t, s.outbounds)
+	assert.Empty(t, s.conns)
+}
+
+func TestShaperOutbound(t *testing.T) {
+	s := New()
+	s.SetLimits(Limits{Outbound: Limit{Upload: 32 << 10}})
+
+	// The connections through an outbound share its bucket, whatever
+	// their sources, but not with those through the others.
+	a := s.Conn(discardConn{}, netip.MustParseAddr("10.0.0.1"), "socks5")
+	defer a.Close()
+	b := s.Conn(discardConn{}, netip.MustParseAddr("10.0.0.2"), "socks5")
+	defer b.Close()
+	c := s.Conn(discardConn{}, netip.MustParseAddr("10.0.0.1"), "direct")
+	defer c.Close()
+
+	start := time.Now()
+	for _, c := range []net.Conn{a, b} {
+		_, err := c.Write(make([]byte, 24<<10))
+		require.NoError(t, err)
+	}
+	assert.InDelta(t, 500*time.Millisecond, time.Since(start), float64(200*time.Millisecond))
+
+	start = time.Now()
+	_, err := c.Write(make([]byte, 32<<10))
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestShaperSetLimits(t *testing.T) {
+	s := New()
+	s.SetLimits(Limits{Global: Limit{Upload: 1}})
+
+	c := s.Conn(discardConn{}, netip.MustParseAddr("10.0.0.1"), "direct")
+	_, err := c.Write(make([]byte, ratelimit.MinBurst))
+	require.NoError(t, err)
+
+	// Closing the connection stops waiting.
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c.Write(make([]byte, ratelimit.MinBurst))
+		errCh <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	c.Close()
+	assert.ErrorIs(t, <-errCh, net.ErrClosed)
+
+	// Lifting the limits applies to the connections established.
+	c = s.Conn(discardConn{}, netip.MustParseAddr("10.0.0.1"), "direct")
+	defer c.Close()
+	s.SetLimits(Limits{})
+	assert.Equal(t, Limits{}, s.Limits())
+
+	start := time.Now()
+	_, err = c.Write(make([]byte, 1<<20))
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
@@ -0,0 +1,198 @@
+package statistic
+
+import (
+	"cmp"
+	"container/list"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.uber.org/atomic"
+
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+)
+
+// maxAccounts is the number of the accounts kept by each table, beyond
+// which the least recently used ones are evicted.
+const maxAccounts = 4096
+
+// Sort orders of accounts.
+const (
+	SortByTotal       = "total"
+	SortByUpload      = "upload"
+	SortByDownload    = "download"
+	SortByConnections = "connections"
+)
+
+// Account is the cumulative traffic of a source address, or of a
+// destination address or host.
+type Account struct {
+	Address     string `json:"address"`
+	Upload      int64  `json:"upload"`
+	Download    int64  `json:"download"`
+	Connections int64  `json:"connections"`
+}
+
+// Accounts is the cumulative traffic by source and by destination.
+type Accounts struct {
+	Sources      []Account `json:"sources"`
+	Destinations []Account `json:"destinations"`
+}
+
+type account struct {
+	address     string
+	upload      *atomic.Int64
+	download    *atomic.Int64
+	connections *atomic.Int64
+}
+
+func newAccount(address string) *account {
+	return &account{
+		address:     address,
+		upload:      atomic.NewInt64(0),
+		download:    atomic.NewInt64(0),
+		connections: atomic.NewInt64(0),
+	}
+}
+
+// accountTable is the accounts keyed by address, of which at most
+// maxAccounts are kept. Trackers keep adding to the accounts evicted,
+// which are gone.
+type accountTable struct {
+	mu  sync.Mutex
+	m   map[string]*list.Element
+	lru list.List // of *account, the most recently used first
+}
+
+func (t *accountTable) get(address string) *account {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e := t.m[address]; e != nil {
+		t.lru.MoveToFront(e)
+		return e.Value.(*account)
+	}
+	a := newAccount(address)
+	t.add(a)
+	return a
+}
+
+// add adds a as the most recently used account, evicting the least
+// recently used one if the table is full.
+func (t *accountTable) add(a *account) {
+	if t.m == nil {
+		t.m = make(map[string]*list.Element)
+	}
+	if t.lru.Len() >= maxAccounts {
+		oldest := t.lru.Back()
+		t.lru.Remove(oldest)
+		delete(t.m, oldest.Value.(*account).address)
+	}
+	t.m[a.address] = t.lru.PushFront(a)
+}
+
+// list returns the accounts, the most recently used first.
+func (t *accountTable) list() []Account {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	accounts := make([]Account, 0, t.lru.Len())
+	for e := t.lru.Front(); e != nil; e = e.Next() {
+		a := e.Value.(*account)
+		accounts = append(accounts, Account{
+			Address:     a.address,
+			Upload:      a.upload.Load(),
+			Download:    a.download.Load(),
+			Connections: a.connections.Load(),
+		})
+	}
+	return accounts
+}
+
+// restore replaces the accounts with accounts, the most recently used
+// first as listed. Trackers keep adding to the replaced ones, which are
+// gone.
+func (t *accountTable) restore(accounts []Account) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.m = nil
+	t.lru.Init()
+	for _, v := range slices.Backward(accounts) {
+		a := newAccount(v.Address)
+		a.upload.Store(v.Upload)
+		a.download.Store(v.Download)
+		a.connections.Store(v.Connections)
+		t.add(a)
+	}
+}
+
+// accountsOf returns the accounts of the source and destination of
+// a new connection of metadata, of which the destination is the host
+// if it's known.
+func (m *Manager) accountsOf(metadata *M.Metadata) (src, dst *account) {
+	dstAddress := metadata.Host
+	if dstAddress == "" {
+		dstAddress = metadata.DstIP.String()
+	}
+	src = m.sources.get(metadata.SrcIP.String())
+	dst = m.destinations.get(dstAddress)
+	src.connections.Inc()
+	dst.connections.Inc()
+	return src, dst
+}
+
+// TopSources returns the top n sources sorted by, one of the sort orders,
+// or all of them if n is not positive.
+func (m *Manager) TopSources(n int, by string) ([]Account, error) {
+	return top(m.sources.list(), n, by)
+}
+
+// TopDestinations returns the top n destinations sorted by, one of the
+// sort orders, or all of them if n is not positive.
+func (m *Manager) TopDestinations(n int, by string) ([]Account, error) {
+	return top(m.destinations.list(), n, by)
+}
+
+func top(accounts []Account, n int, by string) ([]Account, error) {
+	var key func(Account) int64
+	switch by {
+	case SortByTotal, "":
+		key = func(a Account) int64 { return a.Upload + a.Download }
+	case SortByUpload:
+		key = func(a Account) int64 { return a.Upload }
+	case SortByDownload:
+		key = func(a Account) int64 { return a.Download }
+	case SortByConnections:
+		key = func(a Account) int64 { return a.Connections }
+	default:
+		return nil, fmt.Errorf("invalid sort order: %s", by)
+	}
+
+	slices.SortFunc(accounts, func(a, b Account) int {
+		return cmp.Or(cmp.Compare(key(b), key(a)), strings.Compare(a.Address, b.Address))
+	})
+	if n > 0 && n < len(accounts) {
+		accounts = accounts[:n]
+	}
+	return accounts, nil
+}
+
+// Accounts returns all the accounts, to be restored later.
+func (m *Manager) Accounts() *Accounts {
+	return &Accounts{
+		Sources:      m.sources.list(),
+		Destinations: m.destinations.list(),
+	}
+}
+
+// RestoreAccounts replaces all the accounts with a, or clears them if
+// a is nil.
+func (m *Manager) RestoreAccounts(a *Accounts) {
+	if a == nil {
+		a = &Accounts{}
+	}
+	m.sources.restore(a.Sources)
+	m.destinations.restore(a.Destinations)
+}
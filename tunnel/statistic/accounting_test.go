@@ -0,0 +1,99 @@
+package statistic
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+)
+
+func TestAccounting(t *testing.T) {
+	m := NewManager()
+	defer m.Close()
+
+	transfer := func(src, dst string, up int) {
+		c, remote := net.Pipe()
+		defer remote.Close()
+		go remote.Read(make([]byte, up))
+
+		tt := NewTCPTracker(c, &M.Metadata{
+			Network: M.TCP,
+			SrcIP:   netip.MustParseAddr(src),
+			DstIP:   netip.MustParseAddr(dst),
+		}, m)
+		defer tt.Close()
+		_, err := tt.Write(make([]byte, up))
+		require.NoError(t, err)
+	}
+	transfer("10.0.0.2", "203.0.113.1", 100)
+	transfer("10.0.0.2", "203.0.113.2", 300)
+	transfer("10.0.0.3", "203.0.113.2", 50)
+
+	sources, err := m.TopSources(0, SortByUpload)
+	require.NoError(t, err)
+	assert.Equal(t, []Account{
+		{Address: "10.0.0.2", Upload: 400, Connections: 2},
+		{Address: "10.0.0.3", Upload: 50, Connections: 1},
+	}, sources)
+
+	destinations, err := m.TopDestinations(1, SortByConnections)
+	require.NoError(t, err)
+	assert.Equal(t, []Account{{Address: "203.0.113.2", Upload: 350, Connections: 2}}, destinations)
+
+	_, err = m.TopSources(0, "invalid")
+	assert.Error(t, err)
+
+	// Accounts survive restoring them to another manager.
+	restored := NewManager()
+	defer restored.Close()
+	restored.RestoreAccounts(m.Accounts())
+	got, _ := restored.TopSources(0, SortByTotal)
+	assert.Equal(t, sources, got)
+
+	m.RestoreAccounts(nil)
+	got, _ = m.TopSources(0, SortByTotal)
+	assert.Empty(t, got)
+}
+
+func TestAccountTable(t *testing.T) {
+	var table accountTable
+	for i := range maxAccounts {
+		table.get(strconv.Itoa(i))
+	}
+	// The least recently used account is evicted when it's full.
+	table.get("0")
+	table.get("new")
+
+	accounts := table.list()
+	require.Len(t, accounts, maxAccounts)
+	assert.Equal(t, "new", accounts[0].Address)
+	assert.Equal(t, "0", accounts[1].Address)
+	assert.NotContains(t, table.m, "1")
+
+	// The order of use survives restoring.
+	var restored accountTable
+	restored.restore(accounts)
+	assert.Equal(t, accounts, restored.list())
+}
+
+func TestAccountHost(t *testing.T) {
+	m := NewManager()
+	defer m.Close()
+
+	// Destinations are accounted by host if it's known.
+	m.accountsOf(&M.Metadata{SrcIP: netip.MustParseAddr("10.0.0.2"), DstIP: netip.MustParseAddr("203.0.113.1"), Host: "example.com"})
+	m.accountsOf(&M.Metadata{SrcIP: netip.MustParseAddr("10.0.0.2"), DstIP: netip.MustParseAddr("203.0.113.2"), Host: "example.com"})
+	m.accountsOf(&M.Metadata{SrcIP: netip.MustParseAddr("10.0.0.2"), DstIP: netip.MustParseAddr("203.0.113.3")})
+
+	destinations, err := m.TopDestinations(0, SortByConnections)
+	require.NoError(t, err)
+	assert.Equal(t, []Account{
+		{Address: "example.com", Connections: 2},
+		{Address: "203.0.113.3", Connections: 1},
+	}, destinations)
+}
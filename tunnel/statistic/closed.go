@@ -0,0 +1,131 @@
+package statistic
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+)
+
+// closedHistorySize is the number of the recently closed connections
+// kept by a Manager.
+const closedHistorySize = 512
+
+// Reasons why connections are closed.
+const (
+	// ReasonClosed is of connections closed locally without errors,
+	// e.g. on shutdown.
+	ReasonClosed = "closed"
+	// ReasonEOF is of connections closed by either end.
+	ReasonEOF = "eof"
+	// ReasonReset is of connections reset by either end.
+	ReasonReset = "reset"
+	// ReasonTimeout is of connections timed out, e.g. UDP sessions
+	// being idle for the timeout.
+	ReasonTimeout = "timeout"
+	// ReasonKilled is of connections closed via the REST API.
+	ReasonKilled = "killed"
+	// ReasonDialError is of connections failed to dial the proxy.
+	ReasonDialError = "dial error"
+	// ReasonError is of connections closed on other errors.
+	ReasonError = "error"
+)
+
+// ClosedConnection is the record of a closed connection.
+type ClosedConnection struct {
+	ID       string      `json:"id"`
+	Metadata *M.Metadata `json:"metadata"`
+	Start    time.Time   `json:"start"`
+	End      time.Time   `json:"end"`
+	// Duration is in milliseconds.
+	Duration int64  `json:"duration"`
+	Upload   int64  `json:"upload"`
+	Download int64  `json:"download"`
+	Reason   string `json:"reason"`
+	Error    string `json:"error,omitempty"`
+}
+
+// closeReason returns the reason of closing a connection on err.
+func closeReason(err error) string {
+	var ne net.Error
+	switch {
+	case errors.Is(err, io.EOF):
+		return ReasonEOF
+	case errors.Is(err, syscall.ECONNRESET):
+		return ReasonReset
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return ReasonTimeout
+	default:
+		return ReasonError
+	}
+}
+
+// closedHistory is a ring buffer of the recently closed connections.
+type closedHistory struct {
+	mu  sync.Mutex
+	buf []ClosedConnection
+	// seq is the number of connections pushed in total, whose
+	// remainder of the size is the next slot of buf.
+	seq uint64
+
+	// handler is called with each connection pushed.
+	handler func(ClosedConnection)
+}
+
+func (h *closedHistory) push(c ClosedConnection) {
+	h.mu.Lock()
+	if len(h.buf) < closedHistorySize {
+		h.buf = append(h.buf, c)
+	} else {
+		h.buf[h.seq%closedHistorySize] = c
+	}
+	h.seq++
+	handler := h.handler
+	h.mu.Unlock()
+
+	if handler != nil {
+		handler(c)
+	}
+}
+
+// since returns the connections pushed after seq, which are still kept,
+// from the oldest, and the sequence number of the last one.
+func (h *closedHistory) since(seq uint64) ([]ClosedConnection, uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	first := h.seq - uint64(len(h.buf))
+	seq = max(seq, first)
+
+	closed := make([]ClosedConnection, 0, h.seq-seq)
+	for i := seq; i < h.seq; i++ {
+		closed = append(closed, h.buf[i%closedHistorySize])
+	}
+	return closed, h.seq
+}
+
+// SetClosedHandler sets f to be called with each connection closed,
+// or failed to dial. It's called on closing, and should not block.
+func (m *Manager) SetClosedHandler(f func(ClosedConnection)) {
+	m.closed.mu.Lock()
+	m.closed.handler = f
+	m.closed.mu.Unlock()
+}
+
+// Closed returns the recently closed connections, from the oldest.
+func (m *Manager) Closed() []ClosedConnection {
+	closed, _ := m.closed.since(0)
+	return closed
+}
+
+// ClosedSince returns the connections closed after the sequence number
+// seq, which are still kept, and the sequence number to get the next
+// ones with. The first call should be with zero.
+func (m *Manager) ClosedSince(seq uint64) ([]ClosedConnection, uint64) {
+	return m.closed.since(seq)
+}
@@ -0,0 +1,65 @@
+package statistic
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+)
+
+func TestClosedHistory(t *testing.T) {
+	h := &closedHistory{}
+	for i := range closedHistorySize + 10 {
+		h.push(ClosedConnection{ID: strconv.Itoa(i)})
+	}
+
+	closed, seq := h.since(0)
+	require.Len(t, closed, closedHistorySize)
+	assert.Equal(t, "10", closed[0].ID)
+	assert.Equal(t, strconv.Itoa(closedHistorySize+9), closed[len(closed)-1].ID)
+
+	h.push(ClosedConnection{ID: "next"})
+	closed, _ = h.since(seq)
+	require.Len(t, closed, 1)
+	assert.Equal(t, "next", closed[0].ID)
+}
+
+func TestCloseReason(t *testing.T) {
+	m := NewManager()
+	defer m.Close()
+	metadata := &M.Metadata{Network: M.TCP}
+
+	// Closed by the remote end.
+	c, remote := net.Pipe()
+	tt := NewTCPTracker(c, metadata, m)
+	remote.Close()
+	_, err := tt.Read(make([]byte, 1))
+	require.Error(t, err)
+	tt.Close()
+
+	// Killed while timing out.
+	c, remote = net.Pipe()
+	defer remote.Close()
+	tt = NewTCPTracker(c, metadata, m)
+	tt.SetReadDeadline(time.Now())
+	_, err = tt.Read(make([]byte, 1))
+	require.Error(t, err)
+	tt.(tracker).Kill()
+
+	m.ObserveDial(metadata, time.Second, errors.New("refused"))
+
+	closed := m.Closed()
+	require.Len(t, closed, 3)
+	assert.Equal(t, ReasonEOF, closed[0].Reason)
+	assert.Empty(t, closed[0].Error)
+	assert.Equal(t, ReasonKilled, closed[1].Reason)
+	assert.Equal(t, ReasonDialError, closed[2].Reason)
+	assert.Equal(t, "refused", closed[2].Error)
+	assert.Empty(t, m.Snapshot().Connections)
+}
@@ -0,0 +1,108 @@
+package statistic
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+)
+
+// DialBuckets are the upper bounds of the buckets of dial latencies.
+var DialBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// DialStats is the statistics of dials through the proxy of a network.
+type DialStats struct {
+	Network string
+
+	// Buckets are the cumulative counts of successful dials within
+	// each of DialBuckets.
+	Buckets []uint64
+
+	// Count and Sum are the count and total latency of successful
+	// dials, and Errors is the count of failed ones.
+	Count  uint64
+	Sum    time.Duration
+	Errors uint64
+}
+
+type dialStats struct {
+	mu    sync.Mutex
+	stats map[string]*DialStats
+}
+
+// ObserveDial records a dial of the connection of metadata through the
+// proxy, which took d, and failed if err is not nil. Failed dials are
+// recorded as closed connections as well.
+func (m *Manager) ObserveDial(metadata *M.Metadata, d time.Duration, err error) {
+	if err != nil {
+		id, _ := uuid.NewRandom()
+		end := time.Now()
+		m.closed.push(ClosedConnection{
+			ID:       id.String(),
+			Metadata: metadata,
+			Start:    end.Add(-d),
+			End:      end,
+			Duration: d.Milliseconds(),
+			Reason:   ReasonDialError,
+			Error:    err.Error(),
+		})
+	}
+
+	network := metadata.Network.String()
+	m.dials.mu.Lock()
+	defer m.dials.mu.Unlock()
+
+	if m.dials.stats == nil {
+		m.dials.stats = make(map[string]*DialStats)
+	}
+	s := m.dials.stats[network]
+	if s == nil {
+		s = &DialStats{Network: network, Buckets: make([]uint64, len(DialBuckets))}
+		m.dials.stats[network] = s
+	}
+
+	if err != nil {
+		s.Errors++
+		return
+	}
+	for i, le := range DialBuckets {
+		if d <= le {
+			s.Buckets[i]++
+		}
+	}
+	s.Count++
+	s.Sum += d
+}
+
+// DialStats returns the dial statistics of each network, sorted by
+// network.
+func (m *Manager) DialStats() []DialStats {
+	m.dials.mu.Lock()
+	defer m.dials.mu.Unlock()
+
+	stats := make([]DialStats, 0, len(m.dials.stats))
+	for _, s := range m.dials.stats {
+		c := *s
+		c.Buckets = slices.Clone(s.Buckets)
+		stats = append(stats, c)
+	}
+	slices.SortFunc(stats, func(a, b DialStats) int {
+		return strings.Compare(a.Network, b.Network)
+	})
+	return stats
+}
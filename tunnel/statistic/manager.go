@@ -10,15 +10,7 @@ import (
 var DefaultManager *Manager
 
 func init() {
-	DefaultManager = &Manager{
-		uploadTemp:    atomic.NewInt64(0),
-		downloadTemp:  atomic.NewInt64(0),
-		uploadBlip:    atomic.NewInt64(0),
-		downloadBlip:  atomic.NewInt64(0),
-		uploadTotal:   atomic.NewInt64(0),
-		downloadTotal: atomic.NewInt64(0),
-	}
-	go DefaultManager.handle()
+	DefaultManager = NewManager()
 }
 
 type Manager struct {
@@ -29,14 +21,51 @@ type Manager struct {
 	downloadBlip  *atomic.Int64
 	uploadTotal   *atomic.Int64
 	downloadTotal *atomic.Int64
+
+	dials  dialStats
+	closed closedHistory
+
+	// sources and destinations are the accounts by address.
+	sources      accountTable
+	destinations accountTable
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewManager returns a new Manager, which should be closed
+// when it's no longer used.
+func NewManager() *Manager {
+	m := &Manager{
+		uploadTemp:    atomic.NewInt64(0),
+		downloadTemp:  atomic.NewInt64(0),
+		uploadBlip:    atomic.NewInt64(0),
+		downloadBlip:  atomic.NewInt64(0),
+		uploadTotal:   atomic.NewInt64(0),
+		downloadTotal: atomic.NewInt64(0),
+		done:          make(chan struct{}),
+	}
+	go m.handle()
+	return m
+}
+
+// Close stops updating the traffic rates of m.
+func (m *Manager) Close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+	})
 }
 
 func (m *Manager) Join(c tracker) {
 	m.connections.Store(c.ID(), c)
 }
 
+// Leave removes c from the active connections, and records it as
+// closed, if it has not left yet.
 func (m *Manager) Leave(c tracker) {
-	m.connections.Delete(c.ID())
+	if _, ok := m.connections.LoadAndDelete(c.ID()); ok {
+		m.closed.push(c.record())
+	}
 }
 
 func (m *Manager) PushUploaded(size int64) {
@@ -49,6 +78,11 @@ func (m *Manager) PushDownloaded(size int64) {
 	m.downloadTotal.Add(size)
 }
 
+// Total returns the bytes uploaded and downloaded in total.
+func (m *Manager) Total() (up int64, down int64) {
+	return m.uploadTotal.Load(), m.downloadTotal.Load()
+}
+
 func (m *Manager) Now() (up int64, down int64) {
 	return m.uploadBlip.Load(), m.downloadBlip.Load()
 }
@@ -78,12 +112,18 @@ func (m *Manager) ResetStatistic() {
 
 func (m *Manager) handle() {
 	ticker := time.NewTicker(time.Second)
-
-	for range ticker.C {
-		m.uploadBlip.Store(m.uploadTemp.Load())
-		m.uploadTemp.Store(0)
-		m.downloadBlip.Store(m.downloadTemp.Load())
-		m.downloadTemp.Store(0)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.uploadBlip.Store(m.uploadTemp.Load())
+			m.uploadTemp.Store(0)
+			m.downloadBlip.Store(m.downloadTemp.Load())
+			m.downloadTemp.Store(0)
+		case <-m.done:
+			return
+		}
 	}
 }
 
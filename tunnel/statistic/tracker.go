@@ -3,6 +3,7 @@ package statistic
 import (
 	"errors"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,15 +14,91 @@ import (
 
 type tracker interface {
 	ID() string
+	Metadata() *M.Metadata
 	Close() error
+
+	// Kill closes the connection, for the reason of being killed.
+	Kill() error
+
+	record() ClosedConnection
 }
 
 type trackerInfo struct {
 	Start         time.Time     `json:"start"`
 	UUID          uuid.UUID     `json:"id"`
-	Metadata      *M.Metadata   `json:"metadata"`
+	Meta          *M.Metadata   `json:"metadata"`
 	UploadTotal   *atomic.Int64 `json:"upload"`
 	DownloadTotal *atomic.Int64 `json:"download"`
+
+	// src and dst are the accounts the traffic is added to.
+	src, dst *account
+
+	// reason and err are why the connection is closed, which
+	// are of the first error seen.
+	mu     sync.Mutex
+	reason string
+	err    error
+}
+
+func (ti *trackerInfo) Metadata() *M.Metadata {
+	return ti.Meta
+}
+
+func (ti *trackerInfo) addUploaded(n int64) {
+	ti.UploadTotal.Add(n)
+	ti.src.upload.Add(n)
+	ti.dst.upload.Add(n)
+}
+
+func (ti *trackerInfo) addDownloaded(n int64) {
+	ti.DownloadTotal.Add(n)
+	ti.src.download.Add(n)
+	ti.dst.download.Add(n)
+}
+
+// observe records err as why the connection is closed, unless
+// there has been one.
+func (ti *trackerInfo) observe(err error) {
+	if err == nil {
+		return
+	}
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	if ti.reason == "" {
+		ti.reason, ti.err = closeReason(err), err
+	}
+}
+
+func (ti *trackerInfo) kill() {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	ti.reason, ti.err = ReasonKilled, nil
+}
+
+func (ti *trackerInfo) record() ClosedConnection {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	c := ClosedConnection{
+		ID:       ti.UUID.String(),
+		Metadata: ti.Meta,
+		Start:    ti.Start,
+		End:      time.Now(),
+		Upload:   ti.UploadTotal.Load(),
+		Download: ti.DownloadTotal.Load(),
+		Reason:   ti.reason,
+	}
+	c.Duration = c.End.Sub(c.Start).Milliseconds()
+	if c.Reason == "" {
+		c.Reason = ReasonClosed
+	}
+	// EOF is not an error worth reporting.
+	if ti.err != nil && c.Reason != ReasonEOF {
+		c.Error = ti.err.Error()
+	}
+	return c
 }
 
 type tcpTracker struct {
@@ -40,12 +117,13 @@ func NewTCPTracker(conn net.Conn, metadata *M.Metadata, manager *Manager) net.Co
 		trackerInfo: &trackerInfo{
 			UUID:          id,
 			Start:         time.Now(),
-			Metadata:      metadata,
+			Meta:          metadata,
 			UploadTotal:   atomic.NewInt64(0),
 			DownloadTotal: atomic.NewInt64(0),
 		},
 	}
 
+	tt.src, tt.dst = manager.accountsOf(metadata)
 	manager.Join(tt)
 	return tt
 }
@@ -58,7 +136,8 @@ func (tt *tcpTracker) Read(b []byte) (int, error) {
 	n, err := tt.Conn.Read(b)
 	download := int64(n)
 	tt.manager.PushDownloaded(download)
-	tt.DownloadTotal.Add(download)
+	tt.addDownloaded(download)
+	tt.observe(err)
 	return n, err
 }
 
@@ -66,7 +145,8 @@ func (tt *tcpTracker) Write(b []byte) (int, error) {
 	n, err := tt.Conn.Write(b)
 	upload := int64(n)
 	tt.manager.PushUploaded(upload)
-	tt.UploadTotal.Add(upload)
+	tt.addUploaded(upload)
+	tt.observe(err)
 	return n, err
 }
 
@@ -75,6 +155,11 @@ func (tt *tcpTracker) Close() error {
 	return tt.Conn.Close()
 }
 
+func (tt *tcpTracker) Kill() error {
+	tt.kill()
+	return tt.Close()
+}
+
 func (tt *tcpTracker) CloseRead() error {
 	if cr, ok := tt.Conn.(interface{ CloseRead() error }); ok {
 		return cr.CloseRead()
@@ -105,12 +190,13 @@ func NewUDPTracker(conn net.PacketConn, metadata *M.Metadata, manager *Manager)
 		trackerInfo: &trackerInfo{
 			UUID:          id,
 			Start:         time.Now(),
-			Metadata:      metadata,
+			Meta:          metadata,
 			UploadTotal:   atomic.NewInt64(0),
 			DownloadTotal: atomic.NewInt64(0),
 		},
 	}
 
+	ut.src, ut.dst = manager.accountsOf(metadata)
 	manager.Join(ut)
 	return ut
 }
@@ -123,7 +209,8 @@ func (ut *udpTracker) ReadFrom(b []byte) (int, net.Addr, error) {
 	n, addr, err := ut.PacketConn.ReadFrom(b)
 	download := int64(n)
 	ut.manager.PushDownloaded(download)
-	ut.DownloadTotal.Add(download)
+	ut.addDownloaded(download)
+	ut.observe(err)
 	return n, addr, err
 }
 
@@ -131,7 +218,8 @@ func (ut *udpTracker) WriteTo(b []byte, addr net.Addr) (int, error) {
 	n, err := ut.PacketConn.WriteTo(b, addr)
 	upload := int64(n)
 	ut.manager.PushUploaded(upload)
-	ut.UploadTotal.Add(upload)
+	ut.addUploaded(upload)
+	ut.observe(err)
 	return n, err
 }
 
@@ -139,3 +227,8 @@ func (ut *udpTracker) Close() error {
 	ut.manager.Leave(ut)
 	return ut.PacketConn.Close()
 }
+
+func (ut *udpTracker) Kill() error {
+	ut.kill()
+	return ut.Close()
+}
@@ -19,6 +19,7 @@ func (t *Tunnel) handleTCPConn(originConn adapter.TCPConn) {
 
 	id := originConn.ID()
 	metadata := &M.Metadata{
+		Device:  originConn.Device(),
 		Network: M.TCP,
 		SrcIP:   parseTCPIPAddress(id.RemoteAddress),
 		SrcPort: id.RemotePort,
@@ -29,13 +30,19 @@ func (t *Tunnel) handleTCPConn(originConn adapter.TCPConn) {
 	ctx, cancel := context.WithTimeout(context.Background(), tcpConnectTimeout)
 	defer cancel()
 
-	remoteConn, err := t.Proxy().DialContext(ctx, metadata)
+	start := time.Now()
+	remoteConn, err := t.dialTCP(ctx, metadata)
+	t.manager.ObserveDial(metadata, time.Since(start), err)
 	if err != nil {
 		log.Warnf("[TCP] dial %s: %v", metadata.DestinationAddress(), err)
 		return
 	}
 	metadata.MidIP, metadata.MidPort = parseNetAddr(remoteConn.LocalAddr())
 
+	if t.quota.Enabled() {
+		remoteConn = t.quota.Conn(remoteConn, metadata.SrcIP, metadata.Proxy)
+	}
+	remoteConn = t.shaper.Conn(remoteConn, metadata.SrcIP, metadata.Proxy)
 	remoteConn = statistic.NewTCPTracker(remoteConn, metadata, t.manager)
 	defer remoteConn.Close()
 
@@ -43,6 +50,14 @@ func (t *Tunnel) handleTCPConn(originConn adapter.TCPConn) {
 	pipe(originConn, remoteConn)
 }
 
+func (t *Tunnel) dialTCP(ctx context.Context, metadata *M.Metadata) (net.Conn, error) {
+	p, err := t.pickProxy(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return p.DialContext(ctx, metadata)
+}
+
 // pipe copies data to & from provided net.Conn(s) bidirectionally.
 func pipe(origin, remote net.Conn) {
 	wg := sync.WaitGroup{}
@@ -2,13 +2,18 @@ package tunnel
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
 	"go.uber.org/atomic"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
 
 	"github.com/xjasonlyu/tun2socks/v2/core/adapter"
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
 	"github.com/xjasonlyu/tun2socks/v2/proxy"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/quota"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel/shaper"
 	"github.com/xjasonlyu/tun2socks/v2/tunnel/statistic"
 )
 
@@ -21,7 +26,15 @@ const (
 	udpSessionTimeout = 60 * time.Second
 )
 
-var _ adapter.TransportHandler = (*Tunnel)(nil)
+// errQuotaExceeded is the error of sessions rejected for being over
+// quota.
+var errQuotaExceeded = fmt.Errorf("%w: quota exceeded", proxy.ErrRejected)
+
+var (
+	_ adapter.TransportHandler = (*Tunnel)(nil)
+	_ adapter.Drainer          = (*Tunnel)(nil)
+	_ adapter.Limiter          = (*Tunnel)(nil)
+)
 
 type Tunnel struct {
 	// Unbuffered TCP/UDP queues.
@@ -31,25 +44,60 @@ type Tunnel struct {
 	// UDP session timeout.
 	udpTimeout *atomic.Duration
 
-	// Internal proxy.Proxy for Tunnel.
-	proxyMu sync.RWMutex
-	proxy   proxy.Proxy
+	// UDP NAT type, and the endpoint-independent sessions
+	// keyed by device and source address, used by the cone
+	// NAT types.
+	udpNATType    *atomic.Uint32
+	udpSessionsMu sync.Mutex
+	udpSessions   map[udpSessionKey]*udpSession
+
+	// Whether ICMP echo requests are forwarded to real hosts, and
+	// the slots of the echoes being forwarded.
+	icmpForwarding *atomic.Bool
+	icmpEchoes     chan struct{}
+
+	// Whether new connections are refused, and the number of
+	// connections being handled.
+	draining *atomic.Bool
+	active   *atomic.Int64
+
+	// Sessions admitted within the limits.
+	sessions *sessionLimiter
+
+	// Internal proxy.Proxy for Tunnel, and the fallback one
+	// of the sessions over quota.
+	proxyMu  sync.RWMutex
+	proxy    proxy.Proxy
+	fallback proxy.Proxy
 
 	// Where the Tunnel statistics are sent to.
 	manager *statistic.Manager
 
+	// Bandwidth limits and traffic quotas of the connections.
+	shaper *shaper.Shaper
+	quota  *quota.Quota
+
 	procOnce   sync.Once
 	procCancel context.CancelFunc
 }
 
 func New(proxy proxy.Proxy, manager *statistic.Manager) *Tunnel {
 	return &Tunnel{
-		tcpQueue:   make(chan adapter.TCPConn),
-		udpQueue:   make(chan adapter.UDPConn),
-		udpTimeout: atomic.NewDuration(udpSessionTimeout),
-		proxy:      proxy,
-		manager:    manager,
-		procCancel: func() { /* nop */ },
+		tcpQueue:       make(chan adapter.TCPConn),
+		udpQueue:       make(chan adapter.UDPConn),
+		udpTimeout:     atomic.NewDuration(udpSessionTimeout),
+		udpNATType:     atomic.NewUint32(uint32(SymmetricNAT)),
+		udpSessions:    make(map[udpSessionKey]*udpSession),
+		icmpForwarding: atomic.NewBool(false),
+		icmpEchoes:     make(chan struct{}, maxICMPEchoes),
+		draining:       atomic.NewBool(false),
+		active:         atomic.NewInt64(0),
+		sessions:       newSessionLimiter(),
+		proxy:          proxy,
+		manager:        manager,
+		shaper:         shaper.New(),
+		quota:          quota.New(),
+		procCancel:     func() { /* nop */ },
 	}
 }
 
@@ -75,9 +123,19 @@ func (t *Tunnel) process(ctx context.Context) {
 	for {
 		select {
 		case conn := <-t.tcpQueue:
-			go t.handleTCPConn(conn)
+			t.active.Inc()
+			go func() {
+				defer t.active.Dec()
+				defer t.Release(conn.NICID(), header.TCPProtocolNumber, conn.ID())
+				t.handleTCPConn(conn)
+			}()
 		case conn := <-t.udpQueue:
-			go t.handleUDPConn(conn)
+			t.active.Inc()
+			go func() {
+				defer t.active.Dec()
+				defer t.Release(conn.NICID(), header.UDPProtocolNumber, conn.ID())
+				t.handleUDPConn(conn)
+			}()
 		case <-ctx.Done():
 			return
 		}
@@ -111,6 +169,89 @@ func (t *Tunnel) SetProxy(proxy proxy.Proxy) {
 	t.proxyMu.Unlock()
 }
 
+// SetFallbackProxy sets the proxy of the sessions over quota with
+// quota.ActionFallback, which are rejected if it's nil.
+func (t *Tunnel) SetFallbackProxy(proxy proxy.Proxy) {
+	t.proxyMu.Lock()
+	t.fallback = proxy
+	t.proxyMu.Unlock()
+}
+
+// pickProxy returns the proxy of a new session of metadata, which is
+// the fallback one, or none, if it's over quota.
+func (t *Tunnel) pickProxy(metadata *M.Metadata) (proxy.Proxy, error) {
+	t.proxyMu.RLock()
+	p, fallback := t.proxy, t.fallback
+	t.proxyMu.RUnlock()
+
+	metadata.Proxy = proxyName(p)
+	if !t.quota.Enabled() || !t.quota.Exceeded(metadata.SrcIP, metadata.Proxy) {
+		return p, nil
+	}
+	switch t.quota.Config().Action {
+	case quota.ActionThrottle:
+		return p, nil
+	case quota.ActionFallback:
+		if fallback != nil && !t.quota.OutboundExceeded(proxyName(fallback)) {
+			metadata.Proxy = proxyName(fallback)
+			return fallback, nil
+		}
+	}
+	return nil, errQuotaExceeded
+}
+
+// proxyName returns the name of p reported with the connections,
+// if it has one.
+func proxyName(p proxy.Proxy) string {
+	if s, ok := p.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return ""
+}
+
+// Shaper returns the shaper limiting the bandwidth of the connections.
+func (t *Tunnel) Shaper() *shaper.Shaper {
+	return t.shaper
+}
+
+// Quota returns the quota of the traffic of the connections.
+func (t *Tunnel) Quota() *quota.Quota {
+	return t.quota
+}
+
+// SetUDPTimeout sets the timeout of new UDP sessions, or the default
+// one if timeout is not positive.
 func (t *Tunnel) SetUDPTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = udpSessionTimeout
+	}
 	t.udpTimeout.Store(timeout)
 }
+
+// SetICMPForwarding enables or disables forwarding ICMP echo requests
+// to their real destinations, instead of replying to them locally.
+func (t *Tunnel) SetICMPForwarding(v bool) {
+	t.icmpForwarding.Store(v)
+}
+
+// SetUDPNATType sets the NAT type of new UDP sessions.
+func (t *Tunnel) SetUDPNATType(n NATType) {
+	t.udpNATType.Store(uint32(n))
+}
+
+// SetDraining makes the stack refuse new TCP connections and UDP
+// sessions, or accept them again, while the existing ones go on.
+func (t *Tunnel) SetDraining(v bool) {
+	t.draining.Store(v)
+}
+
+// Draining implements adapter.Drainer.
+func (t *Tunnel) Draining() bool {
+	return t.draining.Load()
+}
+
+// Active returns the number of TCP connections and UDP sessions
+// being handled.
+func (t *Tunnel) Active() int64 {
+	return t.active.Load()
+}
@@ -1,53 +1,198 @@
 package tunnel
 
 import (
+	"errors"
 	"io"
 	"net"
+	"net/netip"
 	"sync"
 	"time"
 
+	"go.uber.org/atomic"
+
 	"github.com/xjasonlyu/tun2socks/v2/buffer"
 	"github.com/xjasonlyu/tun2socks/v2/core/adapter"
 	"github.com/xjasonlyu/tun2socks/v2/log"
 	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+	"github.com/xjasonlyu/tun2socks/v2/proxy"
 	"github.com/xjasonlyu/tun2socks/v2/tunnel/statistic"
 )
 
-// TODO: Port Restricted NAT support.
 func (t *Tunnel) handleUDPConn(uc adapter.UDPConn) {
+	switch natType := NATType(t.udpNATType.Load()); natType {
+	case PortRestrictedNAT, FullConeNAT:
+		t.handleConeUDPConn(uc, natType)
+	default:
+		t.handleSymmetricUDPConn(uc)
+	}
+}
+
+func (t *Tunnel) handleSymmetricUDPConn(uc adapter.UDPConn) {
 	defer uc.Close()
 
-	id := uc.ID()
-	metadata := &M.Metadata{
-		Network: M.UDP,
-		SrcIP:   parseTCPIPAddress(id.RemoteAddress),
-		SrcPort: id.RemotePort,
-		DstIP:   parseTCPIPAddress(id.LocalAddress),
-		DstPort: id.LocalPort,
-	}
+	metadata := newUDPMetadata(uc)
 
-	pc, err := t.Proxy().DialUDP(metadata)
+	start := time.Now()
+	pc, err := t.dialUDP(metadata)
+	t.manager.ObserveDial(metadata, time.Since(start), err)
 	if err != nil {
-		log.Warnf("[UDP] dial %s: %v", metadata.DestinationAddress(), err)
+		rejectUDPConn(uc, metadata, err)
 		return
 	}
 	metadata.MidIP, metadata.MidPort = parseNetAddr(pc.LocalAddr())
 
+	if t.quota.Enabled() {
+		pc = t.quota.PacketConn(pc, metadata.SrcIP, metadata.Proxy)
+	}
+	pc = t.shaper.PacketConn(pc, metadata.SrcIP, metadata.Proxy)
 	pc = statistic.NewUDPTracker(pc, metadata, t.manager)
 	defer pc.Close()
 
-	var remote net.Addr
-	if udpAddr := metadata.UDPAddr(); udpAddr != nil {
-		remote = udpAddr
-	} else {
-		remote = metadata.Addr()
-	}
+	remote := udpRemoteAddr(metadata)
 	pc = newSymmetricNATPacketConn(pc, metadata)
 
 	log.Infof("[UDP] %s <-> %s", metadata.SourceAddress(), metadata.DestinationAddress())
 	pipePacket(uc, pc, remote, t.udpTimeout.Load())
 }
 
+func (t *Tunnel) handleConeUDPConn(uc adapter.UDPConn, natType NATType) {
+	defer uc.Close()
+
+	metadata := newUDPMetadata(uc)
+
+	var s *udpSession
+	for {
+		var owner bool
+		s, owner = t.loadOrCreateUDPSession(udpSessionKey{metadata.Device, metadata.SourceAddrPort()}, natType)
+		if owner {
+			start := time.Now()
+			pc, err := t.dialUDP(metadata)
+			t.manager.ObserveDial(metadata, time.Since(start), err)
+			if err != nil {
+				t.deleteUDPSession(s)
+				s.fail(err)
+				rejectUDPConn(uc, metadata, err)
+				return
+			}
+			metadata.MidIP, metadata.MidPort = parseNetAddr(pc.LocalAddr())
+
+			if t.quota.Enabled() {
+				pc = t.quota.PacketConn(pc, metadata.SrcIP, metadata.Proxy)
+			}
+			pc = t.shaper.PacketConn(pc, metadata.SrcIP, metadata.Proxy)
+			// The session is tracked with the metadata of its origin,
+			// of which the destination is the first one only.
+			s.start(statistic.NewUDPTracker(pc, metadata, t.manager), uc)
+			// New connections of the source start another session once
+			// the origin is closed.
+			defer func() {
+				t.deleteUDPSession(s)
+				s.detach()
+			}()
+			go func() {
+				if err := s.relayInbound(); err != nil {
+					log.Debugf("[UDP] copy data for remote->origin: %v", err)
+				}
+				t.deleteUDPSession(s)
+				s.close()
+			}()
+		}
+
+		<-s.ready
+		if s.err != nil {
+			rejectUDPConn(uc, metadata, s.err)
+			return
+		}
+		if s.add(metadata.DestinationAddrPort(), uc) {
+			break
+		}
+		// The session was closed in the meantime, retry with a new one.
+	}
+	metadata.MidIP, metadata.MidPort = parseNetAddr(s.pc.LocalAddr())
+
+	log.Infof("[UDP] %s <-> %s (%s)", metadata.SourceAddress(), metadata.DestinationAddress(), natType)
+	if err := s.relayOutbound(uc, metadata.DestinationAddrPort(), udpRemoteAddr(metadata)); err != nil {
+		log.Debugf("[UDP] copy data for origin->remote: %v", err)
+	}
+}
+
+func (t *Tunnel) dialUDP(metadata *M.Metadata) (net.PacketConn, error) {
+	p, err := t.pickProxy(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return p.DialUDP(metadata)
+}
+
+// udpSessionKey identifies the source of a session. The same source
+// address may show up on different devices.
+type udpSessionKey struct {
+	device string
+	src    netip.AddrPort
+}
+
+// loadOrCreateUDPSession returns the session of key, creating a new one if
+// there is none. The owner of a newly created session must either start or
+// fail it.
+func (t *Tunnel) loadOrCreateUDPSession(key udpSessionKey, natType NATType) (s *udpSession, owner bool) {
+	t.udpSessionsMu.Lock()
+	defer t.udpSessionsMu.Unlock()
+
+	if s = t.udpSessions[key]; s != nil {
+		return s, false
+	}
+	s = newUDPSession(natType, t.udpTimeout.Load())
+	t.udpSessions[key] = s
+	return s, true
+}
+
+func (t *Tunnel) deleteUDPSession(s *udpSession) {
+	t.udpSessionsMu.Lock()
+	defer t.udpSessionsMu.Unlock()
+
+	for key, v := range t.udpSessions {
+		if v == s {
+			delete(t.udpSessions, key)
+			break
+		}
+	}
+}
+
+// rejectUDPConn tells the source of uc that its datagrams won't go anywhere,
+// so that the client fails fast instead of waiting for a timeout.
+func rejectUDPConn(uc adapter.UDPConn, metadata *M.Metadata, err error) {
+	code := adapter.PortUnreachable
+	if errors.Is(err, proxy.ErrRejected) {
+		code = adapter.AdminProhibited
+		log.Debugf("[UDP] reject %s <-> %s", metadata.SourceAddress(), metadata.DestinationAddress())
+	} else {
+		log.Warnf("[UDP] dial %s: %v", metadata.DestinationAddress(), err)
+	}
+
+	if err := uc.WriteUnreachable(code); err != nil {
+		log.Debugf("[UDP] write unreachable to %s: %v", metadata.SourceAddress(), err)
+	}
+}
+
+func newUDPMetadata(uc adapter.UDPConn) *M.Metadata {
+	id := uc.ID()
+	return &M.Metadata{
+		Device:  uc.Device(),
+		Network: M.UDP,
+		SrcIP:   parseTCPIPAddress(id.RemoteAddress),
+		SrcPort: id.RemotePort,
+		DstIP:   parseTCPIPAddress(id.LocalAddress),
+		DstPort: id.LocalPort,
+	}
+}
+
+func udpRemoteAddr(metadata *M.Metadata) net.Addr {
+	if udpAddr := metadata.UDPAddr(); udpAddr != nil {
+		return udpAddr
+	}
+	return metadata.Addr()
+}
+
 func pipePacket(origin, remote net.PacketConn, to net.Addr, timeout time.Duration) {
 	wg := sync.WaitGroup{}
 	wg.Add(2)
@@ -113,3 +258,193 @@ func (pc *symmetricNATPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
 		return n, from, err
 	}
 }
+
+// udpSession is an endpoint-independent UDP mapping, i.e. a single upstream
+// net.PacketConn shared by all the UDP connections from one source address.
+// It's tracked as a single connection, with the metadata of its origin,
+// i.e. the connection it's started by.
+type udpSession struct {
+	natType NATType
+	timeout time.Duration
+
+	// ready is closed once the upstream dial has finished,
+	// with either pc or err set.
+	ready chan struct{}
+	pc    net.PacketConn
+	err   error
+
+	mu     sync.Mutex
+	closed bool
+	origin adapter.UDPConn
+	conns  map[netip.AddrPort]adapter.UDPConn
+	peers  map[netip.AddrPort]struct{}
+
+	lastActive *atomic.Time
+}
+
+func newUDPSession(natType NATType, timeout time.Duration) *udpSession {
+	return &udpSession{
+		natType:    natType,
+		timeout:    timeout,
+		ready:      make(chan struct{}),
+		conns:      make(map[netip.AddrPort]adapter.UDPConn),
+		peers:      make(map[netip.AddrPort]struct{}),
+		lastActive: atomic.NewTime(time.Now()),
+	}
+}
+
+func (s *udpSession) start(pc net.PacketConn, origin adapter.UDPConn) {
+	s.pc, s.origin = pc, origin
+	close(s.ready)
+}
+
+func (s *udpSession) fail(err error) {
+	s.err = err
+	close(s.ready)
+}
+
+// add attaches uc, whose destination is dst, to the session. It
+// returns false if the session has already been closed.
+func (s *udpSession) add(dst netip.AddrPort, uc adapter.UDPConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.conns[dst] = uc
+	s.peers[dst] = struct{}{}
+	return true
+}
+
+func (s *udpSession) remove(dst netip.AddrPort, uc adapter.UDPConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns[dst] == uc {
+		delete(s.conns, dst)
+	}
+}
+
+// detach detaches the origin, which is being closed, from the session.
+// Packets from peers without connections are dropped from then on.
+func (s *udpSession) detach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.origin = nil
+}
+
+func (s *udpSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.pc.Close()
+	for _, uc := range s.conns {
+		uc.Close()
+	}
+}
+
+func (s *udpSession) touch() {
+	s.lastActive.Store(time.Now())
+}
+
+func (s *udpSession) idle() bool {
+	return time.Since(s.lastActive.Load()) >= s.timeout
+}
+
+// relayOutbound copies datagrams from uc to the upstream, addressed to
+// to, until the whole session has been idle for the timeout.
+func (s *udpSession) relayOutbound(uc adapter.UDPConn, dst netip.AddrPort, to net.Addr) error {
+	defer s.remove(dst, uc)
+
+	buf := buffer.Get(buffer.MaxSegmentSize)
+	defer buffer.Put(buf)
+
+	for {
+		uc.SetReadDeadline(time.Now().Add(s.timeout))
+		n, _, err := uc.ReadFrom(buf)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			if s.idle() {
+				return nil
+			}
+			continue
+		} else if err == io.EOF {
+			return nil /* ignore EOF */
+		} else if err != nil {
+			return err
+		}
+
+		s.touch()
+		if _, err = s.pc.WriteTo(buf[:n], to); err != nil {
+			return err
+		}
+	}
+}
+
+// relayInbound copies datagrams from the upstream back to the source,
+// until the whole session has been idle for the timeout.
+func (s *udpSession) relayInbound() error {
+	buf := buffer.Get(buffer.MaxSegmentSize)
+	defer buffer.Put(buf)
+
+	for {
+		s.pc.SetReadDeadline(time.Now().Add(s.timeout))
+		n, from, err := s.pc.ReadFrom(buf)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			if s.idle() {
+				return nil
+			}
+			continue
+		} else if err == io.EOF {
+			return nil /* ignore EOF */
+		} else if err != nil {
+			return err
+		}
+
+		ip, port := parseNetAddr(from)
+		peer := netip.AddrPortFrom(ip.Unmap(), port)
+
+		uc, bound, ok := s.lookup(peer)
+		if !ok {
+			log.Debugf("[UDP] %s NAT: drop packet from %s", s.natType, peer)
+			continue
+		}
+
+		s.touch()
+		if bound {
+			_, err = uc.WriteTo(buf[:n], nil)
+		} else {
+			// No connection in the stack is bound to the peer
+			// yet, so spoof it as the source of the reply.
+			_, err = uc.WriteFrom(buf[:n], peer)
+		}
+		if err != nil {
+			log.Debugf("[UDP] write packet from %s: %v", peer, err)
+		}
+	}
+}
+
+// lookup returns the connection bound to peer, or the origin if there is
+// none, and whether packets from the peer are allowed by the NAT type and
+// there is a connection to write them to.
+func (s *udpSession) lookup(peer netip.AddrPort) (uc adapter.UDPConn, bound, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if uc, ok := s.conns[peer]; ok {
+		return uc, true, true
+	}
+	if s.origin == nil {
+		return nil, false, false
+	}
+	if s.natType == FullConeNAT {
+		return s.origin, false, true
+	}
+	_, ok = s.peers[peer]
+	return s.origin, false, ok
+}